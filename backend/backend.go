@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package backend abstracts the service joining the device to external wifi
+// networks, NetworkManager or wpa_supplicant
+package backend
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/CanonicalLtd/UCWifiConnect/netman"
+	"github.com/CanonicalLtd/UCWifiConnect/utils"
+
+	"github.com/godbus/dbus"
+)
+
+// Backend names, as configured
+const (
+	// Auto selects NetworkManager if it is running, else wpa_supplicant
+	Auto           = "auto"
+	NetworkManager = "network-manager"
+	WpaSupplicant  = "wpa-supplicant"
+)
+
+// Network describes the network to join
+type Network struct {
+	Ssid       string
+	Passphrase string
+	// Hidden networks are joined without being scanned first, with Security
+	Hidden   bool
+	Security netman.Security
+	// Config optionally sets the IP configuration
+	Config *netman.NetworkConfig
+}
+
+// Backend scans for and joins external wifi networks with a wifi interface
+type Backend interface {
+	// Name returns the name of the backend, one of the backend names
+	Name() string
+	// WifiInterface returns the configured wifi interface if usable, else
+	// selects one
+	WifiInterface(configured string) (string, error)
+	// HwAddress returns the hardware address of the interface
+	HwAddress(iface string) (string, error)
+	// SetExclusion sets the access points to leave out of scan results
+	SetExclusion(e *netman.Exclusion)
+	// Scan scans for networks and returns them, strongest first
+	Scan(iface string) ([]netman.SSID, error)
+	// Connect joins the network and waits until connected
+	Connect(iface string, n Network) error
+	// Disconnect disconnects the interface from its network
+	Disconnect(iface string) error
+	// Connected returns true if the interface is connected to a network
+	Connected(iface string) bool
+	// Connectivity checks how far the device reaches through its
+	// connections, probing probeURL if set
+	Connectivity(probeURL string) netman.Connectivity
+	// Managed returns true if the interface is managed by the backend
+	Managed(iface string) bool
+	// SetManaged makes the backend manage the interface, or release it so
+	// that wifi-ap can put up its AP
+	SetManaged(iface string, managed bool) error
+}
+
+// networkManagerRunning returns true if NetworkManager is on the system bus
+func networkManagerRunning() bool {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return false
+	}
+	var has bool
+	err = conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, "org.freedesktop.NetworkManager").Store(&has)
+	return err == nil && has
+}
+
+// New returns the backend of passed name. Auto, or no name, selects
+// NetworkManager if it is running, else wpa_supplicant if its control
+// directory exists, else NetworkManager
+func New(name string) (Backend, error) {
+	switch name {
+	case NetworkManager:
+		return NewNM(netman.DefaultClient()), nil
+	case WpaSupplicant:
+		return NewWpa(DefaultCtrlDir), nil
+	case Auto, "":
+		if networkManagerRunning() {
+			return NewNM(netman.DefaultClient()), nil
+		}
+		if _, err := os.Stat(DefaultCtrlDir); err == nil {
+			return NewWpa(DefaultCtrlDir), nil
+		}
+		return NewNM(netman.DefaultClient()), nil
+	}
+	return nil, fmt.Errorf("unknown backend: %q", name)
+}
+
+// Default returns the configured backend, or the automatically selected one
+// if none or an unknown one is configured
+func Default() Backend {
+	b, err := New(utils.Backend.Read())
+	if err != nil {
+		fmt.Printf("== wifi-connect: %v, selecting one\n", err)
+		b, _ = New(Auto)
+	}
+	return b
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend
+
+import (
+	"fmt"
+
+	"github.com/CanonicalLtd/UCWifiConnect/netman"
+)
+
+// NM is the NetworkManager backend
+type NM struct {
+	c *netman.Client
+}
+
+// NewNM returns a NetworkManager backend using passed client
+func NewNM(c *netman.Client) *NM {
+	return &NM{c: c}
+}
+
+// Client returns the NetworkManager client, for features only NetworkManager
+// supports like saved profiles
+func (b *NM) Client() *netman.Client {
+	return b.c
+}
+
+// Name returns the name of the backend
+func (b *NM) Name() string {
+	return NetworkManager
+}
+
+// WifiInterface returns the configured interface if found, else an AP
+// capable one
+func (b *NM) WifiInterface(configured string) (string, error) {
+	return b.c.WifiInterface(configured)
+}
+
+// HwAddress returns the hardware address of the interface
+func (b *NM) HwAddress(iface string) (string, error) {
+	return b.c.HwAddress(iface)
+}
+
+// SetExclusion sets the access points to leave out of scan results
+func (b *NM) SetExclusion(e *netman.Exclusion) {
+	b.c.SetExclusion(e)
+}
+
+// Scan scans with the device of the interface and returns what it found
+func (b *NM) Scan(iface string) ([]netman.SSID, error) {
+	device, err := b.c.DeviceByInterface(iface)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.c.Scan([]string{device}, netman.ScanTimeout); err != nil {
+		fmt.Println("== wifi-connect: Error scanning:", err)
+	}
+	ssids, _, _, err := b.c.DeviceSsids(device)
+	return ssids, err
+}
+
+// Connect joins the network with the device of the interface, with the access
+// point found by its last scan
+func (b *NM) Connect(iface string, n Network) error {
+	device, err := b.c.DeviceByInterface(iface)
+	if err != nil {
+		return err
+	}
+	_, ap2device, ssid2ap, err := b.c.DeviceSsids(device)
+	if err != nil {
+		return err
+	}
+	if _, scanned := ssid2ap[n.Ssid]; n.Hidden || !scanned {
+		sec := n.Security
+		if !n.Hidden {
+			// as ConnectAp does for networks that were not scanned
+			sec = netman.SecurityWpaPsk
+			if n.Passphrase == "" {
+				sec = netman.SecurityOpen
+			}
+		}
+		return b.c.ConnectHiddenAp(n.Ssid, n.Passphrase, sec, device, n.Config)
+	}
+	return b.c.ConnectAp(n.Ssid, n.Passphrase, ap2device, ssid2ap, n.Config)
+}
+
+// wifiDevices returns the wifi devices known to NetworkManager
+func (b *NM) wifiDevices() ([]string, error) {
+	devices, err := b.c.GetDevices()
+	if err != nil {
+		return nil, err
+	}
+	return b.c.GetWifiDevices(devices)
+}
+
+// Disconnect disconnects the device of the interface
+func (b *NM) Disconnect(iface string) error {
+	device, err := b.c.DeviceByInterface(iface)
+	if err != nil {
+		return err
+	}
+	_, err = b.c.DisconnectWifi([]string{device})
+	return err
+}
+
+// Connected returns true if the device of the interface is connected
+func (b *NM) Connected(iface string) bool {
+	device, err := b.c.DeviceByInterface(iface)
+	if err != nil {
+		fmt.Println("== wifi-connect: Error getting the wifi device:", err)
+		return false
+	}
+	connected, err := b.c.ConnectedWifi([]string{device})
+	if err != nil {
+		fmt.Println("== wifi-connect: Error checking wifi connection:", err)
+	}
+	return connected
+}
+
+// Connectivity probes probeURL if set, else has NetworkManager check
+// connectivity. The default probe URL is used if NetworkManager checks are
+// disabled
+func (b *NM) Connectivity(probeURL string) netman.Connectivity {
+	if probeURL != "" {
+		return netman.Probe(probeURL)
+	}
+	c, err := b.c.CheckConnectivity()
+	if err != nil {
+		fmt.Println("== wifi-connect: Error checking connectivity:", err)
+	}
+	if c == netman.ConnectivityUnknown {
+		return netman.Probe(netman.DefaultProbeURL)
+	}
+	return c
+}
+
+// Managed returns true if NetworkManager manages the interface
+func (b *NM) Managed(iface string) bool {
+	devices, err := b.wifiDevices()
+	if err != nil {
+		return false
+	}
+	ifaces, err := b.c.WifisManaged(devices)
+	if err != nil {
+		return false
+	}
+	_, ok := ifaces[iface]
+	return ok
+}
+
+// SetManaged sets the interface managed or unmanaged by NetworkManager
+func (b *NM) SetManaged(iface string, managed bool) error {
+	devices, err := b.wifiDevices()
+	if err != nil {
+		return err
+	}
+	if _, err = b.c.SetIfaceManaged(iface, managed, devices); err != nil {
+		return err
+	}
+	if b.Managed(iface) != managed {
+		return fmt.Errorf("cannot set %s managed %v", iface, managed)
+	}
+	return nil
+}
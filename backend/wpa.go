@@ -0,0 +1,638 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/CanonicalLtd/UCWifiConnect/netman"
+)
+
+// Default wpa_supplicant paths
+const (
+	// DefaultCtrlDir is the directory of the per interface control sockets
+	DefaultCtrlDir = "/run/wpa_supplicant"
+	// DefaultGlobalCtrl is the global control socket, used to add and
+	// remove interfaces
+	DefaultGlobalCtrl = "/run/wpa_supplicant-global"
+	// DefaultConfig is the configuration file of added interfaces
+	DefaultConfig = "/etc/wpa_supplicant/wpa_supplicant.conf"
+)
+
+// sysClassNet is where the kernel lists the network interfaces
+var sysClassNet = "/sys/class/net"
+
+// ctrlReplyTimeout is the time given to wpa_supplicant to reply a command
+var ctrlReplyTimeout = 5 * time.Second
+
+// wpaScanTimeout is the time given to a scan to complete
+var wpaScanTimeout = netman.ScanTimeout
+
+// wpaConnectTimeout is the time given to a network to be connected
+var wpaConnectTimeout = 20 * time.Second
+
+// wpaNotFoundScans is the number of scans not finding the network after
+// which connecting is given up
+const wpaNotFoundScans = 3
+
+// ctrlCount makes the local control socket names unique
+var ctrlCount uint32
+
+// ctrlConn is a connection to a wpa_supplicant control socket
+type ctrlConn struct {
+	conn  *net.UnixConn
+	local string
+}
+
+// dialCtrl connects to the control socket at path. wpa_supplicant replies to
+// the address of the sender, so a local socket is bound too
+func dialCtrl(path string) (*ctrlConn, error) {
+	local := filepath.Join(os.TempDir(), fmt.Sprintf("wifi-connect-%d-%d", os.Getpid(), atomic.AddUint32(&ctrlCount, 1)))
+	os.Remove(local)
+	laddr := &net.UnixAddr{Name: local, Net: "unixgram"}
+	raddr := &net.UnixAddr{Name: path, Net: "unixgram"}
+	conn, err := net.DialUnix("unixgram", laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &ctrlConn{conn: conn, local: local}, nil
+}
+
+// Close closes the connection and removes the local socket
+func (c *ctrlConn) Close() {
+	c.conn.Close()
+	os.Remove(c.local)
+}
+
+// read returns the next message, command reply or event
+func (c *ctrlConn) read(deadline time.Time) (string, error) {
+	c.conn.SetReadDeadline(deadline)
+	buf := make([]byte, 65536)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// isEvent returns true for unsolicited event messages, prefixed with their
+// priority, eg. "<3>CTRL-EVENT-CONNECTED"
+func isEvent(msg string) bool {
+	return strings.HasPrefix(msg, "<")
+}
+
+// request sends cmd and returns its reply. Events received meanwhile are
+// dropped
+func (c *ctrlConn) request(cmd string) (string, error) {
+	_, err := c.conn.Write([]byte(cmd))
+	if err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(ctrlReplyTimeout)
+	for {
+		msg, err := c.read(deadline)
+		if err != nil {
+			return "", fmt.Errorf("no reply to %s: %v", strings.Fields(cmd)[0], err)
+		}
+		if !isEvent(msg) {
+			return msg, nil
+		}
+	}
+}
+
+// command sends cmd and returns an error unless it is replied with OK
+func (c *ctrlConn) command(cmd string) error {
+	reply, err := c.request(cmd)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(reply) != "OK" {
+		return fmt.Errorf("%s failed: %s", strings.Fields(cmd)[0], strings.TrimSpace(reply))
+	}
+	return nil
+}
+
+// event returns the next event, without its priority prefix
+func (c *ctrlConn) event(deadline time.Time) (string, error) {
+	for {
+		msg, err := c.read(deadline)
+		if err != nil {
+			return "", err
+		}
+		if isEvent(msg) {
+			if i := strings.Index(msg, ">"); i >= 0 {
+				msg = msg[i+1:]
+			}
+			return msg, nil
+		}
+	}
+}
+
+// Wpa is the wpa_supplicant backend, talking its control socket protocol
+type Wpa struct {
+	ctrlDir   string
+	global    string
+	config    string
+	exclusion *netman.Exclusion
+}
+
+// NewWpa returns a wpa_supplicant backend for the control sockets in ctrlDir
+func NewWpa(ctrlDir string) *Wpa {
+	return &Wpa{ctrlDir: ctrlDir, global: DefaultGlobalCtrl, config: DefaultConfig}
+}
+
+// dial connects to the control socket of iface
+func (b *Wpa) dial(iface string) (*ctrlConn, error) {
+	conn, err := dialCtrl(filepath.Join(b.ctrlDir, iface))
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to wpa_supplicant for %s: %v", iface, err)
+	}
+	return conn, nil
+}
+
+// Name returns the name of the backend
+func (b *Wpa) Name() string {
+	return WpaSupplicant
+}
+
+// wifiInterfaces returns the wireless network interfaces
+func wifiInterfaces() []string {
+	entries, err := ioutil.ReadDir(sysClassNet)
+	if err != nil {
+		return nil
+	}
+	var ifaces []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(sysClassNet, e.Name(), "wireless")); err == nil {
+			ifaces = append(ifaces, e.Name())
+		}
+	}
+	return ifaces
+}
+
+// WifiInterface returns the configured interface if it is a wifi one, else
+// the first wifi interface wpa_supplicant controls, if any, or the first
+// wifi interface
+func (b *Wpa) WifiInterface(configured string) (string, error) {
+	ifaces := wifiInterfaces()
+	if len(ifaces) == 0 {
+		return "", errors.New("no wifi interface found")
+	}
+	for _, iface := range ifaces {
+		if iface == configured {
+			return iface, nil
+		}
+	}
+	if configured != "" {
+		return "", fmt.Errorf("%s is not a wifi interface", configured)
+	}
+	for _, iface := range ifaces {
+		if _, err := os.Stat(filepath.Join(b.ctrlDir, iface)); err == nil {
+			return iface, nil
+		}
+	}
+	return ifaces[0], nil
+}
+
+// HwAddress returns the hardware address of the interface
+func (b *Wpa) HwAddress(iface string) (string, error) {
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return "", err
+	}
+	if len(i.HardwareAddr) == 0 {
+		return "", fmt.Errorf("%s has no hardware address", iface)
+	}
+	return i.HardwareAddr.String(), nil
+}
+
+// SetExclusion sets the access points to leave out of scan results
+func (b *Wpa) SetExclusion(e *netman.Exclusion) {
+	b.exclusion = e
+}
+
+// unescapeSsid decodes an ssid as escaped by wpa_supplicant in scan results
+// and network lists
+func unescapeSsid(s string) string {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'e':
+			out = append(out, 0x1b)
+		case 'x':
+			if i+2 < len(s) {
+				if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+					out = append(out, byte(v))
+					i += 2
+					continue
+				}
+			}
+			out = append(out, '\\', 'x')
+		default:
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+// flagsSecurity returns the security class from the scan result flags, eg.
+// "[WPA2-PSK-CCMP][ESS]". WPA3 transition networks are joined with WPA-PSK,
+// like with NetworkManager
+func flagsSecurity(flags string) netman.Security {
+	switch {
+	case strings.Contains(flags, "-EAP"):
+		return netman.SecurityEnterprise
+	case strings.Contains(flags, "-PSK"):
+		return netman.SecurityWpaPsk
+	case strings.Contains(flags, "-SAE"):
+		return netman.SecuritySae
+	case strings.Contains(flags, "-OWE"):
+		return netman.SecurityOwe
+	case strings.Contains(flags, "[WEP]"):
+		return netman.SecurityWep
+	}
+	return netman.SecurityOpen
+}
+
+// signalStrength converts a signal level in dBm to a quality in percent,
+// the way NetworkManager does
+func signalStrength(dbm int) uint8 {
+	q := 2 * (dbm + 100)
+	switch {
+	case q < 0:
+		return 0
+	case q > 100:
+		return 100
+	}
+	return uint8(q)
+}
+
+// parseScanResults parses the SCAN_RESULTS reply, one access point per line
+// after the header: bssid, frequency, signal level, flags and ssid separated
+// by tabs
+func parseScanResults(reply string) []netman.SSID {
+	var aps []netman.SSID
+	lines := strings.Split(reply, "\n")
+	for _, line := range lines[1:] {
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) < 5 || fields[4] == "" {
+			continue
+		}
+		freq, _ := strconv.ParseUint(fields[1], 10, 32)
+		dbm, _ := strconv.Atoi(fields[2])
+		ap := netman.SSID{
+			Ssid:      unescapeSsid(fields[4]),
+			ApPath:    fields[0],
+			Bssid:     fields[0],
+			Security:  flagsSecurity(fields[3]),
+			Strength:  signalStrength(dbm),
+			Frequency: uint32(freq),
+			Channel:   netman.FrequencyToChannel(uint32(freq)),
+			LastSeen:  -1,
+		}
+		aps = append(aps, ap)
+	}
+	return aps
+}
+
+// scanResults returns the networks found by the last scan, strongest first
+func (b *Wpa) scanResults(conn *ctrlConn) ([]netman.SSID, error) {
+	reply, err := conn.request("SCAN_RESULTS")
+	if err != nil {
+		return nil, err
+	}
+	var aps []netman.SSID
+	for _, ap := range parseScanResults(reply) {
+		if !b.exclusion.Excludes(ap) {
+			aps = append(aps, ap)
+		}
+	}
+	ssids := netman.GroupNetworks(aps)
+	netman.SortBySignal(ssids)
+	return ssids, nil
+}
+
+// Scan requests a scan and waits for its results, returning the cached ones
+// if it does not complete in time
+func (b *Wpa) Scan(iface string) ([]netman.SSID, error) {
+	conn, err := b.dial(iface)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// attach before requesting so that the scan completion is not missed
+	err = conn.command("ATTACH")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.command("DETACH")
+	reply, err := conn.request("SCAN")
+	if err != nil {
+		return nil, err
+	}
+	// a busy supplicant is already scanning, its results are as good
+	if r := strings.TrimSpace(reply); r != "OK" && r != "FAIL-BUSY" {
+		return nil, fmt.Errorf("SCAN failed: %s", r)
+	}
+	deadline := time.Now().Add(wpaScanTimeout)
+	for {
+		e, err := conn.event(deadline)
+		if err != nil {
+			fmt.Println("== wifi-connect: Scan did not complete, using previous results:", err)
+			break
+		}
+		if strings.HasPrefix(e, "CTRL-EVENT-SCAN-RESULTS") {
+			break
+		}
+	}
+	return b.scanResults(conn)
+}
+
+// quote returns s as a quoted network setting value
+func quote(s string) string {
+	return "\"" + s + "\""
+}
+
+// isHex returns true if s is hexadecimal
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// networkSettings returns the network block settings to join a network with
+// passed security and passphrase
+func networkSettings(sec netman.Security, p string) ([][2]string, error) {
+	switch sec {
+	case netman.SecurityOpen:
+		return [][2]string{{"key_mgmt", "NONE"}}, nil
+	case netman.SecurityOwe:
+		return [][2]string{{"key_mgmt", "OWE"}, {"ieee80211w", "2"}}, nil
+	case netman.SecurityWep:
+		key := quote(p)
+		// 40 and 104 bit hex keys are set as is
+		if (len(p) == 10 || len(p) == 26) && isHex(p) {
+			key = p
+		}
+		return [][2]string{{"key_mgmt", "NONE"}, {"wep_key0", key}, {"wep_tx_keyidx", "0"}}, nil
+	case netman.SecurityWpaPsk:
+		if len(p) == 64 && isHex(p) {
+			return [][2]string{{"key_mgmt", "WPA-PSK"}, {"psk", p}}, nil
+		}
+		if len(p) < 8 || len(p) > 63 {
+			return nil, errors.New("a WPA passphrase must be 8 to 63 characters long")
+		}
+		return [][2]string{{"key_mgmt", "WPA-PSK"}, {"psk", quote(p)}}, nil
+	case netman.SecuritySae:
+		if p == "" {
+			return nil, errors.New("a passphrase is required for SAE")
+		}
+		return [][2]string{{"key_mgmt", "SAE"}, {"sae_password", quote(p)}, {"ieee80211w", "2"}}, nil
+	}
+	return nil, fmt.Errorf("%v networks are not supported with wpa_supplicant", sec)
+}
+
+// networkIDs returns the ids of the configured networks for ssid
+func (b *Wpa) networkIDs(conn *ctrlConn, ssid string) []string {
+	reply, err := conn.request("LIST_NETWORKS")
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	lines := strings.Split(reply, "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || unescapeSsid(fields[1]) != ssid {
+			continue
+		}
+		ids = append(ids, fields[0])
+	}
+	return ids
+}
+
+// removeNetworks removes the configured networks of passed ids
+func (b *Wpa) removeNetworks(conn *ctrlConn, ids []string) {
+	for _, id := range ids {
+		err := conn.command("REMOVE_NETWORK " + id)
+		if err != nil {
+			fmt.Printf("== wifi-connect: Error removing network %s: %v\n", id, err)
+		}
+	}
+}
+
+// waitConnected waits until the selected network is connected. The reason it
+// fails is returned as one of the netman Err* values
+func waitConnected(conn *ctrlConn) error {
+	deadline := time.Now().Add(wpaConnectTimeout)
+	notFound := 0
+	for {
+		e, err := conn.event(deadline)
+		if err != nil {
+			return netman.ErrTimeout
+		}
+		switch {
+		case strings.HasPrefix(e, "CTRL-EVENT-CONNECTED"):
+			return nil
+		case strings.HasPrefix(e, "CTRL-EVENT-SSID-TEMP-DISABLED") && strings.Contains(e, "reason=WRONG_KEY"):
+			return netman.ErrBadSecret
+		case strings.HasPrefix(e, "CTRL-EVENT-SSID-TEMP-DISABLED"):
+			return netman.ErrConnectFailed
+		case strings.HasPrefix(e, "CTRL-EVENT-NETWORK-NOT-FOUND"):
+			notFound++
+			if notFound >= wpaNotFoundScans {
+				return netman.ErrSsidNotFound
+			}
+		}
+	}
+}
+
+// Connect adds a network for n and selects it, then replaces the ones
+// previously configured for its ssid if it connected. The security is the one of the last scan
+// results, a network not found there is joined as hidden
+func (b *Wpa) Connect(iface string, n Network) error {
+	if n.Config != nil && (n.Config.IPv4 != nil || n.Config.IPv6 != nil) {
+		return errors.New("IP configuration is not supported with wpa_supplicant")
+	}
+	conn, err := b.dial(iface)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.command("ATTACH")
+	if err != nil {
+		return err
+	}
+	defer conn.command("DETACH")
+
+	// the network is configured with the ssid as scanned, n.Ssid may be
+	// without its surrounding spaces
+	ssid, sec, hidden := n.Ssid, n.Security, n.Hidden
+	if !hidden {
+		ssids, err := b.scanResults(conn)
+		if err != nil {
+			return err
+		}
+		hidden = true
+		sec = netman.SecurityWpaPsk
+		if n.Passphrase == "" {
+			sec = netman.SecurityOpen
+		}
+		for _, s := range ssids {
+			if strings.TrimSpace(s.Ssid) == strings.TrimSpace(n.Ssid) {
+				hidden = false
+				ssid = s.Ssid
+				sec = s.Security
+				break
+			}
+		}
+	}
+	settings, err := networkSettings(sec, n.Passphrase)
+	if err != nil {
+		return err
+	}
+
+	// the networks configured for ssid are only replaced once the new one
+	// works
+	previous := b.networkIDs(conn, ssid)
+	reply, err := conn.request("ADD_NETWORK")
+	if err != nil {
+		return err
+	}
+	id := strings.TrimSpace(reply)
+	if _, err := strconv.Atoi(id); err != nil {
+		return fmt.Errorf("ADD_NETWORK failed: %s", id)
+	}
+	settings = append([][2]string{{"ssid", hex.EncodeToString([]byte(ssid))}}, settings...)
+	if hidden {
+		settings = append(settings, [2]string{"scan_ssid", "1"})
+	}
+	for _, s := range settings {
+		err = conn.command("SET_NETWORK " + id + " " + s[0] + " " + s[1])
+		if err != nil {
+			conn.command("REMOVE_NETWORK " + id)
+			return err
+		}
+	}
+	err = conn.command("SELECT_NETWORK " + id)
+	if err == nil {
+		err = waitConnected(conn)
+	}
+	if err != nil {
+		fmt.Printf("== wifi-connect: Cannot connect to %s: %v\n", n.Ssid, err)
+		conn.command("REMOVE_NETWORK " + id)
+		// selecting disabled the other networks, let them connect again
+		conn.command("ENABLE_NETWORK all")
+		return err
+	}
+	b.removeNetworks(conn, previous)
+	// the configuration may not be writable, the network is kept until
+	// wpa_supplicant restarts then
+	if err := conn.command("SAVE_CONFIG"); err != nil {
+		fmt.Println("== wifi-connect: Error saving wpa_supplicant configuration:", err)
+	}
+	return nil
+}
+
+// Disconnect disconnects the interface, it is not reconnected until a network
+// is selected again
+func (b *Wpa) Disconnect(iface string) error {
+	conn, err := b.dial(iface)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.command("DISCONNECT")
+}
+
+// Connected returns true if wpa_supplicant completed joining a network
+func (b *Wpa) Connected(iface string) bool {
+	conn, err := b.dial(iface)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	reply, err := conn.request("STATUS")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(reply, "\n") {
+		if line == "wpa_state=COMPLETED" {
+			return true
+		}
+	}
+	return false
+}
+
+// Connectivity probes probeURL, or the default one, as wpa_supplicant does
+// not check connectivity
+func (b *Wpa) Connectivity(probeURL string) netman.Connectivity {
+	if probeURL == "" {
+		probeURL = netman.DefaultProbeURL
+	}
+	return netman.Probe(probeURL)
+}
+
+// Managed returns true if wpa_supplicant controls the interface
+func (b *Wpa) Managed(iface string) bool {
+	conn, err := b.dial(iface)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	reply, err := conn.request("PING")
+	return err == nil && strings.TrimSpace(reply) == "PONG"
+}
+
+// SetManaged adds the interface to wpa_supplicant, or removes it, through
+// the global control socket
+func (b *Wpa) SetManaged(iface string, managed bool) error {
+	if b.Managed(iface) == managed {
+		return nil
+	}
+	conn, err := dialCtrl(b.global)
+	if err != nil {
+		return fmt.Errorf("cannot connect to wpa_supplicant: %v", err)
+	}
+	defer conn.Close()
+	if !managed {
+		return conn.command("INTERFACE_REMOVE " + iface)
+	}
+	// fields are the interface, configuration file, driver and control
+	// interface
+	return conn.command("INTERFACE_ADD " + iface + "\t" + b.config + "\t\t" + b.ctrlDir)
+}
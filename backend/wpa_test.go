@@ -0,0 +1,302 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/UCWifiConnect/netman"
+)
+
+// fakeSupplicant serves a wpa_supplicant control socket. Commands are
+// replied from replies, by full command or by command name, else with OK,
+// and the events queued for them are sent afterwards
+type fakeSupplicant struct {
+	conn     *net.UnixConn
+	mu       sync.Mutex
+	requests []string
+	replies  map[string]string
+	events   map[string][]string
+}
+
+func newFakeSupplicant(t *testing.T, path string) *fakeSupplicant {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Cannot listen on %s: %v", path, err)
+	}
+	f := &fakeSupplicant{
+		conn:    conn,
+		replies: make(map[string]string),
+		events:  make(map[string][]string),
+	}
+	go f.serve()
+	return f
+}
+
+func (f *fakeSupplicant) serve() {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := f.conn.ReadFromUnix(buf)
+		if err != nil {
+			return
+		}
+		cmd := string(buf[:n])
+		name := strings.Fields(cmd)[0]
+		f.mu.Lock()
+		f.requests = append(f.requests, cmd)
+		reply, ok := f.replies[cmd]
+		if !ok {
+			reply, ok = f.replies[name]
+		}
+		if !ok {
+			reply = "OK\n"
+		}
+		events, ok := f.events[cmd]
+		if !ok {
+			events = f.events[name]
+		}
+		f.mu.Unlock()
+		f.conn.WriteToUnix([]byte(reply), addr)
+		for _, e := range events {
+			f.conn.WriteToUnix([]byte("<3>"+e), addr)
+		}
+	}
+}
+
+// reply sets the reply to cmd, and the events sent after it
+func (f *fakeSupplicant) reply(cmd string, reply string, events ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies[cmd] = reply
+	f.events[cmd] = events
+}
+
+// received returns true if cmd was sent to the supplicant
+func (f *fakeSupplicant) received(cmd string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.requests {
+		if r == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// log returns the commands sent to the supplicant
+func (f *fakeSupplicant) log() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+func (f *fakeSupplicant) Close() {
+	f.conn.Close()
+}
+
+const scanResults = "bssid / frequency / signal level / flags / ssid\n" +
+	"00:11:22:33:44:01\t2412\t-80\t[WPA2-PSK-CCMP][ESS]\thome\n" +
+	"00:11:22:33:44:02\t5180\t-50\t[WPA2-PSK-CCMP][ESS]\thome\n" +
+	"00:11:22:33:44:03\t2437\t-60\t[ESS]\tcaf\\xc3\\xa9\n" +
+	"00:11:22:33:44:04\t2462\t-40\t[WPA2-PSK+SAE-CCMP][ESS]\tUbuntu\n" +
+	"00:11:22:33:44:05\t2462\t-70\t[WPA2-EAP-CCMP][ESS]\t\n"
+
+func newFakeWpa(t *testing.T) (*Wpa, *fakeSupplicant) {
+	dir, err := ioutil.TempDir("", "wpa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFakeSupplicant(t, filepath.Join(dir, "wlan0"))
+	f.reply("SCAN_RESULTS", scanResults)
+	b := NewWpa(dir)
+	b.global = filepath.Join(dir, "global")
+	return b, f
+}
+
+func TestParseScanResults(t *testing.T) {
+	aps := parseScanResults(scanResults)
+	if len(aps) != 4 {
+		t.Fatalf("Hidden access point should have been skipped, got: %v", aps)
+	}
+	if aps[1].Bssid != "00:11:22:33:44:02" || aps[1].Strength != 100 || aps[1].Channel != 36 || aps[1].Security != netman.SecurityWpaPsk {
+		t.Errorf("Unexpected access point: %v", aps[1])
+	}
+	if aps[2].Ssid != "café" || aps[2].Security != netman.SecurityOpen || aps[2].Strength != 80 {
+		t.Errorf("Unexpected access point: %v", aps[2])
+	}
+	if aps[3].Security != netman.SecurityWpaPsk {
+		t.Errorf("Transition network should be joined with WPA-PSK: %v", aps[3])
+	}
+}
+
+func TestWpaScan(t *testing.T) {
+	b, f := newFakeWpa(t)
+	defer os.RemoveAll(b.ctrlDir)
+	defer f.Close()
+	f.reply("SCAN", "OK\n", "CTRL-EVENT-SCAN-STARTED ", "CTRL-EVENT-SCAN-RESULTS ")
+	b.SetExclusion(&netman.Exclusion{Ssids: []string{"Ubuntu"}})
+	ssids, err := b.Scan("wlan0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(ssids) != 2 || ssids[0].Ssid != "home" || len(ssids[0].BSSes) != 2 || ssids[1].Ssid != "café" {
+		t.Errorf("Unexpected networks: %v", ssids)
+	}
+	if !f.received("ATTACH") || !f.received("SCAN") {
+		t.Errorf("Scan should have been requested: %v", f.log())
+	}
+	if _, err := b.Scan("wlan1"); err == nil {
+		t.Errorf("Scanning with an interface without supplicant should fail")
+	}
+}
+
+func TestWpaConnect(t *testing.T) {
+	b, f := newFakeWpa(t)
+	defer os.RemoveAll(b.ctrlDir)
+	defer f.Close()
+	f.reply("LIST_NETWORKS", "network id / ssid / bssid / flags\n0\thome\tany\t[DISABLED]\n2\toffice\tany\t\n")
+	f.reply("ADD_NETWORK", "1\n")
+	f.reply("SELECT_NETWORK 1", "OK\n", "CTRL-EVENT-CONNECTED - Connection to 00:11:22:33:44:02 completed")
+	err := b.Connect("wlan0", Network{Ssid: "home", Passphrase: "secret12"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, cmd := range []string{"REMOVE_NETWORK 0", "SET_NETWORK 1 ssid 686f6d65", "SET_NETWORK 1 key_mgmt WPA-PSK",
+		"SET_NETWORK 1 psk \"secret12\"", "SELECT_NETWORK 1", "SAVE_CONFIG"} {
+		if !f.received(cmd) {
+			t.Errorf("%s should have been sent: %v", cmd, f.log())
+		}
+	}
+	if f.received("REMOVE_NETWORK 2") || f.received("SET_NETWORK 1 scan_ssid 1") {
+		t.Errorf("Only the home network should have been replaced: %v", f.log())
+	}
+
+	// a network not scanned is joined as hidden
+	err = b.Connect("wlan0", Network{Ssid: "lab", Passphrase: "secret12", Hidden: true, Security: netman.SecuritySae})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !f.received("SET_NETWORK 1 key_mgmt SAE") || !f.received("SET_NETWORK 1 scan_ssid 1") {
+		t.Errorf("Hidden SAE network should have been added: %v", f.log())
+	}
+
+	// the network is configured with the ssid as scanned
+	f.reply("SCAN_RESULTS", "bssid / frequency / signal level / flags / ssid\n00:11:22:33:44:06\t2412\t-60\t[ESS]\t cafe \n")
+	if err = b.Connect("wlan0", Network{Ssid: "cafe"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !f.received("SET_NETWORK 1 ssid 206361666520") || !f.received("SET_NETWORK 1 key_mgmt NONE") {
+		t.Errorf("The scanned open network should have been added: %v", f.log())
+	}
+
+	cfg := &netman.NetworkConfig{IPv4: &netman.IPConfig{Method: netman.IPMethodManual}}
+	if err = b.Connect("wlan0", Network{Ssid: "home", Passphrase: "secret12", Config: cfg}); err == nil {
+		t.Errorf("IP configuration should not be supported")
+	}
+}
+
+func TestWpaConnectFailed(t *testing.T) {
+	b, f := newFakeWpa(t)
+	defer os.RemoveAll(b.ctrlDir)
+	defer f.Close()
+	f.reply("LIST_NETWORKS", "network id / ssid / bssid / flags\n0\thome\tany\t\n")
+	f.reply("ADD_NETWORK", "1\n")
+	f.reply("SELECT_NETWORK 1", "OK\n", "CTRL-EVENT-SSID-TEMP-DISABLED id=1 ssid=\"home\" auth_failures=1 duration=10 reason=WRONG_KEY")
+	err := b.Connect("wlan0", Network{Ssid: "home", Passphrase: "secret12"})
+	if err != netman.ErrBadSecret {
+		t.Errorf("Expected a bad secret error, got: %v", err)
+	}
+	if !f.received("REMOVE_NETWORK 1") || f.received("SAVE_CONFIG") {
+		t.Errorf("Failed network should have been removed: %v", f.log())
+	}
+	if f.received("REMOVE_NETWORK 0") || !f.received("ENABLE_NETWORK all") {
+		t.Errorf("The previous network of the ssid should have been kept: %v", f.log())
+	}
+
+	wpaConnectTimeout = 50 * time.Millisecond
+	defer func() { wpaConnectTimeout = 20 * time.Second }()
+	f.reply("SELECT_NETWORK 1", "OK\n")
+	err = b.Connect("wlan0", Network{Ssid: "home", Passphrase: "secret12"})
+	if err != netman.ErrTimeout {
+		t.Errorf("Expected a timeout error, got: %v", err)
+	}
+	if err = b.Connect("wlan0", Network{Ssid: "home", Passphrase: "short"}); err == nil {
+		t.Errorf("Too short passphrase should fail")
+	}
+}
+
+func TestWpaState(t *testing.T) {
+	b, f := newFakeWpa(t)
+	defer os.RemoveAll(b.ctrlDir)
+	defer f.Close()
+	global := newFakeSupplicant(t, b.global)
+	defer global.Close()
+	f.reply("PING", "PONG\n")
+	f.reply("STATUS", "bssid=00:11:22:33:44:02\nssid=home\nwpa_state=COMPLETED\naddress=00:11:22:33:44:55\n")
+	if !b.Connected("wlan0") || !b.Managed("wlan0") {
+		t.Errorf("wlan0 should be managed and connected")
+	}
+	if b.Connected("wlan1") || b.Managed("wlan1") {
+		t.Errorf("wlan1 should not be managed nor connected")
+	}
+	if err := b.Disconnect("wlan0"); err != nil || !f.received("DISCONNECT") {
+		t.Errorf("wlan0 should have been disconnected: %v", err)
+	}
+	if err := b.SetManaged("wlan1", true); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !global.received("INTERFACE_ADD wlan1\t" + DefaultConfig + "\t\t" + b.ctrlDir) {
+		t.Errorf("wlan1 should have been added: %v", global.log())
+	}
+	if err := b.SetManaged("wlan0", false); err != nil || !global.received("INTERFACE_REMOVE wlan0") {
+		t.Errorf("wlan0 should have been removed: %v", err)
+	}
+}
+
+func TestWpaWifiInterface(t *testing.T) {
+	dir, err := ioutil.TempDir("", "net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"eth0", "wlan0/wireless", "wlan1/wireless"} {
+		os.MkdirAll(filepath.Join(dir, d), 0755)
+	}
+	sysClassNet = dir
+	defer func() { sysClassNet = "/sys/class/net" }()
+
+	b := NewWpa(filepath.Join(dir, "ctrl"))
+	os.MkdirAll(b.ctrlDir, 0755)
+	ioutil.WriteFile(filepath.Join(b.ctrlDir, "wlan1"), nil, 0644)
+	if iface, err := b.WifiInterface(""); err != nil || iface != "wlan1" {
+		t.Errorf("Interface with a supplicant should have been selected, got %q: %v", iface, err)
+	}
+	if iface, err := b.WifiInterface("wlan0"); err != nil || iface != "wlan0" {
+		t.Errorf("Configured interface should have been used, got %q: %v", iface, err)
+	}
+	if _, err := b.WifiInterface("eth0"); err == nil {
+		t.Errorf("Ethernet interface should not be usable")
+	}
+}
@@ -19,11 +19,18 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 
+	"github.com/CanonicalLtd/UCWifiConnect/backend"
+	"github.com/CanonicalLtd/UCWifiConnect/daemon"
 	"github.com/CanonicalLtd/UCWifiConnect/netman"
 	"github.com/CanonicalLtd/UCWifiConnect/server"
 	"github.com/CanonicalLtd/UCWifiConnect/utils"
@@ -45,6 +52,46 @@ Commands:
 	show-ap:		Show AP configuration
 	ssid VALUE: 		Set the AP ssid (causes AP restart if it is UP)
 	passphrase VALUE: 	Set the AP passphrase (cause AP restart if it is UP)
+	interface [VALUE]:	Show or set the wifi interface used for the AP and to
+				connect to external APs, which must support AP mode.
+				Use "auto" to select an AP capable one. Applied on
+				next start
+	policy [VALUE]:		Show or set the operating policy: "wifi" requires a wifi
+				connection, "ethernet" accepts ethernet or wifi and
+				"ethernet+wifi" requires both. Applied on next start
+	connectivity:		Check and show the internet connectivity: "full",
+				"limited", "portal" when a captive portal login is
+				required, or "none"
+	connectivity-check [VALUE]:
+				Show or set how connectivity is checked before being
+				operational: "auto" uses NetworkManager checks, or
+				probes the default URL, "off" disables checks, else an
+				URL answering 204 No Content to probe
+	roam-threshold [VALUE]:	Show or set the signal quality in percent, 1 to 100,
+				below which a stronger saved network is looked for
+				when operational. Use "off" to disable roaming or
+				"default" for the default threshold
+	backend [VALUE]:	Show or set the network backend: "network-manager",
+				"wpa-supplicant" or "auto" to use NetworkManager if
+				it is running. Applied on next start
+	deny-list [PATTERNS]:	Show or set the comma separated SSID patterns, eg.
+				"Ubuntu-*", hidden from the networks to connect to.
+				Use "clear" to remove them all
+	devices [--json]:	Show the network devices, their driver, state and wifi
+				capabilities, as a table or JSON
+	ip6-addresses [IFACE]:	Show the IPv6 addresses of IFACE, by default the wifi
+				interface
+	status [--json]:	Show the active wifi connection: access point, signal,
+				bitrate, frequency and IP configuration
+	connect-enterprise:	Connect to a WPA/WPA2-Enterprise (802.1X) network. Asks
+				for the SSID, the EAP method ("peap", "ttls" or
+				"tls"), the identity, an optional anonymous identity
+				and CA certificate, then the phase2 method and
+				password, or for "tls" the client certificate, private
+				key and its password, and the IP configuration
+	list-saved:		List saved wifi connection profiles
+	forget SSID:		Delete the saved profiles of SSID
+	set-priority SSID N:	Set the autoconnect priority of SSID profiles to N
 `
 	return text
 }
@@ -75,6 +122,130 @@ func operHandler() *mux.Router {
 	return router
 }
 
+// readNetworkConfig prompts for the optional IP configuration to join a
+// network with
+func readNetworkConfig(reader *bufio.Reader) (*netman.NetworkConfig, error) {
+	read := func(prompt string) string {
+		fmt.Print(prompt)
+		s, _ := reader.ReadString('\n')
+		return strings.TrimSpace(s)
+	}
+	if strings.ToLower(read("Configure IP settings? (y/N): ")) != "y" {
+		return nil, nil
+	}
+	method := read("Enter IPv4 method (auto, manual) [auto]: ")
+	addresses, gateway := "", ""
+	if strings.ToLower(method) == netman.IPMethodManual {
+		addresses = read("Enter IPv4 addresses, eg. 192.168.1.10/24 (comma separated): ")
+		gateway = read("Enter IPv4 gateway (optional): ")
+	}
+	dns := read("Enter IPv4 DNS servers (comma separated, optional): ")
+	search := read("Enter DNS search domains (comma separated, optional): ")
+	ipv4, err := netman.ParseIPConfig(method, addresses, gateway, dns, search)
+	if err != nil {
+		return nil, err
+	}
+
+	method = read("Enter IPv6 method (auto, dhcp, manual, ignore) [auto]: ")
+	addresses, gateway, dns = "", "", ""
+	if strings.ToLower(method) == netman.IPMethodManual {
+		addresses = read("Enter IPv6 addresses, eg. 2001:db8::10/64 (comma separated): ")
+		gateway = read("Enter IPv6 gateway (optional): ")
+	}
+	privacy := ""
+	if strings.ToLower(method) != netman.IPMethodIgnore {
+		dns = read("Enter IPv6 DNS servers (comma separated, optional): ")
+		privacy = read("Enter IPv6 privacy extensions (disabled, prefer-public, prefer-temporary, optional): ")
+	}
+	ipv6, err := netman.ParseIPv6Config(method, addresses, gateway, dns, "", privacy)
+	if err != nil {
+		return nil, err
+	}
+	return &netman.NetworkConfig{IPv4: ipv4, IPv6: ipv6}, nil
+}
+
+// networkBackend returns the configured network backend and the wifi
+// interface to use with it
+func networkBackend() (backend.Backend, string) {
+	b := backend.Default()
+	iface, err := b.WifiInterface(utils.Interface.Read())
+	if err != nil {
+		iface = daemon.GetClient().GetInterface()
+	}
+	return b, iface
+}
+
+// excludeOwnAp leaves the AP put up by wifi-ap and the deny-list out of the
+// scan results of b
+func excludeOwnAp(b backend.Backend, iface string) {
+	client := daemon.GetClient()
+	client.SetInterface(iface)
+	client.SetScanExclusion(b, wifiap.DefaultClient())
+}
+
+// wifiDevices returns the wifi devices known to NetworkManager
+func wifiDevices(c *netman.Client) ([]string, error) {
+	devices, err := c.GetDevices()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetWifiDevices(devices)
+}
+
+// printStatus prints the active connection status, one field per line
+func printStatus(s *netman.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Interface:\t%s\n", s.Interface)
+	fmt.Fprintf(w, "SSID:\t%s\n", s.Ssid)
+	fmt.Fprintf(w, "BSSID:\t%s\n", s.Bssid)
+	fmt.Fprintf(w, "Signal:\t%d%%\n", s.Strength)
+	fmt.Fprintf(w, "Bitrate:\t%d Mb/s\n", s.Bitrate/1000)
+	fmt.Fprintf(w, "Frequency:\t%d MHz\n", s.Frequency)
+	fmt.Fprintf(w, "Security:\t%s\n", s.Security)
+	for _, ip := range []struct {
+		name   string
+		status netman.IPStatus
+	}{{"IPv4", s.IPv4}, {"IPv6", s.IPv6}} {
+		fmt.Fprintf(w, "%s addresses:\t%s\n", ip.name, strings.Join(ip.status.Addresses, ", "))
+		fmt.Fprintf(w, "%s gateway:\t%s\n", ip.name, ip.status.Gateway)
+		fmt.Fprintf(w, "%s DNS:\t%s\n", ip.name, strings.Join(ip.status.DNS, ", "))
+	}
+	w.Flush()
+}
+
+// deviceJSON is a device as printed by the devices command
+type deviceJSON struct {
+	Interface    string   `json:"interface"`
+	Type         string   `json:"type"`
+	Driver       string   `json:"driver"`
+	HwAddress    string   `json:"hwaddress"`
+	State        string   `json:"state"`
+	Managed      bool     `json:"managed"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// printDevicesJSON prints devices as a JSON array
+func printDevicesJSON(devices []netman.Device) {
+	out := []deviceJSON{}
+	for _, d := range devices {
+		out = append(out, deviceJSON{
+			Interface:    d.Interface,
+			Type:         netman.DeviceTypeName(d.Type),
+			Driver:       d.Driver,
+			HwAddress:    d.HwAddress,
+			State:        netman.DeviceStateName(d.State),
+			Managed:      d.Managed,
+			Capabilities: d.Capabilities.Names(),
+		})
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println(string(b))
+}
+
 // checkSudo return false if the current user is not root, else true
 func checkSudo() bool {
 	if os.Geteuid() != 0 {
@@ -159,21 +330,250 @@ func main() {
 		}
 		wifiAPClient := wifiap.DefaultClient()
 		wifiAPClient.SetPassphrase(os.Args[2])
+	case "interface":
+		if len(os.Args) < 3 {
+			configured := utils.Interface.Read()
+			iface, err := backend.Default().WifiInterface(configured)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			if configured == "" {
+				fmt.Printf("%s (auto-selected)\n", iface)
+				return
+			}
+			fmt.Println(iface)
+			return
+		}
+		if !checkSudo() {
+			return
+		}
+		iface := os.Args[2]
+		if iface == "auto" {
+			iface = ""
+		}
+		if _, err := backend.Default().WifiInterface(iface); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		err := utils.Interface.Write(iface)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	case "policy":
+		if len(os.Args) < 3 {
+			policy := utils.Policy.Read()
+			if policy == "" {
+				policy = daemon.PolicyWifi
+			}
+			fmt.Println(policy)
+			return
+		}
+		if !checkSudo() {
+			return
+		}
+		if err := daemon.GetClient().SetPolicy(os.Args[2]); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		err := utils.Policy.Write(os.Args[2])
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	case "connectivity":
+		b, _ := networkBackend()
+		check := utils.ConnectivityCheck.Read()
+		if check == daemon.CheckOff || check == daemon.CheckAuto {
+			check = ""
+		}
+		fmt.Println(b.Connectivity(check))
+	case "connectivity-check":
+		if len(os.Args) < 3 {
+			check := utils.ConnectivityCheck.Read()
+			if check == "" {
+				check = daemon.CheckAuto
+			}
+			fmt.Println(check)
+			return
+		}
+		if !checkSudo() {
+			return
+		}
+		check := os.Args[2]
+		switch check {
+		case daemon.CheckAuto:
+			check = ""
+		case daemon.CheckOff:
+		default:
+			if u, err := url.Parse(check); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fmt.Printf("Error: invalid probe URL: %q\n", check)
+				return
+			}
+		}
+		err := utils.ConnectivityCheck.Write(check)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	case "roam-threshold":
+		if len(os.Args) < 3 {
+			if threshold, enabled := daemon.GetRoamThreshold(); enabled {
+				fmt.Println(threshold)
+			} else {
+				fmt.Println(daemon.RoamOff)
+			}
+			return
+		}
+		if !checkSudo() {
+			return
+		}
+		threshold := os.Args[2]
+		switch threshold {
+		case "default":
+			threshold = ""
+		case daemon.RoamOff:
+		default:
+			if v, err := strconv.Atoi(threshold); err != nil || v < 1 || v > 100 {
+				fmt.Printf("Error: invalid threshold: %q, use 1 to 100\n", threshold)
+				return
+			}
+		}
+		err := utils.RoamThreshold.Write(threshold)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	case "backend":
+		if len(os.Args) < 3 {
+			fmt.Println(backend.Default().Name())
+			return
+		}
+		if !checkSudo() {
+			return
+		}
+		name := os.Args[2]
+		switch name {
+		case backend.Auto:
+			name = ""
+		case backend.NetworkManager, backend.WpaSupplicant:
+		default:
+			fmt.Printf("Error: unknown backend: %q\n", name)
+			return
+		}
+		err := utils.Backend.Write(name)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	case "deny-list":
+		if len(os.Args) < 3 {
+			for _, p := range utils.DenyList.ReadList() {
+				fmt.Println(p)
+			}
+			return
+		}
+		if !checkSudo() {
+			return
+		}
+		var patterns []string
+		if os.Args[2] != "clear" {
+			for _, p := range strings.Split(os.Args[2], ",") {
+				if _, err := path.Match(p, ""); err != nil {
+					fmt.Printf("Error: invalid pattern %q\n", p)
+					return
+				}
+				patterns = append(patterns, p)
+			}
+		}
+		err := utils.DenyList.WriteList(patterns)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	case "ip6-addresses":
+		c := netman.DefaultClient()
+		iface := ""
+		if len(os.Args) > 2 {
+			iface = os.Args[2]
+		} else {
+			var err error
+			iface, err = c.WifiInterface(utils.Interface.Read())
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+		}
+		addresses, err := c.IP6Addresses(iface)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		for _, a := range addresses {
+			fmt.Println(a)
+		}
+	case "status":
+		c := netman.DefaultClient()
+		iface, err := c.WifiInterface(utils.Interface.Read())
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		status, err := c.Status(iface)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if len(os.Args) > 2 && os.Args[2] == "--json" {
+			b, err := json.MarshalIndent(status, "", "  ")
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			fmt.Println(string(b))
+			return
+		}
+		printStatus(status)
 	case "get-devices":
 		c := netman.DefaultClient()
-		devices := c.GetDevices()
-		for d := range devices {
+		devices, err := c.GetDevices()
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		for _, d := range devices {
 			fmt.Println(d)
 		}
 	case "get-wifi-devices":
 		c := netman.DefaultClient()
-		devices := c.GetWifiDevices(c.GetDevices())
-		for d := range devices {
+		devices, err := wifiDevices(c)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		for _, d := range devices {
 			fmt.Println(d)
 		}
+	case "devices":
+		devices, err := netman.DefaultClient().Devices()
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if len(os.Args) > 2 && os.Args[2] == "--json" {
+			printDevicesJSON(devices)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "INTERFACE\tTYPE\tDRIVER\tHWADDR\tSTATE\tMANAGED\tCAPABILITIES")
+		for _, d := range devices {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%s\n", d.Interface, netman.DeviceTypeName(d.Type), d.Driver, d.HwAddress,
+				netman.DeviceStateName(d.State), d.Managed, strings.Join(d.Capabilities.Names(), ","))
+		}
+		w.Flush()
 	case "get-ssids":
-		c := netman.DefaultClient()
-		SSIDs, _, _ := c.Ssids()
+		b, iface := networkBackend()
+		excludeOwnAp(b, iface)
+		SSIDs, err := b.Scan(iface)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
 		var out string
 		for _, ssid := range SSIDs {
 			out += strings.TrimSpace(ssid.Ssid) + ","
@@ -181,27 +581,52 @@ func main() {
 		if len(out) > 0 {
 			fmt.Printf("%s\n", out[:len(out)-1])
 		}
-	case "check-connected":
+	case "get-aps":
 		c := netman.DefaultClient()
-		if c.ConnectedWifi(c.GetWifiDevices(c.GetDevices())) {
+		iface, _ := c.WifiInterface(utils.Interface.Read())
+		excludeOwnAp(backend.NewNM(c), iface)
+		SSIDs, _, _, err := c.Ssids()
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "SSID\tBSSID\tSIGNAL\tFREQ\tCHAN\tRATE\tSECURITY")
+		for _, ssid := range SSIDs {
+			for _, bss := range ssid.BSSes {
+				fmt.Fprintf(w, "%s\t%s\t%d%%\t%d MHz\t%d\t%d Mb/s\t%v\n", strings.TrimSpace(bss.Ssid), bss.Bssid,
+					bss.Strength, bss.Frequency, bss.Channel, bss.MaxBitrate/1000, bss.Security)
+			}
+		}
+		w.Flush()
+	case "check-connected":
+		b, iface := networkBackend()
+		if b.Connected(iface) {
 			fmt.Println("Device is connected")
 		} else {
 			fmt.Println("Device is not connected")
 		}
 
 	case "check-connected-wifi":
-		c := netman.DefaultClient()
-		if c.ConnectedWifi(c.GetWifiDevices(c.GetDevices())) {
+		b, iface := networkBackend()
+		if b.Connected(iface) {
 			fmt.Println("Device is connected to external wifi AP")
 		} else {
 			fmt.Println("Device is not connected to external wifi AP")
 		}
 	case "disconnect-wifi":
-		c := netman.DefaultClient()
-		c.DisconnectWifi(c.GetWifiDevices(c.GetDevices()))
+		b, iface := networkBackend()
+		if err := b.Disconnect(iface); err != nil {
+			fmt.Println("Error:", err)
+		}
 	case "wifis-managed":
 		c := netman.DefaultClient()
-		wifis, err := c.WifisManaged(c.GetWifiDevices(c.GetDevices()))
+		devices, err := wifiDevices(c)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		wifis, err := c.WifisManaged(devices)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -214,29 +639,158 @@ func main() {
 			fmt.Println("Error: no interface provided")
 			return
 		}
-		c := netman.DefaultClient()
-		c.SetIfaceManaged(os.Args[2], true, c.GetWifiDevices(c.GetDevices()))
+		if err := backend.Default().SetManaged(os.Args[2], true); err != nil {
+			fmt.Println("Error:", err)
+		}
 	case "unmanage-iface":
 		if len(os.Args) < 3 {
 			fmt.Println("Error: no interface provided")
 			return
 		}
-		c := netman.DefaultClient()
-		c.SetIfaceManaged(os.Args[2], false, c.GetWifiDevices(c.GetDevices()))
+		if err := backend.Default().SetManaged(os.Args[2], false); err != nil {
+			fmt.Println("Error:", err)
+		}
 	case "connect":
-		c := netman.DefaultClient()
-		SSIDs, ap2device, ssid2ap := c.Ssids()
+		b, iface := networkBackend()
+		SSIDs, err := b.Scan(iface)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		scanned := make(map[string]bool)
 		for _, ssid := range SSIDs {
 			fmt.Printf("    %v\n", ssid.Ssid)
+			scanned[strings.TrimSpace(ssid.Ssid)] = true
 		}
 		reader := bufio.NewReader(os.Stdin)
 		fmt.Print("Connect to AP. Enter SSID: ")
 		ssid, _ := reader.ReadString('\n')
 		ssid = strings.TrimSpace(ssid)
-		fmt.Print("Enter phasprase: ")
+		if !scanned[ssid] {
+			fmt.Printf("%s not found, connecting to it as a hidden network\n", ssid)
+			fmt.Print("Enter security (open, wep, wpa-psk, sae): ")
+			s, _ := reader.ReadString('\n')
+			sec, err := netman.ParseSecurity(strings.TrimSpace(s))
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			pw := ""
+			if sec.NeedsPassphrase() {
+				fmt.Print("Enter passphrase: ")
+				pw, _ = reader.ReadString('\n')
+				pw = strings.TrimSpace(pw)
+			}
+			cfg, err := readNetworkConfig(reader)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			err = b.Connect(iface, backend.Network{Ssid: ssid, Passphrase: pw, Hidden: true, Security: sec, Config: cfg})
+			if err != nil {
+				fmt.Println("Error:", err)
+			}
+			return
+		}
+		fmt.Print("Enter passphrase: ")
 		pw, _ := reader.ReadString('\n')
 		pw = strings.TrimSpace(pw)
-		c.ConnectAp(ssid, pw, ap2device, ssid2ap)
+		cfg, err := readNetworkConfig(reader)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		err = b.Connect(iface, backend.Network{Ssid: ssid, Passphrase: pw, Config: cfg})
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	case "connect-enterprise":
+		c := netman.DefaultClient()
+		SSIDs, ap2device, ssid2ap, err := c.Ssids()
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		for _, ssid := range SSIDs {
+			fmt.Printf("    %v\n", ssid.Ssid)
+		}
+		reader := bufio.NewReader(os.Stdin)
+		read := func(prompt string) string {
+			fmt.Print(prompt)
+			s, _ := reader.ReadString('\n')
+			return strings.TrimSpace(s)
+		}
+		ssid := read("Connect to AP. Enter SSID: ")
+		creds := &netman.EnterpriseCredentials{}
+		creds.Eap = strings.ToLower(read("Enter EAP method (peap, ttls, tls): "))
+		creds.Identity = read("Enter identity: ")
+		creds.AnonymousIdentity = read("Enter anonymous identity (optional): ")
+		creds.CaCert = read("Enter CA certificate path (optional): ")
+		if creds.Eap == netman.EapTLS {
+			creds.ClientCert = read("Enter client certificate path: ")
+			creds.PrivateKey = read("Enter private key path: ")
+			creds.PrivateKeyPassword = read("Enter private key password (optional): ")
+		} else {
+			creds.Phase2Auth = read("Enter phase2 method (default mschapv2): ")
+			creds.Password = read("Enter password: ")
+		}
+		cfg, err := readNetworkConfig(reader)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		err = c.ConnectApEnterprise(ssid, creds, ap2device, ssid2ap, cfg)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	case "list-saved":
+		c := netman.DefaultClient()
+		profiles, err := c.SavedProfiles()
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "SSID\tNAME\tPRIORITY\tAUTOCONNECT\tHIDDEN")
+		for _, p := range profiles {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%t\t%t\n", p.Ssid, p.ID, p.Priority, p.Autoconnect, p.Hidden)
+		}
+		w.Flush()
+	case "forget":
+		if !checkSudo() {
+			return
+		}
+		if len(os.Args) < 3 {
+			fmt.Println("Error: no ssid provided")
+			return
+		}
+		c := netman.DefaultClient()
+		n, err := c.ForgetProfile(os.Args[2])
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Printf("%d profile(s) deleted\n", n)
+	case "set-priority":
+		if !checkSudo() {
+			return
+		}
+		if len(os.Args) < 4 {
+			fmt.Println("Error: no ssid or priority provided")
+			return
+		}
+		priority, err := strconv.ParseInt(os.Args[3], 10, 32)
+		if err != nil {
+			fmt.Println("Error: priority must be a number")
+			return
+		}
+		c := netman.DefaultClient()
+		n, err := c.SetProfilePriority(os.Args[2], int32(priority))
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Printf("%d profile(s) updated\n", n)
 	case "management":
 		http.ListenAndServe(":8081", mgmtHandler())
 	case "operational":
@@ -21,9 +21,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/CanonicalLtd/UCWifiConnect/avahi"
+	"github.com/CanonicalLtd/UCWifiConnect/backend"
 	"github.com/CanonicalLtd/UCWifiConnect/netman"
 	"github.com/CanonicalLtd/UCWifiConnect/server"
 	"github.com/CanonicalLtd/UCWifiConnect/utils"
@@ -36,13 +40,80 @@ const (
 	MANAGING
 	OPERATING
 	MANUAL
+	// PORTAL is connected, waiting for the user to log in to the captive
+	// portal of the network
+	PORTAL
 )
 
+// Operating policies, deciding which connections make the device OPERATING
+const (
+	// PolicyWifi requires a wifi connection, ethernet is not considered
+	PolicyWifi = "wifi"
+	// PolicyEthernet accepts either an ethernet or a wifi connection
+	PolicyEthernet = "ethernet"
+	// PolicyEthernetWifi requires both an ethernet and a wifi connection
+	PolicyEthernetWifi = "ethernet+wifi"
+)
+
+// Connectivity check settings, besides a probe URL
+const (
+	// CheckAuto has NetworkManager check connectivity if it can, else
+	// probes the default URL
+	CheckAuto = "auto"
+	// CheckOff disables checks, connected links are enough to be OPERATING
+	CheckOff = "off"
+)
+
+// Links reports which kind of links are connected
+type Links interface {
+	ConnectedWifi() bool
+	ConnectedEthernet() bool
+}
+
 var manualFlagPath string
 var waitFlagPath string
 var previousState = STARTING
 var state = STARTING
 
+// wifiIface is the wifi interface hosting the AP and managed by wifi-connect
+var wifiIface = "wlan0"
+
+var policy = PolicyWifi
+
+// ConnectivityInterval is how long a connectivity check result is reused
+var ConnectivityInterval = 60 * time.Second
+
+var connectivity = netman.ConnectivityUnknown
+var connectivityChecked time.Time
+
+// PortalTimeout is how long the user has to log in to a captive portal
+// before the AP is put up again
+var PortalTimeout = 10 * time.Minute
+
+var portalSince time.Time
+
+// RoamOff disables roaming, see Roam
+const RoamOff = "off"
+
+// Roaming hysteresis: the signal must stay below the threshold for
+// RoamSamples samples in a row, the new access point must be stronger by
+// RoamMargin and attempts are at least RoamHoldoff apart
+var (
+	// RoamThreshold is the default signal quality in percent below which a
+	// stronger network is looked for, 30% is about -85 dBm
+	RoamThreshold uint8 = 30
+	RoamSamples         = 3
+	RoamMargin    uint8 = 15
+	RoamHoldoff         = 5 * time.Minute
+	// SignalInterval is the time between signal samples
+	SignalInterval = 10 * time.Second
+)
+
+var signalsMu sync.Mutex
+var signals *netman.SignalMonitor
+var signalsIface string
+var lastRoam time.Time
+
 // Client is the base type for both testing and runtime
 type Client struct {
 }
@@ -95,19 +166,328 @@ func (c *Client) GetState() int {
 func (c *Client) SetState(i int) {
 	previousState = state
 	state = i
+	// the signal is only sampled while OPERATING
+	if i != OPERATING {
+		stopSignalMonitor()
+	}
+}
+
+// GetInterface returns the wifi interface in use
+func (c *Client) GetInterface() string {
+	return wifiIface
+}
+
+// SetInterface sets the wifi interface in use, also for the portals
+func (c *Client) SetInterface(iface string) {
+	wifiIface = iface
+	server.WifiInterface = iface
+}
+
+// SelectInterface sets the wifi interface to the configured one, or to an AP
+// capable one if none is configured or it is not found, and makes wifi-ap use
+// the same interface. If there is no AP capable interface, none is set and
+// the error is returned
+func (c *Client) SelectInterface(b backend.Backend, cw *wifiap.Client) error {
+	configured := utils.Interface.Read()
+	iface, err := b.WifiInterface(configured)
+	if err != nil && configured != "" {
+		fmt.Printf("== wifi-connect: Configured interface %s not usable, selecting one: %v\n", configured, err)
+		iface, err = b.WifiInterface("")
+	}
+	if err != nil {
+		c.SetInterface("")
+		return err
+	}
+	c.SetInterface(iface)
+	fmt.Println("== wifi-connect: Using wifi interface", wifiIface)
+
+	config, err := cw.Show()
+	if err != nil {
+		fmt.Println("== wifi-connect: Error getting wifi-ap configuration:", err)
+		return nil
+	}
+	if config["wifi.interface"] != wifiIface {
+		fmt.Printf("== wifi-connect: Setting wifi-ap interface to %s\n", wifiIface)
+		err = cw.SetInterface(wifiIface)
+		if err != nil {
+			fmt.Println("== wifi-connect: Error setting wifi-ap interface:", err)
+		}
+	}
+	return nil
+}
+
+// GetPolicy returns the operating policy
+func (c *Client) GetPolicy() string {
+	return policy
+}
+
+// SetPolicy sets the operating policy, one of the Policy* values
+func (c *Client) SetPolicy(p string) error {
+	switch p {
+	case PolicyWifi, PolicyEthernet, PolicyEthernetWifi:
+		policy = p
+		return nil
+	}
+	return fmt.Errorf("unknown operating policy: %q", p)
+}
+
+// LoadPolicy sets the configured operating policy, or the default one if
+// none or an unknown one is configured
+func (c *Client) LoadPolicy() {
+	configured := utils.Policy.Read()
+	if configured == "" {
+		configured = PolicyWifi
+	}
+	err := c.SetPolicy(configured)
+	if err != nil {
+		fmt.Printf("== wifi-connect: %v, using %s\n", err, PolicyWifi)
+		policy = PolicyWifi
+	}
+	fmt.Println("== wifi-connect: Using operating policy", policy)
+}
+
+// Operational returns true if the connected links satisfy the operating
+// policy, and the reason of the decision
+func (c *Client) Operational(links Links) (bool, string) {
+	wifi := links.ConnectedWifi()
+	ethernet := links.ConnectedEthernet()
+	switch {
+	case policy == PolicyEthernetWifi && wifi && ethernet:
+		return true, "ethernet and wifi are connected"
+	case policy == PolicyEthernetWifi && ethernet:
+		return false, "ethernet is connected but wifi is not"
+	case policy == PolicyEthernetWifi && wifi:
+		return false, "wifi is connected but ethernet is not"
+	case policy == PolicyEthernetWifi:
+		return false, "neither ethernet nor wifi are connected"
+	case wifi:
+		return true, "wifi is connected"
+	case policy == PolicyEthernet && ethernet:
+		return true, "ethernet is connected"
+	case policy == PolicyEthernet:
+		return false, "neither ethernet nor wifi are connected"
+	case ethernet:
+		return false, "wifi is not connected, ethernet is not considered"
+	}
+	return false, "wifi is not connected"
+}
+
+// GetConnectivity returns the result of the last connectivity check
+func (c *Client) GetConnectivity() netman.Connectivity {
+	return connectivity
+}
+
+// Online checks connectivity with b and returns true if the internet is
+// reachable, and the reason of the decision. The last result is reused for
+// ConnectivityInterval unless force is true
+func (c *Client) Online(b backend.Backend, force bool) (bool, string) {
+	check := utils.ConnectivityCheck.Read()
+	if check == CheckOff {
+		return true, "connectivity checks are disabled"
+	}
+	if check == CheckAuto {
+		check = ""
+	}
+	if force || time.Since(connectivityChecked) >= ConnectivityInterval {
+		connectivity = b.Connectivity(check)
+		connectivityChecked = time.Now()
+	}
+	switch connectivity {
+	case netman.ConnectivityFull:
+		return true, "the internet is reachable"
+	case netman.ConnectivityPortal:
+		return false, "a captive portal login is required to reach the internet"
+	case netman.ConnectivityLimited:
+		return false, "the internet is not reachable"
+	case netman.ConnectivityNone:
+		return false, "there is no network connection"
+	}
+	return false, "the internet connectivity is unknown"
+}
+
+// probeURL returns the URL probing connectivity, the configured one or the
+// default one
+func probeURL() string {
+	switch check := utils.ConnectivityCheck.Read(); check {
+	case "", CheckAuto, CheckOff:
+		return netman.DefaultProbeURL
+	default:
+		return check
+	}
+}
+
+// WaitPortalLogin returns true while the device should stay connected to a
+// network behind a captive portal, so that the user logs in through the
+// portals, and false once PortalTimeout expired. The login page is looked
+// up when the wait starts
+func (c *Client) WaitPortalLogin() bool {
+	if state != PORTAL {
+		portalSince = time.Now()
+		login, err := netman.PortalURL(probeURL())
+		if err != nil {
+			fmt.Println("== wifi-connect: Cannot find the captive portal login page:", err)
+			return false
+		}
+		fmt.Println("== wifi-connect: Captive portal login page is", login)
+		server.SetPortalURL(login)
+	}
+	if time.Since(portalSince) >= PortalTimeout {
+		fmt.Println("== wifi-connect: No captive portal login, giving up")
+		c.PortalDone()
+		return false
+	}
+	return true
+}
+
+// PortalDone stops proxying the captive portal login page
+func (c *Client) PortalDone() {
+	server.SetPortalURL("")
+}
+
+// SettleCheckpoint ends the pending connection attempt from the portal: the
+// new network configuration is kept if keep is true, else NetworkManager
+// restores the configuration saved before the attempt
+func (c *Client) SettleCheckpoint(b backend.Backend, keep bool) {
+	checkpoint := utils.Checkpoint.Read()
+	if checkpoint == "" {
+		return
+	}
+	if nm, ok := b.(*backend.NM); ok {
+		var err error
+		if keep {
+			err = nm.Client().CheckpointDestroy(checkpoint)
+		} else {
+			fmt.Println("== wifi-connect: Restoring the network configuration saved before connecting")
+			err = nm.Client().CheckpointRollback(checkpoint)
+		}
+		if err != nil {
+			fmt.Println("== wifi-connect: Error settling the connection attempt:", err)
+		}
+	}
+	if err := utils.Checkpoint.Write(""); err != nil {
+		fmt.Println("== wifi-connect: Error clearing the checkpoint:", err)
+	}
+}
+
+// signalMonitor returns the monitor sampling the signal of the wifi
+// interface, started if needed
+func signalMonitor(nm *backend.NM) *netman.SignalMonitor {
+	signalsMu.Lock()
+	defer signalsMu.Unlock()
+	if signals == nil || signalsIface != wifiIface {
+		if signals != nil {
+			signals.Close()
+		}
+		signals = nm.Client().NewSignalMonitor(wifiIface, SignalInterval)
+		signalsIface = wifiIface
+	}
+	return signals
 }
 
-// ScanSsids sets wlan0 to be managed and then scans
+// stopSignalMonitor stops sampling the signal, eg. when no longer operating
+// as the interface may be used for the AP
+func stopSignalMonitor() {
+	signalsMu.Lock()
+	defer signalsMu.Unlock()
+	if signals != nil {
+		signals.Close()
+		signals = nil
+	}
+}
+
+// GetRoamThreshold returns the signal quality in percent below which a
+// stronger network is looked for, false if roaming is disabled
+func GetRoamThreshold() (uint8, bool) {
+	switch setting := utils.RoamThreshold.Read(); setting {
+	case "":
+		return RoamThreshold, true
+	case RoamOff:
+		return 0, false
+	default:
+		threshold, err := strconv.ParseUint(setting, 10, 8)
+		if err != nil || threshold > 100 {
+			fmt.Printf("== wifi-connect: Invalid roaming threshold %q, using %d%%\n", setting, RoamThreshold)
+			return RoamThreshold, true
+		}
+		return uint8(threshold), true
+	}
+}
+
+// Roam samples the signal of the wifi connection and, when it stayed weak,
+// moves to a stronger saved network or access point of the same network.
+// Only NetworkManager supports it
+func (c *Client) Roam(b backend.Backend) {
+	nm, ok := b.(*backend.NM)
+	if !ok {
+		return
+	}
+	threshold, enabled := GetRoamThreshold()
+	if !enabled {
+		stopSignalMonitor()
+		return
+	}
+	signals := signalMonitor(nm)
+	if time.Since(lastRoam) < RoamHoldoff || !netman.WeakSignal(signals.Samples(), threshold, RoamSamples) {
+		return
+	}
+	lastRoam = time.Now()
+	to, found, err := nm.Client().FindRoamCandidate(wifiIface, RoamMargin)
+	if err != nil {
+		fmt.Println("== wifi-connect: Error looking for a stronger network:", err)
+		return
+	}
+	if !found {
+		fmt.Println("== wifi-connect: Weak signal, no stronger saved network found")
+		return
+	}
+	fmt.Printf("== wifi-connect: Weak signal, roaming to %s (%s, %d%%)\n", to.Ssid, to.Bssid, to.Strength)
+	if err = nm.Client().Roam(wifiIface, to); err != nil {
+		fmt.Println("== wifi-connect: Error roaming:", err)
+	}
+	signals.Reset()
+}
+
+// SetScanExclusion makes b leave the AP put up by wifi-ap, by SSID and
+// BSSID, and the SSID patterns of the deny-list out of scan results
+func (c *Client) SetScanExclusion(b backend.Backend, cw *wifiap.Client) {
+	e := &netman.Exclusion{Patterns: utils.DenyList.ReadList()}
+	config, err := cw.Show()
+	if err != nil {
+		fmt.Println("== wifi-connect: Error getting wifi-ap configuration:", err)
+	} else if ssid, ok := config["wifi.ssid"].(string); ok && ssid != "" {
+		e.Ssids = append(e.Ssids, ssid)
+	}
+	// the AP is hosted on the wifi interface, so it has its address
+	bssid, err := b.HwAddress(wifiIface)
+	if err != nil {
+		fmt.Println("== wifi-connect: Error getting wifi interface address:", err)
+	} else if bssid != "" {
+		e.Bssids = append(e.Bssids, bssid)
+	}
+	b.SetExclusion(e)
+}
+
+// ScanSsids sets the wifi interface to be managed and then requests a fresh scan
 // for ssids. If found, write the ssids (comma separated)
 // to path and return true, else return false.
-func (c *Client) ScanSsids(path string, nc *netman.Client) bool {
-	c.Manage(nc)
-	SSIDs, _, _ := nc.Ssids()
+func (c *Client) ScanSsids(path string, b backend.Backend) bool {
+	c.Manage(b)
+	SSIDs, err := b.Scan(wifiIface)
+	if err != nil {
+		fmt.Println("== wifi-connect: Error scanning:", err)
+	}
 	//only write SSIDs when found
 	if len(SSIDs) > 0 {
 		var out string
+		// an ssid announced with different securities is listed once
+		seen := make(map[string]bool)
 		for _, ssid := range SSIDs {
-			out += strings.TrimSpace(ssid.Ssid) + ","
+			name := strings.TrimSpace(ssid.Ssid)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			out += name + ","
 		}
 		out = out[:len(out)-1]
 		err := ioutil.WriteFile(path, []byte(out), 0644)
@@ -122,18 +502,24 @@ func (c *Client) ScanSsids(path string, nc *netman.Client) bool {
 	return false
 }
 
-// Unmanage sets wlan0 to be Unmanaged by network manager if it
-// is managed
-func (c *Client) Unmanage(nc *netman.Client) {
-	ifaces, _ := nc.WifisManaged(nc.GetWifiDevices(nc.GetDevices()))
-	if _, ok := ifaces["wlan0"]; ok {
-		nc.SetIfaceManaged("wlan0", false, nc.GetWifiDevices(nc.GetDevices()))
+// Unmanage sets the wifi interface to be Unmanaged by the network backend if
+// it is managed
+func (c *Client) Unmanage(b backend.Backend) {
+	if !b.Managed(wifiIface) {
+		return
+	}
+	err := b.SetManaged(wifiIface, false)
+	if err != nil {
+		fmt.Println("== wifi-connect: Error unmanaging wifi interface:", err)
 	}
 }
 
-// Manage sets wlan0 to not managed by network manager
-func (c *Client) Manage(nc *netman.Client) {
-	nc.SetIfaceManaged("wlan0", true, nc.GetWifiDevices(nc.GetDevices()))
+// Manage sets the wifi interface to be managed by the network backend
+func (c *Client) Manage(b backend.Backend) {
+	err := b.SetManaged(wifiIface, true)
+	if err != nil {
+		fmt.Println("== wifi-connect: Error managing wifi interface:", err)
+	}
 }
 
 // CheckWaitApConnect returns true if the flag wait file exists
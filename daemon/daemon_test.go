@@ -18,9 +18,13 @@
 package daemon
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/CanonicalLtd/UCWifiConnect/server"
 	"github.com/CanonicalLtd/UCWifiConnect/utils"
 )
 
@@ -44,6 +48,58 @@ func TestWaitFlagPath(t *testing.T) {
 	}
 }
 
+func TestInterface(t *testing.T) {
+	client := GetClient()
+	client.SetInterface("mlan0")
+	if iface := client.GetInterface(); iface != "mlan0" {
+		t.Errorf("Interface should be mlan0 but is %s", iface)
+	}
+	if server.WifiInterface != "mlan0" {
+		t.Errorf("Portal interface should be mlan0 but is %s", server.WifiInterface)
+	}
+}
+
+type links struct {
+	wifi, ethernet bool
+}
+
+func (l links) ConnectedWifi() bool {
+	return l.wifi
+}
+
+func (l links) ConnectedEthernet() bool {
+	return l.ethernet
+}
+
+func TestOperational(t *testing.T) {
+	client := GetClient()
+	defer client.SetPolicy(PolicyWifi)
+	if err := client.SetPolicy("always"); err == nil {
+		t.Errorf("Unknown policy should not be accepted")
+	}
+	cases := []struct {
+		policy      string
+		links       links
+		operational bool
+	}{
+		{PolicyWifi, links{wifi: true}, true},
+		{PolicyWifi, links{ethernet: true}, false},
+		{PolicyEthernet, links{ethernet: true}, true},
+		{PolicyEthernet, links{wifi: true}, true},
+		{PolicyEthernet, links{}, false},
+		{PolicyEthernetWifi, links{ethernet: true}, false},
+		{PolicyEthernetWifi, links{wifi: true}, false},
+		{PolicyEthernetWifi, links{wifi: true, ethernet: true}, true},
+	}
+	for _, tc := range cases {
+		client.SetPolicy(tc.policy)
+		operational, reason := client.Operational(tc.links)
+		if operational != tc.operational || reason == "" {
+			t.Errorf("Policy %s with %+v should be operational %t, got %t (%s)", tc.policy, tc.links, tc.operational, operational, reason)
+		}
+	}
+}
+
 func TestState(t *testing.T) {
 	client := GetClient()
 	client.SetState(MANAGING)
@@ -108,3 +164,49 @@ func TestSetDefaults(t *testing.T) {
 		t.Errorf("SetDefaults password match did not match")
 	}
 }
+
+func TestWaitPortalLogin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://portal.example/login", http.StatusFound)
+	}))
+	defer ts.Close()
+	utils.ConnectivityCheck.SetPath("/tmp/connectivity-check")
+	utils.ConnectivityCheck.Write(ts.URL)
+	defer utils.ConnectivityCheck.Write("")
+	defer server.SetPortalURL("")
+
+	client := GetClient()
+	client.SetState(MANAGING)
+	if !client.WaitPortalLogin() || server.PortalURL() != "http://portal.example/login" {
+		t.Errorf("The login page should have been found, got %q", server.PortalURL())
+	}
+	client.SetState(PORTAL)
+	timeout := PortalTimeout
+	defer func() { PortalTimeout = timeout }()
+	PortalTimeout = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if client.WaitPortalLogin() || server.PortalURL() != "" {
+		t.Errorf("The wait should have timed out")
+	}
+}
+
+func TestGetRoamThreshold(t *testing.T) {
+	utils.RoamThreshold.SetPath("/tmp/roam-threshold")
+	defer utils.RoamThreshold.Write("")
+	for _, tc := range []struct {
+		setting   string
+		threshold uint8
+		enabled   bool
+	}{
+		{"", RoamThreshold, true},
+		{"50", 50, true},
+		{RoamOff, 0, false},
+		{"loud", RoamThreshold, true},
+		{"300", RoamThreshold, true},
+	} {
+		utils.RoamThreshold.Write(tc.setting)
+		if threshold, enabled := GetRoamThreshold(); threshold != tc.threshold || enabled != tc.enabled {
+			t.Errorf("GetRoamThreshold with %q returned %d %v", tc.setting, threshold, enabled)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/UCWifiConnect/backend"
+	"github.com/CanonicalLtd/UCWifiConnect/netman"
+	"github.com/CanonicalLtd/UCWifiConnect/netman/fakenm"
+	"github.com/CanonicalLtd/UCWifiConnect/utils"
+	"github.com/CanonicalLtd/UCWifiConnect/wifiap"
+)
+
+// TestFakeFlow goes through the daemon steps of a portal session against a
+// fake NetworkManager: scan, unmanage for the AP, then connect
+func TestFakeFlow(t *testing.T) {
+	bus, err := fakenm.StartBus()
+	if err == fakenm.ErrNoDaemon {
+		t.Skip("dbus-daemon is not installed")
+	}
+	if err != nil {
+		t.Fatalf("Cannot start bus: %v", err)
+	}
+	defer bus.Close()
+	nm, err := fakenm.New(bus)
+	if err != nil {
+		t.Fatalf("Cannot start fake NetworkManager: %v", err)
+	}
+	defer nm.Close()
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatalf("Cannot connect to bus: %v", err)
+	}
+	defer conn.Close()
+
+	// another radio, first in the list, must not be used
+	other := nm.AddDevice(fakenm.Device{Interface: "wlan1", Type: fakenm.TypeWifi, Capabilities: 0x40})
+	nm.AddAccessPoint(other, fakenm.AccessPoint{Ssid: "upstairs", Bssid: "00:11:22:33:44:10", Strength: 90, Frequency: 2412})
+	nm.AddAccessPoint(other, fakenm.AccessPoint{Ssid: "lab", Bssid: "00:11:22:33:44:11", Strength: 60, Frequency: 2412, Hidden: true})
+	wifi := nm.AddDevice(fakenm.Device{Interface: "wlan0", Type: fakenm.TypeWifi, Capabilities: 0x40, Unmanaged: true})
+	nm.AddAccessPoint(wifi, fakenm.AccessPoint{Ssid: "home", Bssid: "00:11:22:33:44:01", Strength: 70, Frequency: 2412,
+		Flags: 0x1, RsnFlags: 0x100, Passphrase: "secret12"})
+	nm.AddAccessPoint(wifi, fakenm.AccessPoint{Ssid: "cafe", Bssid: "00:11:22:33:44:02", Strength: 50, Frequency: 2437})
+	nm.AddAccessPoint(wifi, fakenm.AccessPoint{Ssid: "lab", Bssid: "00:11:22:33:44:03", Strength: 40, Frequency: 2437, Hidden: true})
+	b := backend.NewNM(netman.NewBusClient(conn))
+
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ssids")
+
+	client := GetClient()
+	client.SetInterface("wlan0")
+	if !client.ScanSsids(path, b) {
+		t.Fatalf("SSIDs should have been found")
+	}
+	if nm.State(wifi) != fakenm.StateDisconnected {
+		t.Errorf("wlan0 should have been managed for scanning")
+	}
+	if ssids, _ := ioutil.ReadFile(path); string(ssids) != "home,cafe" {
+		t.Errorf("Unexpected SSIDs: %q", ssids)
+	}
+
+	client.Unmanage(b)
+	if nm.State(wifi) != fakenm.StateUnmanaged {
+		t.Errorf("wlan0 should have been unmanaged for the AP")
+	}
+
+	// connecting from the portal
+	if err = b.SetManaged("wlan0", true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = b.Connect("wlan0", backend.Network{Ssid: "home", Passphrase: "secret12"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !b.Connected("wlan0") || nm.State(wifi) != fakenm.StateActivated {
+		t.Errorf("wlan0 should be connected")
+	}
+	if err = b.Connect("wlan0", backend.Network{Ssid: "lab", Hidden: true, Security: netman.SecurityOpen}); err != nil {
+		t.Fatalf("Unexpected error joining a hidden network: %v", err)
+	}
+	if nm.State(wifi) != fakenm.StateActivated || nm.State(other) == fakenm.StateActivated {
+		t.Errorf("The hidden network should have been joined with wlan0")
+	}
+
+	// each radio is connected and disconnected on its own
+	if err = b.Connect("wlan1", backend.Network{Ssid: "upstairs"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = b.Disconnect("wlan0"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if b.Connected("wlan0") || !b.Connected("wlan1") || nm.State(other) != fakenm.StateActivated {
+		t.Errorf("Only wlan0 should have been disconnected")
+	}
+}
+
+// TestFakeOnline checks the daemon only considers full connectivity online
+func TestFakeOnline(t *testing.T) {
+	bus, err := fakenm.StartBus()
+	if err == fakenm.ErrNoDaemon {
+		t.Skip("dbus-daemon is not installed")
+	}
+	if err != nil {
+		t.Fatalf("Cannot start bus: %v", err)
+	}
+	defer bus.Close()
+	nm, err := fakenm.New(bus)
+	if err != nil {
+		t.Fatalf("Cannot start fake NetworkManager: %v", err)
+	}
+	defer nm.Close()
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatalf("Cannot connect to bus: %v", err)
+	}
+	defer conn.Close()
+	b := backend.NewNM(netman.NewBusClient(conn))
+
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	utils.ConnectivityCheck.SetPath(filepath.Join(dir, "connectivity-check"))
+
+	client := GetClient()
+	if online, reason := client.Online(b, true); !online || client.GetConnectivity() != netman.ConnectivityFull {
+		t.Errorf("Full connectivity should be online: %s", reason)
+	}
+	// the last result is reused until the interval expires
+	nm.SetConnectivity(fakenm.ConnectivityPortal)
+	if online, _ := client.Online(b, false); !online {
+		t.Errorf("Last result should have been reused")
+	}
+	interval := ConnectivityInterval
+	defer func() { ConnectivityInterval = interval }()
+	ConnectivityInterval = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if online, reason := client.Online(b, false); online || client.GetConnectivity() != netman.ConnectivityPortal {
+		t.Errorf("Captive portal should not be online: %s", reason)
+	}
+	nm.SetConnectivity(fakenm.ConnectivityLimited)
+	if online, reason := client.Online(b, true); online || client.GetConnectivity() != netman.ConnectivityLimited {
+		t.Errorf("Limited connectivity should not be online: %s", reason)
+	}
+
+	utils.ConnectivityCheck.Write(CheckOff)
+	if online, _ := client.Online(b, true); !online {
+		t.Errorf("Disabled checks should be online")
+	}
+}
+
+func TestFakeSettleCheckpoint(t *testing.T) {
+	bus, err := fakenm.StartBus()
+	if err == fakenm.ErrNoDaemon {
+		t.Skip("dbus-daemon is not installed")
+	}
+	if err != nil {
+		t.Fatalf("Cannot start bus: %v", err)
+	}
+	defer bus.Close()
+	nm, err := fakenm.New(bus)
+	if err != nil {
+		t.Fatalf("Cannot start fake NetworkManager: %v", err)
+	}
+	defer nm.Close()
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatalf("Cannot connect to bus: %v", err)
+	}
+	defer conn.Close()
+	wifi := nm.AddDevice(fakenm.Device{Interface: "wlan0", Type: fakenm.TypeWifi, Capabilities: 0x40})
+	nm.AddAccessPoint(wifi, fakenm.AccessPoint{Ssid: "cafe", Bssid: "00:11:22:33:44:02", Strength: 50, Frequency: 2437})
+	b := backend.NewNM(netman.NewBusClient(conn))
+
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	utils.Checkpoint.SetPath(filepath.Join(dir, "checkpoint"))
+
+	client := GetClient()
+	for _, keep := range []bool{false, true} {
+		checkpoint, err := b.Client().CheckpointCreate(nil, time.Minute)
+		if err != nil {
+			t.Fatalf("Unexpected checkpoint error: %v", err)
+		}
+		utils.Checkpoint.Write(checkpoint)
+		if err = b.Connect("wlan0", backend.Network{Ssid: "cafe"}); err != nil {
+			t.Fatalf("Unexpected connect error: %v", err)
+		}
+		client.SettleCheckpoint(b, keep)
+		if utils.Checkpoint.Read() != "" || nm.Checkpoints() != 0 {
+			t.Errorf("Checkpoint should have been settled")
+		}
+		if kept := len(nm.Connections()) == 1; kept != keep {
+			t.Errorf("Keep %v, but the profile kept is %v", keep, kept)
+		}
+	}
+}
+
+// TestFakeSignalMonitor checks the signal is only sampled while OPERATING
+func TestFakeSignalMonitor(t *testing.T) {
+	bus, err := fakenm.StartBus()
+	if err == fakenm.ErrNoDaemon {
+		t.Skip("dbus-daemon is not installed")
+	}
+	if err != nil {
+		t.Fatalf("Cannot start bus: %v", err)
+	}
+	defer bus.Close()
+	nm, err := fakenm.New(bus)
+	if err != nil {
+		t.Fatalf("Cannot start fake NetworkManager: %v", err)
+	}
+	defer nm.Close()
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatalf("Cannot connect to bus: %v", err)
+	}
+	defer conn.Close()
+	nm.AddDevice(fakenm.Device{Interface: "wlan0", Type: fakenm.TypeWifi, Capabilities: 0x40})
+	b := backend.NewNM(netman.NewBusClient(conn))
+	utils.RoamThreshold.SetPath(filepath.Join(os.TempDir(), "roam-threshold"))
+	defer utils.RoamThreshold.Write("")
+
+	client := GetClient()
+	client.SetInterface("wlan0")
+	defer stopSignalMonitor()
+	client.SetState(OPERATING)
+	client.Roam(b)
+	if signals == nil {
+		t.Fatalf("The signal should be sampled while operating")
+	}
+	client.SetState(MANAGING)
+	if signals != nil {
+		t.Errorf("The signal should not be sampled after leaving OPERATING")
+	}
+
+	utils.RoamThreshold.Write(RoamOff)
+	client.SetState(OPERATING)
+	client.Roam(b)
+	if signals != nil {
+		t.Errorf("The signal should not be sampled with roaming disabled")
+	}
+	client.SetState(MANAGING)
+}
+
+// TestFakeSelectInterface checks no interface is used for the AP if none
+// supports AP mode
+func TestFakeSelectInterface(t *testing.T) {
+	bus, err := fakenm.StartBus()
+	if err == fakenm.ErrNoDaemon {
+		t.Skip("dbus-daemon is not installed")
+	}
+	if err != nil {
+		t.Fatalf("Cannot start bus: %v", err)
+	}
+	defer bus.Close()
+	nm, err := fakenm.New(bus)
+	if err != nil {
+		t.Fatalf("Cannot start fake NetworkManager: %v", err)
+	}
+	defer nm.Close()
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatalf("Cannot connect to bus: %v", err)
+	}
+	defer conn.Close()
+	nm.AddDevice(fakenm.Device{Interface: "wlan0", Type: fakenm.TypeWifi})
+	b := backend.NewNM(netman.NewBusClient(conn))
+	utils.Interface.SetPath(filepath.Join(os.TempDir(), "interface"))
+	utils.Interface.Write("wlan0")
+	defer utils.Interface.Write("")
+
+	client := GetClient()
+	defer client.SetInterface("wlan0")
+	if err = client.SelectInterface(b, wifiap.DefaultClient()); !errors.Is(err, netman.ErrNoDevice) {
+		t.Errorf("No AP capable interface expected, got: %v", err)
+	}
+	if iface := client.GetInterface(); iface != "" {
+		t.Errorf("The interface without AP mode should not be used, got %q", iface)
+	}
+}
@@ -0,0 +1,457 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	agentPath        = dbus.ObjectPath("/org/freedesktop/NetworkManager/SecretAgent")
+	agentIface       = "org.freedesktop.NetworkManager.SecretAgent"
+	agentManagerPath = dbus.ObjectPath("/org/freedesktop/NetworkManager/AgentManager")
+	agentIdentifier  = "com.canonical.wifi-connect"
+)
+
+// GetSecrets flags (NM_SECRET_AGENT_GET_SECRETS_FLAG_*)
+const (
+	secretsAllowInteraction uint32 = 0x1
+	secretsRequestNew       uint32 = 0x2
+)
+
+// SecretTimeout is the time a secret request waits for an answer from the
+// portal, before NetworkManager gives up on the agent
+var SecretTimeout = 110 * time.Second
+
+// SecretStore keeps the secrets the agent answers NetworkManager with, by
+// network name and setting, eg. "802-11-wireless-security"
+type SecretStore interface {
+	// Secrets returns the stored secrets of setting, nil if none
+	Secrets(network string, setting string) map[string]string
+	// SaveSecrets stores the secrets of setting
+	SaveSecrets(network string, setting string, secrets map[string]string) error
+	// DeleteSecrets forgets all secrets of the network
+	DeleteSecrets(network string) error
+}
+
+// SecretRequest is a request for secrets from NetworkManager that the store
+// could not answer, so that the user supplies them
+type SecretRequest struct {
+	ID string
+	// Network is the SSID, or the connection name if not wifi
+	Network string
+	// Setting is the setting the secrets are for, eg. "802-1x"
+	Setting string
+	// Keys are the secrets requested, eg. "psk" or "password"
+	Keys []string
+	// Waiting is true while NetworkManager waits for the answer, else the
+	// answer is stored for the next request
+	Waiting bool
+
+	connection dbus.ObjectPath
+	answer     chan map[string]string
+	cancel     chan struct{}
+}
+
+// SecretAgent answers the secret requests of NetworkManager from a store.
+// It registers again when NetworkManager restarts or the bus connection is
+// lost, until closed
+type SecretAgent struct {
+	client *Client
+	store  SecretStore
+
+	mu       sync.Mutex
+	sub      *Subscription
+	requests []*SecretRequest
+	next     int
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewSecretAgent registers a secret agent answering with store. Close it
+// when no longer needed
+func (c *Client) NewSecretAgent(store SecretStore) (*SecretAgent, error) {
+	sub, err := c.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	a := &SecretAgent{
+		client: &Client{dbusClient: c.dbusClient},
+		store:  store,
+		sub:    sub,
+		done:   make(chan struct{}),
+	}
+	if err = a.register(); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	go a.run()
+	return a, nil
+}
+
+// Close unregisters the agent
+func (a *SecretAgent) Close() {
+	a.once.Do(func() {
+		close(a.done)
+	})
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sub.Close()
+	obj := a.client.object(agentManagerPath)
+	obj.Call("org.freedesktop.NetworkManager.AgentManager.Unregister", 0)
+}
+
+// Requests returns the secret requests not answered yet
+func (a *SecretAgent) Requests() []SecretRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	requests := []SecretRequest{}
+	for _, r := range a.requests {
+		requests = append(requests, SecretRequest{ID: r.ID, Network: r.Network, Setting: r.Setting, Keys: r.Keys, Waiting: r.Waiting})
+	}
+	return requests
+}
+
+// SaveNetworkSecrets stores the secrets of the saved profile of the wifi
+// network ssid, eg. the passphrase it was just joined with, so that they are
+// at hand when NetworkManager asks for them again
+func (a *SecretAgent) SaveNetworkSecrets(ssid string) error {
+	profiles, err := a.client.ProfilesBySsid(ssid)
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return opError("save secrets", ssid, errors.New("no saved profile"))
+	}
+	path := profiles[newestProfile(profiles)].Path
+	settings, err := a.client.connectionSettings(path)
+	if err != nil {
+		return err
+	}
+	a.client.mergeSecrets(path, settings)
+	for _, setting := range []string{"802-11-wireless-security", "802-1x"} {
+		if _, ok := settings[setting]; !ok {
+			continue
+		}
+		secrets := make(map[string]string)
+		for _, k := range requestedKeys(settings, setting, nil) {
+			if v, ok := settings[setting][k].Value().(string); ok && v != "" {
+				secrets[k] = v
+			}
+		}
+		if len(secrets) == 0 {
+			continue
+		}
+		if err = a.store.SaveSecrets(networkName(settings), setting, secrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Answer stores the secrets supplied for the request id, and answers
+// NetworkManager if it still waits for them
+func (a *SecretAgent) Answer(id string, secrets map[string]string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, r := range a.requests {
+		if r.ID != id {
+			continue
+		}
+		if err := a.store.SaveSecrets(r.Network, r.Setting, secrets); err != nil {
+			return err
+		}
+		if r.Waiting {
+			r.answer <- secrets
+		}
+		a.requests = append(a.requests[:i], a.requests[i+1:]...)
+		return nil
+	}
+	return ErrNoSecretRequest
+}
+
+// register exports the agent on the current bus connection and registers it
+// with NetworkManager
+func (a *SecretAgent) register() error {
+	if a.client.dbusClient.bus == nil {
+		return opError("register secret agent", "", ErrNoBus)
+	}
+	conn, err := a.client.dbusClient.bus.Conn()
+	if err != nil {
+		return opError("register secret agent", "", err)
+	}
+	err = conn.ExportMethodTable(map[string]interface{}{
+		"GetSecrets": func(sender dbus.Sender, connection map[string]map[string]dbus.Variant, path dbus.ObjectPath,
+			setting string, hints []string, flags uint32) (map[string]map[string]dbus.Variant, *dbus.Error) {
+			if err := a.checkSender(conn, sender); err != nil {
+				return nil, err
+			}
+			return a.getSecrets(connection, path, setting, hints, flags)
+		},
+		"CancelGetSecrets": func(sender dbus.Sender, path dbus.ObjectPath, setting string) *dbus.Error {
+			if err := a.checkSender(conn, sender); err != nil {
+				return err
+			}
+			a.cancelSecrets(path, setting)
+			return nil
+		},
+		"SaveSecrets": func(sender dbus.Sender, connection map[string]map[string]dbus.Variant, path dbus.ObjectPath) *dbus.Error {
+			if err := a.checkSender(conn, sender); err != nil {
+				return err
+			}
+			return a.saveSecrets(connection)
+		},
+		"DeleteSecrets": func(sender dbus.Sender, connection map[string]map[string]dbus.Variant, path dbus.ObjectPath) *dbus.Error {
+			if err := a.checkSender(conn, sender); err != nil {
+				return err
+			}
+			if err := a.store.DeleteSecrets(networkName(connection)); err != nil {
+				return agentError("Failed", err.Error())
+			}
+			return nil
+		},
+	}, agentPath, agentIface)
+	if err != nil {
+		return opError("export secret agent", "", err)
+	}
+	obj := conn.Object("org.freedesktop.NetworkManager", agentManagerPath)
+	err = obj.Call("org.freedesktop.NetworkManager.AgentManager.Register", 0, agentIdentifier).Err
+	return opError("register secret agent", "", err)
+}
+
+// run registers again when NetworkManager restarts or the bus connection is
+// lost, until the agent is closed
+func (a *SecretAgent) run() {
+	for {
+		a.mu.Lock()
+		sub := a.sub
+		a.mu.Unlock()
+		for e := range sub.Events() {
+			if e.Type == ManagerStarted {
+				if err := a.register(); err != nil {
+					fmt.Println("== wifi-connect: Cannot register the secret agent:", err)
+				}
+			}
+		}
+		sub = subscribeAgain(a.client, a.done)
+		if sub == nil {
+			return
+		}
+		a.mu.Lock()
+		select {
+		case <-a.done:
+			a.mu.Unlock()
+			sub.Close()
+			return
+		default:
+		}
+		a.sub = sub
+		a.mu.Unlock()
+		if err := a.register(); err != nil {
+			fmt.Println("== wifi-connect: Cannot register the secret agent:", err)
+		}
+	}
+}
+
+func agentError(name string, message string) *dbus.Error {
+	return dbus.NewError(agentIface+"."+name, []interface{}{message})
+}
+
+// checkSender only lets NetworkManager ask for secrets
+func (a *SecretAgent) checkSender(conn *dbus.Conn, sender dbus.Sender) *dbus.Error {
+	var owner string
+	err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, "org.freedesktop.NetworkManager").Store(&owner)
+	if err != nil || owner != string(sender) {
+		return agentError("PermissionDenied", "only NetworkManager may use the agent")
+	}
+	return nil
+}
+
+// networkName returns the SSID of a wifi connection, else its name
+func networkName(connection map[string]map[string]dbus.Variant) string {
+	if ssid, ok := connection["802-11-wireless"]["ssid"].Value().([]byte); ok && len(ssid) > 0 {
+		return string(ssid)
+	}
+	id, _ := connection["connection"]["id"].Value().(string)
+	return id
+}
+
+// requestedKeys returns the secrets of setting NetworkManager needs, from
+// the hints if any, else guessed from the connection
+func requestedKeys(connection map[string]map[string]dbus.Variant, setting string, hints []string) []string {
+	keys := []string{}
+	for _, h := range hints {
+		// hints like "user:..." are for VPN plugins
+		if h != "" && !strings.Contains(h, ":") {
+			keys = append(keys, h)
+		}
+	}
+	if len(keys) > 0 {
+		return keys
+	}
+	values := connection[setting]
+	switch setting {
+	case "802-11-wireless-security":
+		if mgmt, _ := values["key-mgmt"].Value().(string); mgmt == "none" {
+			idx, _ := values["wep-tx-keyidx"].Value().(uint32)
+			return []string{"wep-key" + strconv.Itoa(int(idx))}
+		}
+		return []string{"psk"}
+	case "802-1x":
+		eap, _ := values["eap"].Value().([]string)
+		for _, eap := range eap {
+			if eap == "tls" {
+				return []string{"private-key-password"}
+			}
+		}
+	}
+	return []string{"password"}
+}
+
+// getSecrets answers from the store, else records the request and waits for
+// the user to answer it if NetworkManager allows interaction
+func (a *SecretAgent) getSecrets(connection map[string]map[string]dbus.Variant, path dbus.ObjectPath,
+	setting string, hints []string, flags uint32) (map[string]map[string]dbus.Variant, *dbus.Error) {
+	network := networkName(connection)
+	keys := requestedKeys(connection, setting, hints)
+	if flags&secretsRequestNew == 0 {
+		if stored := a.store.Secrets(network, setting); hasKeys(stored, keys) {
+			return secretSettings(setting, stored), nil
+		}
+	}
+	waiting := flags&secretsAllowInteraction != 0
+	r, answer, cancel := a.addRequest(network, setting, keys, path, waiting)
+	fmt.Printf("== wifi-connect: NetworkManager requests %s secrets for %s\n", setting, network)
+	if !waiting {
+		return nil, agentError("NoSecrets", "no secrets stored for "+network)
+	}
+	select {
+	case secrets := <-answer:
+		return secretSettings(setting, secrets), nil
+	case <-cancel:
+		a.stopWaiting(r)
+		return nil, agentError("AgentCanceled", "the secret request was canceled")
+	case <-time.After(SecretTimeout):
+		a.stopWaiting(r)
+		return nil, agentError("NoSecrets", "no secrets supplied for "+network)
+	}
+}
+
+func hasKeys(secrets map[string]string, keys []string) bool {
+	if len(secrets) == 0 {
+		return false
+	}
+	for _, k := range keys {
+		if _, ok := secrets[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func secretSettings(setting string, secrets map[string]string) map[string]map[string]dbus.Variant {
+	values := make(map[string]dbus.Variant)
+	for k, v := range secrets {
+		values[k] = dbus.MakeVariant(v)
+	}
+	return map[string]map[string]dbus.Variant{setting: values}
+}
+
+// addRequest records a request, replacing a previous one for the same
+// network and setting. It returns the channels the answer or the
+// cancellation comes on, as r is only accessed with a.mu held
+func (a *SecretAgent) addRequest(network string, setting string, keys []string, path dbus.ObjectPath,
+	waiting bool) (*SecretRequest, <-chan map[string]string, <-chan struct{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.next++
+	r := &SecretRequest{
+		ID:         strconv.Itoa(a.next),
+		Network:    network,
+		Setting:    setting,
+		Keys:       keys,
+		Waiting:    waiting,
+		connection: path,
+		answer:     make(chan map[string]string, 1),
+		cancel:     make(chan struct{}),
+	}
+	for i, old := range a.requests {
+		if old.Network == network && old.Setting == setting {
+			a.requests = append(a.requests[:i], a.requests[i+1:]...)
+			break
+		}
+	}
+	a.requests = append(a.requests, r)
+	return r, r.answer, r.cancel
+}
+
+// stopWaiting keeps r for the user to answer later, once NetworkManager gave
+// up waiting
+func (a *SecretAgent) stopWaiting(r *SecretRequest) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r.Waiting = false
+}
+
+func (a *SecretAgent) cancelSecrets(path dbus.ObjectPath, setting string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range a.requests {
+		if r.connection == path && r.Setting == setting && r.Waiting {
+			r.Waiting = false
+			close(r.cancel)
+		}
+	}
+}
+
+// saveSecrets stores the secrets of connection NetworkManager asks the agent
+// to keep
+func (a *SecretAgent) saveSecrets(connection map[string]map[string]dbus.Variant) *dbus.Error {
+	network := networkName(connection)
+	for setting, values := range connection {
+		secrets := make(map[string]string)
+		for k, v := range values {
+			if s, ok := v.Value().(string); ok && isSecretKey(k) {
+				secrets[k] = s
+			}
+		}
+		if len(secrets) == 0 {
+			continue
+		}
+		if err := a.store.SaveSecrets(network, setting, secrets); err != nil {
+			return agentError("Failed", err.Error())
+		}
+	}
+	return nil
+}
+
+// isSecretKey returns true if the setting key k holds a secret
+func isSecretKey(k string) bool {
+	switch k {
+	case "psk", "password", "private-key-password", "phase2-private-key-password", "leap-password", "pin":
+		return true
+	}
+	return strings.HasPrefix(k, "wep-key") && k != "wep-key-type"
+}
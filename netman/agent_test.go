@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/UCWifiConnect/netman/fakenm"
+	"github.com/godbus/dbus"
+)
+
+// memStore is a SecretStore in memory
+type memStore map[string]map[string]map[string]string
+
+func (s memStore) Secrets(network string, setting string) map[string]string {
+	return s[network][setting]
+}
+
+func (s memStore) SaveSecrets(network string, setting string, secrets map[string]string) error {
+	if s[network] == nil {
+		s[network] = make(map[string]map[string]string)
+	}
+	s[network][setting] = secrets
+	return nil
+}
+
+func (s memStore) DeleteSecrets(network string) error {
+	delete(s, network)
+	return nil
+}
+
+func TestRequestedKeys(t *testing.T) {
+	wep := map[string]map[string]dbus.Variant{"802-11-wireless-security": {
+		"key-mgmt": dbus.MakeVariant("none"), "wep-tx-keyidx": dbus.MakeVariant(uint32(1))}}
+	tls := map[string]map[string]dbus.Variant{"802-1x": {"eap": dbus.MakeVariant([]string{"tls"})}}
+	tests := []struct {
+		connection map[string]map[string]dbus.Variant
+		setting    string
+		hints      []string
+		keys       string
+	}{
+		{nil, "802-11-wireless-security", nil, "psk"},
+		{wep, "802-11-wireless-security", nil, "wep-key1"},
+		{tls, "802-1x", nil, "private-key-password"},
+		{nil, "802-1x", nil, "password"},
+		{nil, "802-1x", []string{"user:otp", "password"}, "password"},
+	}
+	for i, tt := range tests {
+		keys := requestedKeys(tt.connection, tt.setting, tt.hints)
+		if len(keys) != 1 || keys[0] != tt.keys {
+			t.Errorf("Case %d: expected %s, got %v", i, tt.keys, keys)
+		}
+	}
+}
+
+// waitRequest waits until the agent has a request waiting for an answer
+func waitRequest(t *testing.T, a *SecretAgent) SecretRequest {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, r := range a.Requests() {
+			if r.Waiting {
+				return r
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("No request waiting: %+v", a.Requests())
+	return SecretRequest{}
+}
+
+func TestFakeSecretAgent(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+
+	store := memStore{}
+	a, err := f.c.NewSecretAgent(store)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer a.Close()
+	if f.nm.Agent() == "" {
+		t.Fatalf("Agent should have been registered")
+	}
+	security := "802-11-wireless-security"
+	connection := f.nm.AddConnection(map[string]map[string]dbus.Variant{
+		"802-11-wireless": {"ssid": dbus.MakeVariant([]byte("home"))},
+		security:          {"key-mgmt": dbus.MakeVariant("wpa-psk")},
+	})
+
+	// without stored secrets nor interaction the request is kept for later
+	if _, err = f.nm.RequestSecrets(connection, security, nil, 0); err == nil {
+		t.Errorf("Request without secrets should fail")
+	}
+	requests := a.Requests()
+	if len(requests) != 1 || requests[0].Network != "home" || requests[0].Waiting || requests[0].Keys[0] != "psk" {
+		t.Fatalf("Unexpected requests: %+v", requests)
+	}
+	if err = a.Answer(requests[0].ID, map[string]string{"psk": "secret12"}); err != nil {
+		t.Errorf("Unexpected answer error: %v", err)
+	}
+	secrets, err := f.nm.RequestSecrets(connection, security, nil, 0)
+	if err != nil || secrets[security]["psk"].Value() != "secret12" {
+		t.Errorf("Stored secrets expected, got %v, %v", secrets, err)
+	}
+
+	// new secrets are asked to the user while NetworkManager waits
+	done := make(chan error)
+	go func() {
+		var err error
+		secrets, err = f.nm.RequestSecrets(connection, security, nil, fakenm.SecretsAllowInteraction|fakenm.SecretsRequestNew)
+		done <- err
+	}()
+	r := waitRequest(t, a)
+	if err = a.Answer(r.ID, map[string]string{"psk": "changed12"}); err != nil {
+		t.Errorf("Unexpected answer error: %v", err)
+	}
+	if err = <-done; err != nil || secrets[security]["psk"].Value() != "changed12" {
+		t.Errorf("Answered secrets expected, got %v, %v", secrets, err)
+	}
+	if store.Secrets("home", security)["psk"] != "changed12" || len(a.Requests()) != 0 {
+		t.Errorf("Answer should have been stored")
+	}
+	if err = a.Answer(r.ID, map[string]string{"psk": "changed12"}); err != ErrNoSecretRequest {
+		t.Errorf("Expected an unknown request error, got: %v", err)
+	}
+
+	// a canceled request stays for the user to answer
+	go func() {
+		_, err := f.nm.RequestSecrets(connection, security, nil, fakenm.SecretsAllowInteraction|fakenm.SecretsRequestNew)
+		done <- err
+	}()
+	waitRequest(t, a)
+	if err = f.nm.CancelSecrets(connection, security); err != nil {
+		t.Errorf("Unexpected cancel error: %v", err)
+	}
+	if err = <-done; err == nil {
+		t.Errorf("Canceled request should fail")
+	}
+	if requests = a.Requests(); len(requests) != 1 || requests[0].Waiting {
+		t.Errorf("Canceled request should be kept: %+v", requests)
+	}
+
+	// only NetworkManager gets secrets
+	conn, err := f.bus.Connect()
+	if err != nil {
+		t.Fatalf("Cannot connect to bus: %v", err)
+	}
+	defer conn.Close()
+	err = conn.Object(f.nm.Agent(), agentPath).Call(agentIface+".GetSecrets", 0,
+		map[string]map[string]dbus.Variant{"802-11-wireless": {"ssid": dbus.MakeVariant([]byte("home"))}},
+		dbus.ObjectPath(connection), security, []string{}, uint32(0)).Err
+	if err == nil {
+		t.Errorf("Other clients should not get secrets")
+	}
+
+	// the passphrase a network is joined with is stored
+	_, ap2device, ssid2ap, err := f.c.Ssids()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = f.c.ConnectAp("home", "secret12", ap2device, ssid2ap, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store.DeleteSecrets("home")
+	if err = a.SaveNetworkSecrets("home"); err != nil {
+		t.Errorf("Unexpected error saving secrets: %v", err)
+	}
+	if psk := store.Secrets("home", security)["psk"]; psk != "secret12" {
+		t.Errorf("The passphrase should have been stored, got %q", psk)
+	}
+	if err = a.SaveNetworkSecrets("cafe"); err == nil {
+		t.Errorf("A network without profile should fail")
+	}
+
+	a.Close()
+	if f.nm.Agent() != "" {
+		t.Errorf("Agent should have been unregistered")
+	}
+}
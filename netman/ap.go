@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/godbus/dbus"
+)
+
+// Band returns the frequency band of the access point
+func (s SSID) Band() string {
+	switch {
+	case s.Frequency >= 2400 && s.Frequency < 2500:
+		return "2.4GHz"
+	case s.Frequency >= 4900 && s.Frequency < 5925:
+		return "5GHz"
+	case s.Frequency >= 5925 && s.Frequency < 7125:
+		return "6GHz"
+	}
+	return ""
+}
+
+// FrequencyToChannel returns the 802.11 channel number of passed frequency
+// in MHz, or 0 if unknown
+func FrequencyToChannel(freq uint32) int {
+	f := int(freq)
+	switch {
+	case f == 2484:
+		return 14
+	case f >= 2412 && f <= 2472:
+		return (f - 2407) / 5
+	case f >= 4915 && f < 5000:
+		return (f - 4000) / 5
+	case f >= 5000 && f < 5925:
+		return (f - 5000) / 5
+	case f == 5935:
+		return 2
+	case f >= 5955 && f <= 7115:
+		return (f - 5950) / 5
+	}
+	return 0
+}
+
+// accessPoint reads all properties of passed access point
+func (c *Client) accessPoint(ap string) (SSID, error) {
+	objPath := dbus.ObjectPath(ap)
+	obj := c.object(objPath)
+	props := make(map[string]dbus.Variant)
+	err := obj.Call("org.freedesktop.DBus.Properties.GetAll", 0, "org.freedesktop.NetworkManager.AccessPoint").Store(&props)
+	if err != nil {
+		return SSID{}, opError("get AccessPoint properties", ap, err)
+	}
+	ssid, ok := props["Ssid"].Value().([]byte)
+	if !ok {
+		return SSID{}, opError("get AccessPoint properties", ap, errors.New("no ssid"))
+	}
+	s := SSID{Ssid: string(ssid), ApPath: ap, LastSeen: -1}
+	s.Bssid, _ = props["HwAddress"].Value().(string)
+	s.Strength, _ = props["Strength"].Value().(uint8)
+	s.Frequency, _ = props["Frequency"].Value().(uint32)
+	s.Channel = FrequencyToChannel(s.Frequency)
+	s.MaxBitrate, _ = props["MaxBitrate"].Value().(uint32)
+	if lastSeen, ok := props["LastSeen"].Value().(int32); ok {
+		s.LastSeen = lastSeen
+	}
+	flags, _ := props["Flags"].Value().(uint32)
+	wpaFlags, _ := props["WpaFlags"].Value().(uint32)
+	rsnFlags, _ := props["RsnFlags"].Value().(uint32)
+	s.Security = classifySecurity(flags, wpaFlags, rsnFlags)
+	return s, nil
+}
+
+// bySignal sorts access points by strength, strongest first, then by ssid
+// and path so that the order is stable between scans
+type bySignal []SSID
+
+func (a bySignal) Len() int      { return len(a) }
+func (a bySignal) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySignal) Less(i, j int) bool {
+	if a[i].Strength != a[j].Strength {
+		return a[i].Strength > a[j].Strength
+	}
+	if a[i].Ssid != a[j].Ssid {
+		return a[i].Ssid < a[j].Ssid
+	}
+	return a[i].ApPath < a[j].ApPath
+}
+
+// SortBySignal sorts passed access points strongest first
+func SortBySignal(ssids []SSID) {
+	sort.Sort(bySignal(ssids))
+}
+
+// GroupNetworks groups access points by ssid and security, in the order the
+// networks are first found. Each network holds its BSSes strongest first and
+// takes the properties of the strongest one
+func GroupNetworks(aps []SSID) []SSID {
+	var SSIDs []SSID
+	type network struct {
+		ssid     string
+		security Security
+	}
+	networks := make(map[network]int)
+	for _, ap := range aps {
+		key := network{ap.Ssid, ap.Security}
+		i, ok := networks[key]
+		if !ok {
+			networks[key] = len(SSIDs)
+			ap.BSSes = []SSID{ap}
+			SSIDs = append(SSIDs, ap)
+			continue
+		}
+		SSIDs[i].BSSes = append(SSIDs[i].BSSes, ap)
+	}
+	for i := range SSIDs {
+		bsses := SSIDs[i].BSSes
+		SortBySignal(bsses)
+		lastSeen := int32(-1)
+		for _, b := range bsses {
+			if b.LastSeen > lastSeen {
+				lastSeen = b.LastSeen
+			}
+		}
+		SSIDs[i] = bsses[0]
+		SSIDs[i].BSSes = bsses
+		SSIDs[i].LastSeen = lastSeen
+	}
+	return SSIDs
+}
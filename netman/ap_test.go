@@ -0,0 +1,151 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestFrequencyToChannel(t *testing.T) {
+	cases := map[uint32]int{
+		2412: 1,
+		2437: 6,
+		2484: 14,
+		5180: 36,
+		5825: 165,
+		5955: 1,
+		6115: 33,
+		1000: 0,
+	}
+	for freq, channel := range cases {
+		if c := FrequencyToChannel(freq); c != channel {
+			t.Errorf("Frequency %d: expected channel %d, got %d", freq, channel, c)
+		}
+	}
+	if b := (SSID{Frequency: 5180}).Band(); b != "5GHz" {
+		t.Errorf("Expected 5GHz band, got %q", b)
+	}
+	if b := (SSID{Frequency: 2412}).Band(); b != "2.4GHz" {
+		t.Errorf("Expected 2.4GHz band, got %q", b)
+	}
+}
+
+func TestAccessPoint(t *testing.T) {
+	mock := &mockObj{rsnFlags: apSecKeyMgmtPsk}
+	client := NewClient(mock)
+	ap, err := client.accessPoint("/ap/1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ap.Ssid != "ssid0" || ap.ApPath != "/ap/1" {
+		t.Errorf("Unexpected ssid/path: %v", ap)
+	}
+	if ap.Channel != 6 || ap.Frequency != 2437 || ap.MaxBitrate != 54000 || ap.LastSeen != 1000 {
+		t.Errorf("Unexpected radio properties: %v", ap)
+	}
+	if ap.Bssid == "" {
+		t.Errorf("BSSID should be set")
+	}
+	if ap.Security != SecurityWpaPsk {
+		t.Errorf("Expected wpa-psk security, got %v", ap.Security)
+	}
+}
+
+func TestSsidsSorted(t *testing.T) {
+	client := NewClient(&mockObj{})
+	ssids, _, _, err := client.Ssids()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 1; i < len(ssids); i++ {
+		if ssids[i-1].Strength < ssids[i].Strength {
+			t.Errorf("SSIDs not sorted by strength: %v", ssids)
+		}
+	}
+	same := []SSID{{Ssid: "b", ApPath: "/ap/2"}, {Ssid: "a", ApPath: "/ap/3"}, {Ssid: "a", ApPath: "/ap/1"}}
+	SortBySignal(same)
+	if same[0].ApPath != "/ap/1" || same[1].ApPath != "/ap/3" || same[2].ApPath != "/ap/2" {
+		t.Errorf("Equal strength SSIDs not sorted by ssid and path: %v", same)
+	}
+}
+
+// mockScan returns the properties of the access points in aps
+type mockScan struct {
+	mockObj
+	path dbus.ObjectPath
+	aps  map[dbus.ObjectPath]map[string]dbus.Variant
+}
+
+func (m *mockScan) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	m.path = path
+	return m
+}
+
+func (m *mockScan) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	if method == "org.freedesktop.DBus.Properties.GetAll" {
+		call := makeCall()
+		call.Body = []interface{}{m.aps[m.path]}
+		return call
+	}
+	return m.mockObj.Call(method, flags, args...)
+}
+
+func scannedAp(ssid string, strength uint8, rsnFlags uint32) map[string]dbus.Variant {
+	return map[string]dbus.Variant{
+		"Ssid":      dbus.MakeVariant([]byte(ssid)),
+		"HwAddress": dbus.MakeVariant("00:11:22:33:44:" + fmt.Sprintf("%02d", strength)),
+		"Strength":  dbus.MakeVariant(strength),
+		"LastSeen":  dbus.MakeVariant(int32(strength)),
+		"RsnFlags":  dbus.MakeVariant(rsnFlags),
+	}
+}
+
+func TestSsidsGroupedByNetwork(t *testing.T) {
+	mock := &mockScan{aps: map[dbus.ObjectPath]map[string]dbus.Variant{
+		"/ap/1": scannedAp("home", 40, apSecKeyMgmtPsk),
+		"/ap/2": scannedAp("home", 80, apSecKeyMgmtPsk),
+		"/ap/3": scannedAp("home", 60, apSecKeyMgmtPsk),
+		"/ap/4": scannedAp("home", 90, 0),
+		"/ap/5": scannedAp("cafe", 30, 0),
+	}}
+	client := NewClient(mock)
+	ssid2ap := make(map[string]string)
+	ssids, _ := client.getSsids([]string{"/ap/1", "/ap/2", "/ap/3", "/ap/4", "/ap/5"}, ssid2ap)
+	if len(ssids) != 3 {
+		t.Fatalf("3 networks should have been found, but found: %v", ssids)
+	}
+	home := ssids[0]
+	if home.Security != SecurityWpaPsk || len(home.BSSes) != 3 {
+		t.Fatalf("Secured home network should have 3 BSSes: %v", home)
+	}
+	if home.ApPath != "/ap/2" || home.Strength != 80 || home.BSSes[0].ApPath != "/ap/2" || home.BSSes[2].ApPath != "/ap/1" {
+		t.Errorf("Strongest BSS should have been selected: %v", home)
+	}
+	if home.LastSeen != 80 {
+		t.Errorf("Network should have been last seen with its latest BSS, got %d", home.LastSeen)
+	}
+	if ssids[1].Security != SecurityOpen || len(ssids[1].BSSes) != 1 {
+		t.Errorf("Open home network should be a different network: %v", ssids[1])
+	}
+	if ssid2ap["home"] != "/ap/4" || ssid2ap["cafe"] != "/ap/5" {
+		t.Errorf("Strongest access points should be used for activation: %v", ssid2ap)
+	}
+}
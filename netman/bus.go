@@ -0,0 +1,153 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// Default delays between attempts to connect to the bus, doubled after each
+// failed attempt
+const (
+	busRetryMin = 500 * time.Millisecond
+	busRetryMax = 30 * time.Second
+)
+
+// Bus is a connection to a message bus shared by clients. It is connected
+// on first use and connected again, after a backoff delay, when the bus
+// restarts. It is safe for concurrent use
+type Bus struct {
+	dial func() (*dbus.Conn, error)
+	// retryMin and retryMax bound the delay between attempts to connect,
+	// they are not changed once the bus is used
+	retryMin time.Duration
+	retryMax time.Duration
+
+	mu    sync.Mutex
+	conn  *dbus.Conn
+	err   error
+	retry time.Time
+	delay time.Duration
+}
+
+// NewBus returns a bus connected with dial when needed
+func NewBus(dial func() (*dbus.Conn, error)) *Bus {
+	return &Bus{dial: dial, retryMin: busRetryMin, retryMax: busRetryMax}
+}
+
+// backoff returns the bounds of the delay between attempts to connect
+func (b *Bus) backoff() (time.Duration, time.Duration) {
+	if b == nil {
+		return busRetryMin, busRetryMax
+	}
+	return b.retryMin, b.retryMax
+}
+
+// dialSystemBus opens a private connection to the system bus, so that
+// closing it does not affect other users of godbus' shared one
+func dialSystemBus() (*dbus.Conn, error) {
+	conn, err := dbus.SystemBusPrivate()
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Auth(nil)
+	if err == nil {
+		err = conn.Hello()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// systemBus is the connection shared by the clients returned by DefaultClient
+var systemBus = NewBus(dialSystemBus)
+
+// Conn returns the current connection, connecting first if needed. While
+// connecting fails ErrNoBus is returned until the backoff delay expired
+func (b *Bus) Conn() (*dbus.Conn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		return b.conn, nil
+	}
+	if b.dial == nil || time.Now().Before(b.retry) {
+		return nil, b.noBus()
+	}
+	conn, err := b.dial()
+	if err != nil {
+		b.err = err
+		b.delay *= 2
+		if b.delay < b.retryMin {
+			b.delay = b.retryMin
+		}
+		if b.delay > b.retryMax {
+			b.delay = b.retryMax
+		}
+		b.retry = time.Now().Add(b.delay)
+		fmt.Printf("== wifi-connect: Cannot connect to the bus, retrying in %v: %v\n", b.delay, err)
+		return nil, b.noBus()
+	}
+	b.watch(conn)
+	b.conn, b.err, b.delay = conn, nil, 0
+	return conn, nil
+}
+
+// noBus returns ErrNoBus with the last connection error. Must be called
+// locked
+func (b *Bus) noBus() error {
+	if b.err == nil {
+		return ErrNoBus
+	}
+	return fmt.Errorf("%w: %v", ErrNoBus, b.err)
+}
+
+// watch forgets conn once it is closed, which godbus does when the bus goes
+// away, so that the next Conn call connects again. godbus closes the
+// channels passed to Signal then
+func (b *Bus) watch(conn *dbus.Conn) {
+	ch := make(chan *dbus.Signal, 16)
+	conn.Signal(ch)
+	go func() {
+		for range ch {
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.conn == conn {
+			fmt.Println("== wifi-connect: Bus connection lost")
+			b.conn = nil
+			b.err = dbus.ErrClosed
+		}
+	}()
+}
+
+// Close closes the current connection
+func (b *Bus) Close() {
+	b.mu.Lock()
+	conn := b.conn
+	b.conn, b.dial = nil, nil
+	b.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/UCWifiConnect/netman/fakenm"
+	"github.com/godbus/dbus"
+)
+
+func TestBusBackoff(t *testing.T) {
+	dials := 0
+	b := NewBus(func() (*dbus.Conn, error) {
+		dials++
+		return nil, errors.New("no bus")
+	})
+	b.retryMin, b.retryMax = 20*time.Millisecond, 40*time.Millisecond
+	for i := 0; i < 3; i++ {
+		if _, err := b.Conn(); !errors.Is(err, ErrNoBus) {
+			t.Errorf("ErrNoBus expected, but found: %v", err)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("1 dial expected before the delay expired, but found: %d", dials)
+	}
+	time.Sleep(25 * time.Millisecond)
+	b.Conn()
+	if dials != 2 {
+		t.Errorf("2 dials expected after the delay expired, but found: %d", dials)
+	}
+	if b.delay != b.retryMax {
+		t.Errorf("Delay should be clamped to %v, but found: %v", b.retryMax, b.delay)
+	}
+
+	b.Close()
+	b.Conn()
+	if dials != 2 {
+		t.Errorf("No dial expected once closed")
+	}
+}
+
+// TestFakeReconnect checks the client gets back to NetworkManager once the
+// bus restarts
+func TestFakeReconnect(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+	var mu sync.Mutex
+	current := f.bus
+	b := NewBus(func() (*dbus.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return current.Connect()
+	})
+	b.retryMin = 10 * time.Millisecond
+	c := &Client{dbusClient: DbusClient{bus: b}}
+	defer c.Close()
+
+	if devices, err := c.GetDevices(); err != nil || len(devices) != 2 {
+		t.Fatalf("2 devices expected, but found: %v, %v", devices, err)
+	}
+
+	// restart the bus, with a new NetworkManager
+	f.nm.Close()
+	f.bus.Close()
+	bus, err := fakenm.StartBus()
+	if err != nil {
+		t.Fatalf("Cannot restart bus: %v", err)
+	}
+	nm, err := fakenm.New(bus)
+	if err != nil {
+		bus.Close()
+		t.Fatalf("Cannot restart fake NetworkManager: %v", err)
+	}
+	f.bus, f.nm = bus, nm
+	mu.Lock()
+	current = bus
+	mu.Unlock()
+	nm.AddDevice(fakenm.Device{Interface: "wlan1", Type: fakenm.TypeWifi})
+
+	var devices []string
+	for i := 0; i < 100; i++ {
+		if devices, err = c.GetDevices(); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil || len(devices) != 1 {
+		t.Errorf("1 device expected after the restart, but found: %v, %v", devices, err)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// Checkpoint creation flags (NM_CHECKPOINT_CREATE_FLAG_*)
+const (
+	checkpointDestroyAll           uint32 = 0x1
+	checkpointDeleteNewConnections uint32 = 0x2
+	checkpointDisconnectNewDevices uint32 = 0x4
+)
+
+// rollbackOk is the rollback result of a restored device
+// (NM_ROLLBACK_RESULT_OK)
+const rollbackOk uint32 = 0
+
+// CheckpointCreate saves the configuration of devices, all devices if none,
+// and returns the checkpoint. NetworkManager rolls back to it by itself after
+// timeout unless it is rolled back or destroyed before. Checkpoints left by
+// previous attempts are destroyed, and connections created after the
+// checkpoint are deleted on rollback
+func (c *Client) CheckpointCreate(devices []string, timeout time.Duration) (string, error) {
+	paths := []dbus.ObjectPath{}
+	for _, d := range devices {
+		paths = append(paths, dbus.ObjectPath(d))
+	}
+	flags := checkpointDestroyAll | checkpointDeleteNewConnections | checkpointDisconnectNewDevices
+	obj := c.object(nmPath)
+	var checkpoint dbus.ObjectPath
+	err := obj.Call("org.freedesktop.NetworkManager.CheckpointCreate", 0, paths, uint32(timeout/time.Second), flags).Store(&checkpoint)
+	if err != nil {
+		return "", opError("CheckpointCreate", "", err)
+	}
+	return string(checkpoint), nil
+}
+
+// CheckpointRollback restores the configuration saved by checkpoint and
+// destroys it. An error lists the devices that could not be restored
+func (c *Client) CheckpointRollback(checkpoint string) error {
+	obj := c.object(nmPath)
+	results := make(map[string]uint32)
+	err := obj.Call("org.freedesktop.NetworkManager.CheckpointRollback", 0, dbus.ObjectPath(checkpoint)).Store(&results)
+	if err != nil {
+		return opError("CheckpointRollback", checkpoint, err)
+	}
+	failed := []string{}
+	for device, result := range results {
+		if result != rollbackOk {
+			failed = append(failed, fmt.Sprintf("%s (result %d)", device, result))
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return opError("CheckpointRollback", checkpoint, fmt.Errorf("cannot restore %s", strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+// CheckpointDestroy keeps the current configuration and destroys checkpoint
+func (c *Client) CheckpointDestroy(checkpoint string) error {
+	obj := c.object(nmPath)
+	err := obj.Call("org.freedesktop.NetworkManager.CheckpointDestroy", 0, dbus.ObjectPath(checkpoint)).Err
+	return opError("CheckpointDestroy", checkpoint, err)
+}
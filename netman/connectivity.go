@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Connectivity is how far the device reaches, as NetworkManager reports it
+// (NM_CONNECTIVITY_*)
+type Connectivity uint32
+
+// Enum of connectivity states
+const (
+	// ConnectivityUnknown means connectivity was not checked
+	ConnectivityUnknown Connectivity = 0
+	// ConnectivityNone means there is no network connection
+	ConnectivityNone Connectivity = 1
+	// ConnectivityPortal means a captive portal intercepts requests, a login
+	// is required to reach the internet
+	ConnectivityPortal Connectivity = 2
+	// ConnectivityLimited means there is a network connection but the
+	// internet is not reachable
+	ConnectivityLimited Connectivity = 3
+	// ConnectivityFull means the internet is reachable
+	ConnectivityFull Connectivity = 4
+)
+
+func (c Connectivity) String() string {
+	switch c {
+	case ConnectivityNone:
+		return "none"
+	case ConnectivityPortal:
+		return "portal"
+	case ConnectivityLimited:
+		return "limited"
+	case ConnectivityFull:
+		return "full"
+	}
+	return "unknown"
+}
+
+// DefaultProbeURL answers 204 No Content when the internet is reachable
+const DefaultProbeURL = "http://connectivity-check.ubuntu.com/"
+
+// ProbeTimeout is the time a probe request may take
+var ProbeTimeout = 10 * time.Second
+
+// probe requests url, without following redirects as captive portals
+// redirect to their login page
+func probe(url string) (*http.Response, error) {
+	client := &http.Client{
+		Timeout: ProbeTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return client.Get(url)
+}
+
+// Probe checks connectivity by requesting url, which must answer 204 No
+// Content. Any other answer, like a redirect to a login page, means a
+// captive portal intercepts requests. No answer means connectivity is
+// limited
+func Probe(url string) Connectivity {
+	resp, err := probe(url)
+	if err != nil {
+		fmt.Printf("== wifi-connect: Connectivity probe %s failed: %v\n", url, err)
+		return ConnectivityLimited
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return ConnectivityFull
+	}
+	return ConnectivityPortal
+}
+
+// PortalURL returns the login page of the captive portal intercepting
+// requests to the probe url. That is where the portal redirects to, or url
+// itself if the portal answers in its place
+func PortalURL(url string) (string, error) {
+	resp, err := probe(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return "", errors.New("no captive portal intercepts requests")
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		login, err := resp.Location()
+		if err != nil {
+			return "", fmt.Errorf("captive portal redirect without location: %v", err)
+		}
+		return login.String(), nil
+	}
+	return url, nil
+}
+
+// Connectivity returns the connectivity found by the last NetworkManager
+// check
+func (c *Client) Connectivity() (Connectivity, error) {
+	obj := c.object(nmPath)
+	v, err := obj.GetProperty("org.freedesktop.NetworkManager.Connectivity")
+	if err != nil {
+		return ConnectivityUnknown, opError("get Connectivity", "", err)
+	}
+	s, ok := v.Value().(uint32)
+	if !ok {
+		return ConnectivityUnknown, opError("get Connectivity", "", fmt.Errorf("unexpected type %T", v.Value()))
+	}
+	return Connectivity(s), nil
+}
+
+// CheckConnectivity makes NetworkManager check connectivity again and
+// returns the result. It is ConnectivityUnknown if checks are disabled in
+// the NetworkManager configuration
+func (c *Client) CheckConnectivity() (Connectivity, error) {
+	obj := c.object(nmPath)
+	var s uint32
+	err := obj.Call("org.freedesktop.NetworkManager.CheckConnectivity", 0).Store(&s)
+	if err != nil {
+		return ConnectivityUnknown, opError("CheckConnectivity", "", err)
+	}
+	return Connectivity(s), nil
+}
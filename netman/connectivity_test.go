@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/online", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/portal", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Please log in"))
+	})
+	ts := httptest.NewServer(mux)
+	url := ts.URL
+
+	cases := map[string]Connectivity{
+		"/online": ConnectivityFull,
+		"/portal": ConnectivityPortal,
+		"/login":  ConnectivityPortal,
+	}
+	for path, expected := range cases {
+		if c := Probe(url + path); c != expected {
+			t.Errorf("%s: expected %v, got %v", path, expected, c)
+		}
+	}
+
+	if login, err := PortalURL(url + "/portal"); err != nil || login != url+"/login" {
+		t.Errorf("Redirect should give the login page, got %q: %v", login, err)
+	}
+	if login, err := PortalURL(url + "/login"); err != nil || login != url+"/login" {
+		t.Errorf("Intercepted probe should be the login page, got %q: %v", login, err)
+	}
+	if _, err := PortalURL(url + "/online"); err == nil {
+		t.Errorf("No login page expected without captive portal")
+	}
+	ts.Close()
+	if c := Probe(url + "/online"); c != ConnectivityLimited {
+		t.Errorf("Unreachable probe should give limited connectivity, got %v", c)
+	}
+}
@@ -20,23 +20,34 @@ package netman
 import (
 	"errors"
 	"fmt"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/godbus/dbus"
 )
 
-// Client type to support unit test mock and runtime execution
+// activateTimeout is the time given to a connection to be activated
+var activateTimeout = 20 * time.Second
+
+// nmPath is the object path of the NetworkManager service
+const nmPath = dbus.ObjectPath("/org/freedesktop/NetworkManager")
+
+// Client type to support unit test mock and runtime execution. It is safe for
+// concurrent use at runtime
 type Client struct {
 	dbusClient DbusClient
+	mu         sync.Mutex
+	// exclusion are the access points left out of scan results
+	exclusion *Exclusion
 }
 
 // DbusClient properties for testing & runtime
 type DbusClient struct {
-	test       bool
-	BusObj     dbus.BusObject
-	Connection *dbus.Conn
+	test    bool
+	BusObj  dbus.BusObject
+	bus     *Bus
+	signals Signaler
 }
 
 // Objecter allows mocking the godbus Object function
@@ -44,23 +55,19 @@ type Objecter interface {
 	Object(dest string, path dbus.ObjectPath) dbus.BusObject
 }
 
-// Object is the mock implementation of the godbus Object function
-func (d *DbusClient) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
-	obj := d.Connection.Object(dest, path)
-	return obj
+// DefaultClient is the runtime client object, using the system bus
+// connection shared by all clients
+func DefaultClient() *Client {
+	return &Client{dbusClient: DbusClient{bus: systemBus}}
 }
 
-// DefaultClient is the runtime client object
-func DefaultClient() *Client {
-	conn := getSystemBus()
-	obj := conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager")
-	return &Client{
-		dbusClient: DbusClient{
-			test:       false,
-			BusObj:     obj,
-			Connection: getSystemBus(),
-		},
-	}
+// NewBusClient returns a client of the NetworkManager service on passed bus
+// connection, eg. a private bus in tests
+func NewBusClient(conn *dbus.Conn) *Client {
+	b := &Bus{}
+	b.conn = conn
+	b.watch(conn)
+	return &Client{dbusClient: DbusClient{bus: b}}
 }
 
 // NewClient is the mocked client object
@@ -73,301 +80,504 @@ func NewClient(myobj dbus.BusObject) *Client {
 	}
 }
 
-// for Non test operation, save the current bus object to the client
+// for test operation, save the current bus object to the client. Test
+// objects implementing Objecter can return a different object per path
 func setObject(c *Client, iface string, path dbus.ObjectPath) {
-	if !c.dbusClient.test {
-		c.dbusClient.BusObj = getSystemBus().Object(iface, path)
+	if o, ok := c.dbusClient.BusObj.(Objecter); ok {
+		c.dbusClient.BusObj = o.Object(iface, path)
 	}
 }
 
-// GetDevices returns NetMan (NetworkManager) devices
-func (c *Client) GetDevices() []string {
-	if !c.dbusClient.test {
-		c.dbusClient.Connection = getSystemBus()
+// object returns the NetworkManager object at path. If the bus is not
+// available the calls on the returned object fail with ErrNoBus
+func (c *Client) object(path dbus.ObjectPath) dbus.BusObject {
+	if c.dbusClient.test {
+		setObject(c, "org.freedesktop.NetworkManager", path)
+		return c.dbusClient.BusObj
+	}
+	conn, err := c.dbusClient.bus.Conn()
+	if err != nil {
+		return &failedObject{path: path, err: err}
 	}
-	c.dbusClient.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager")
-	setObject(c, "org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager")
+	return conn.Object("org.freedesktop.NetworkManager", path)
+}
+
+// failedObject is the object returned while there is no bus connection
+type failedObject struct {
+	path dbus.ObjectPath
+	err  error
+}
+
+func (o *failedObject) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	return &dbus.Call{Path: o.path, Method: method, Args: args, Err: o.err}
+}
+
+func (o *failedObject) Go(method string, flags dbus.Flags, ch chan *dbus.Call, args ...interface{}) *dbus.Call {
+	call := o.Call(method, flags, args...)
+	if ch == nil {
+		ch = make(chan *dbus.Call, 1)
+	}
+	call.Done = ch
+	ch <- call
+	return call
+}
+
+func (o *failedObject) GetProperty(p string) (dbus.Variant, error) {
+	return dbus.Variant{}, o.err
+}
+
+func (o *failedObject) Destination() string {
+	return "org.freedesktop.NetworkManager"
+}
+
+func (o *failedObject) Path() dbus.ObjectPath {
+	return o.path
+}
+
+// Close releases the bus connection of a client returned by NewBusClient.
+// The shared connection of DefaultClient clients is kept
+func (c *Client) Close() {
+	if c.dbusClient.bus != nil && c.dbusClient.bus != systemBus {
+		c.dbusClient.bus.Close()
+	}
+}
+
+// GetDevices returns NetMan (NetworkManager) devices
+func (c *Client) GetDevices() ([]string, error) {
+	obj := c.object(nmPath)
 	var devices []string
-	err := c.dbusClient.BusObj.Call("org.freedesktop.NetworkManager.GetAllDevices", 0).Store(&devices)
+	err := obj.Call("org.freedesktop.NetworkManager.GetAllDevices", 0).Store(&devices)
+	if err != nil {
+		return nil, opError("GetAllDevices", "", err)
+	}
+	return devices, nil
+}
+
+// deviceType returns the type of passed device, one of the DeviceType*
+// values
+func (c *Client) deviceType(device string) (uint32, error) {
+	obj := c.object(dbus.ObjectPath(device))
+	v, err := obj.GetProperty("org.freedesktop.NetworkManager.Device.DeviceType")
 	if err != nil {
-		fmt.Println("== wifi-connect: Error getting devices:", err)
+		return 0, opError("get DeviceType", device, err)
+	}
+	t, ok := v.Value().(uint32)
+	if !ok {
+		return 0, opError("get DeviceType", device, fmt.Errorf("unexpected type %T", v.Value()))
 	}
-	return devices
+	return t, nil
 }
 
 // GetWifiDevices returns wifi NetMan devices
-func (c *Client) GetWifiDevices(devices []string) []string {
+func (c *Client) GetWifiDevices(devices []string) ([]string, error) {
 	var wifiDevices []string
 	for _, d := range devices {
-		objPath := dbus.ObjectPath(d)
-		c.dbusClient.Object("org.freedesktop.NetworkManager", objPath)
-		setObject(c, "org.freedesktop.NetworkManager", objPath)
-		deviceType, err2 := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.Device.DeviceType")
-		if err2 != nil {
-			fmt.Println("== wifi-connect: Error getting wifi devices:", err2)
-			continue
-		}
-		var wifiType uint32
-		wifiType = 2
-		if deviceType.Value() == nil {
-			break
+		t, err := c.deviceType(d)
+		if err != nil {
+			return nil, err
 		}
-		if deviceType.Value() != wifiType {
-			continue
+		if t == DeviceTypeWifi {
+			wifiDevices = append(wifiDevices, d)
 		}
-		wifiDevices = append(wifiDevices, d)
 	}
-	return wifiDevices
+	return wifiDevices, nil
 }
 
-//GetAccessPoints returns NetMan known external APs
-func (c *Client) GetAccessPoints(devices []string, ap2device map[string]string) []string {
+// wifiDevices returns all wifi devices
+func (c *Client) wifiDevices() ([]string, error) {
+	devices, err := c.GetDevices()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetWifiDevices(devices)
+}
+
+// GetAccessPoints returns NetMan known external APs
+func (c *Client) GetAccessPoints(devices []string, ap2device map[string]string) ([]string, error) {
 	var APs []string
 	for _, d := range devices {
 		var aps []string
-		objPath := dbus.ObjectPath(d)
-		c.dbusClient.Object("org.freedesktop.NetworkManager", objPath)
-		setObject(c, "org.freedesktop.NetworkManager", objPath)
-		err := c.dbusClient.BusObj.Call("org.freedesktop.NetworkManager.Device.Wireless.GetAllAccessPoints", 0).Store(&aps)
+		obj := c.object(dbus.ObjectPath(d))
+		err := obj.Call("org.freedesktop.NetworkManager.Device.Wireless.GetAllAccessPoints", 0).Store(&aps)
 		if err != nil {
-			fmt.Println("== wifi-connect: Error getting accesspoints:", err)
-			continue
-		}
-		if len(aps) == 0 {
-			break
+			return nil, opError("GetAllAccessPoints", d, err)
 		}
 		for _, i := range aps {
 			APs = append(APs, i)
 			ap2device[i] = d
 		}
 	}
-	return APs
+	return APs, nil
 }
 
-// SSID holds SSID properties
+// SSID holds SSID properties of an access point
 type SSID struct {
-	Ssid   string
-	ApPath string
+	Ssid     string
+	ApPath   string
+	Security Security
+	Bssid    string
+	// Strength is the signal quality in percent
+	Strength uint8
+	// Frequency is the radio frequency in MHz
+	Frequency uint32
+	Channel   int
+	// MaxBitrate is the maximum bitrate in Kb/s
+	MaxBitrate uint32
+	// LastSeen is the time in seconds since boot (CLOCK_BOOTTIME) the
+	// access point was last found in a scan, -1 if never
+	LastSeen int32
+	// BSSes are the access points of the network, strongest first. The
+	// fields above are the ones of the strongest
+	BSSes []SSID
 }
 
-// getSsids returns known NetMan SSIDs
-func (c *Client) getSsids(APs []string, ssid2ap map[string]string) []SSID {
-	var SSIDs []SSID
+// getSsids returns known NetMan SSIDs, one per network as grouped by
+// GroupNetworks. The strongest network of each ssid is set in ssid2ap for
+// activation. Access points excluded with SetExclusion are left out
+func (c *Client) getSsids(APs []string, ssid2ap map[string]string) ([]SSID, error) {
+	var found []SSID
+	exclusion := c.getExclusion()
 	for _, ap := range APs {
-		objPath := dbus.ObjectPath(ap)
-		c.dbusClient.Object("org.freedesktop.NetworkManager", objPath)
-		setObject(c, "org.freedesktop.NetworkManager", objPath)
-		ssid, err := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.AccessPoint.Ssid")
+		Ssid, err := c.accessPoint(ap)
+		if errors.Is(err, ErrNoBus) {
+			return nil, err
+		}
 		if err != nil {
+			// access points come and go between scans
 			fmt.Println("== wifi-connect: Error getting accesspoint's ssids:", err)
 			continue
 		}
-		type B []byte
-		res := B(ssid.Value().([]byte))
-		ssidStr := string(res)
-		if len(ssidStr) < 1 {
+		if len(Ssid.Ssid) < 1 {
 			continue
 		}
-		for _, s := range SSIDs {
-			if s.Ssid == ssidStr {
-				continue
-			}
+		if exclusion.Excludes(Ssid) {
+			continue
+		}
+		found = append(found, Ssid)
+	}
+	SSIDs := GroupNetworks(found)
+	strongest := make(map[string]uint8)
+	for _, s := range SSIDs {
+		// the strongest network is used for an ssid announced with
+		// different securities
+		name := strings.TrimSpace(s.Ssid)
+		if strength, ok := strongest[name]; !ok || s.Strength > strength {
+			strongest[name] = s.Strength
+			ssid2ap[name] = s.ApPath
 		}
-		Ssid := SSID{Ssid: ssidStr, ApPath: ap}
-		SSIDs = append(SSIDs, Ssid)
-		ssid2ap[strings.TrimSpace(ssidStr)] = ap
-		//TODO: exclude ssid of device's own AP (the wifi-ap one)
 	}
-	return SSIDs
+	return SSIDs, nil
 }
 
-// ConnectAp attempts to Connect to an external AP
-func (c *Client) ConnectAp(ssid string, p string, ap2device map[string]string, ssid2ap map[string]string) error {
-	inner1 := make(map[string]dbus.Variant)
-	inner1["security"] = dbus.MakeVariant("802-11-wireless-security")
+// sameSsid returns true if a and b name the same network. Surrounding spaces
+// are ignored, as in the keys of ssid2ap
+func sameSsid(a string, b string) bool {
+	return strings.TrimSpace(a) == strings.TrimSpace(b)
+}
+
+// ConnectAp attempts to Connect to an external AP. The security settings
+// are chosen from the security the AP advertises. An SSID that has not been
+// scanned is joined as a hidden network. cfg optionally sets the IP
+// configuration
+func (c *Client) ConnectAp(ssid string, p string, ap2device map[string]string, ssid2ap map[string]string, cfg *NetworkConfig) error {
+	ap, ok := ssid2ap[ssid]
+	if !ok {
+		sec := SecurityWpaPsk
+		if p == "" {
+			sec = SecurityOpen
+		}
+		return c.ConnectHiddenAp(ssid, p, sec, "", cfg)
+	}
+	sec, err := c.apSecurity(ap)
+	if err != nil {
+		// keep previous behaviour if the AP flags cannot be read
+		fmt.Println("== wifi-connect: Error getting accesspoint's security:", err)
+		sec = SecurityWpaPsk
+		if p == "" {
+			sec = SecurityOpen
+		}
+	}
+	outer, err := wirelessSettings(sec, p)
+	if err != nil {
+		return err
+	}
+	err = cfg.apply(outer)
+	if err != nil {
+		return err
+	}
 
-	inner2 := make(map[string]dbus.Variant)
-	inner2["key-mgmt"] = dbus.MakeVariant("wpa-psk")
-	inner2["psk"] = dbus.MakeVariant(p)
+	return c.activate(ssid, outer, ap2device[ap], ap)
+}
+
+// wirelessSettings returns the connection settings for a network with passed
+// security and passphrase
+func wirelessSettings(sec Security, p string) (map[string]map[string]dbus.Variant, error) {
+	inner2, err := securitySettings(sec, p)
+	if err != nil {
+		return nil, err
+	}
 
+	inner1 := make(map[string]dbus.Variant)
 	outer := make(map[string]map[string]dbus.Variant)
+	if inner2 != nil {
+		inner1["security"] = dbus.MakeVariant("802-11-wireless-security")
+		outer["802-11-wireless-security"] = inner2
+	}
 	outer["802-11-wireless"] = inner1
-	outer["802-11-wireless-security"] = inner2
+	return outer, nil
+}
+
+// activate activates a connection for ssid with passed settings on device and
+// waits until it is connected, returning the failure reason otherwise. An
+// existing saved profile for ssid is updated and reused, else a new one is
+// added. ap is the access point to use, "/" lets NetworkManager choose it
+func (c *Client) activate(ssid string, outer map[string]map[string]dbus.Variant, device string, ap string) error {
+	// subscribe before activating so that no state change is missed
+	sub, err := c.Subscribe()
+	if err != nil {
+		fmt.Println("== wifi-connect: Cannot follow connection state, polling instead:", err)
+	} else {
+		defer sub.Close()
+	}
+
+	profile, previous, err := c.reuseProfile(ssid, outer)
+	if err != nil {
+		fmt.Println("== wifi-connect: Cannot reuse saved profile, adding a new one:", err)
+		profile = ""
+	}
 
-	c.dbusClient.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager")
-	setObject(c, "org.freedesktop.NetworkManager", dbus.ObjectPath("/org/freedesktop/NetworkManager"))
-	c.dbusClient.BusObj.Call("org.freedesktop.NetworkManager.AddAndActivateConnection", 0, outer, dbus.ObjectPath(ap2device[ssid2ap[ssid]]), dbus.ObjectPath(ssid2ap[ssid]))
+	var call *dbus.Call
+	obj := c.object(nmPath)
+	if profile != "" {
+		call = obj.Call("org.freedesktop.NetworkManager.ActivateConnection", 0, dbus.ObjectPath(profile), dbus.ObjectPath(device), dbus.ObjectPath(ap))
+	} else {
+		call = obj.Call("org.freedesktop.NetworkManager.AddAndActivateConnection", 0, outer, dbus.ObjectPath(device), dbus.ObjectPath(ap))
+	}
 
-	// loop until connected or until max loops
-	trying := true
-	idx := -1
-	for trying {
-		idx++
-		time.Sleep(1000 * time.Millisecond)
-		if c.Connected(c.GetWifiDevices(c.GetDevices())) {
-			return nil
-		}
-		if idx == 19 {
-			return errors.New("wifi-connect: cannot connect to AP")
+	if call.Err != nil {
+		fmt.Printf("== wifi-connect: Error activating connection to %s: %v\n", ssid, call.Err)
+		c.restoreProfile(profile, previous)
+		return ErrConnectFailed
+	}
+	var active string
+	if len(call.Body) > 0 {
+		// the active connection is the last returned value of both calls
+		active = fmt.Sprint(call.Body[len(call.Body)-1])
+	}
+
+	// wait until connected, failed or until timeout
+	err = c.waitActivation(sub, device, active, activateTimeout)
+	if err == nil {
+		return nil
+	}
+	fmt.Printf("== wifi-connect: Cannot connect to %s: %v\n", ssid, err)
+	// do not leave the profile of a failed attempt behind, nor lose the
+	// settings of a saved one that worked before
+	c.restoreProfile(profile, previous)
+	if profile == "" && len(call.Body) > 0 {
+		if path, ok := call.Body[0].(dbus.ObjectPath); ok {
+			err := c.deleteConnection(string(path))
+			if err != nil {
+				fmt.Println("== wifi-connect: Error deleting failed connection:", err)
+			}
 		}
 	}
-	return nil
+	return err
+}
+
+// restoreProfile puts back the previous settings of a reused profile, if any
+func (c *Client) restoreProfile(profile string, previous map[string]map[string]dbus.Variant) {
+	if profile == "" || previous == nil {
+		return
+	}
+	if err := c.updateConnection(profile, previous, false); err != nil {
+		fmt.Println("== wifi-connect: Error restoring the saved profile:", err)
+	}
 }
 
-func getSystemBus() *dbus.Conn {
-	conn, err := dbus.SystemBus()
+// Ssids returns known SSIDs, strongest first
+func (c *Client) Ssids() ([]SSID, map[string]string, map[string]string, error) {
+	wifiDevices, err := c.wifiDevices()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "== wifi-connect: Error: Failed to connect to system bus:", err)
-		panic(1)
+		return nil, nil, nil, err
 	}
-	return conn
+	return c.devicesSsids(wifiDevices)
 }
 
-// Ssids returns known SSIDs
-func (c *Client) Ssids() ([]SSID, map[string]string, map[string]string) {
+// DeviceSsids returns the SSIDs found by the wifi device, strongest first, so
+// that they are joined with that device
+func (c *Client) DeviceSsids(device string) ([]SSID, map[string]string, map[string]string, error) {
+	return c.devicesSsids([]string{device})
+}
+
+// devicesSsids returns the SSIDs found by passed wifi devices, strongest
+// first, and the maps of their access points to devices and of SSIDs to
+// access points
+func (c *Client) devicesSsids(wifiDevices []string) ([]SSID, map[string]string, map[string]string, error) {
 	ap2device := make(map[string]string)
 	ssid2ap := make(map[string]string)
-	devices := c.GetDevices()
-	wifiDevices := c.GetWifiDevices(devices)
-	APs := c.GetAccessPoints(wifiDevices, ap2device)
-	SSIDs := c.getSsids(APs, ssid2ap)
-	return SSIDs, ap2device, ssid2ap
+	APs, err := c.GetAccessPoints(wifiDevices, ap2device)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	SSIDs, err := c.getSsids(APs, ssid2ap)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	SortBySignal(SSIDs)
+	return SSIDs, ap2device, ssid2ap, nil
 }
 
 // Connected checks if any passed ethernet/wifi devices are connected
-func (c *Client) Connected(devices []string) bool {
+func (c *Client) Connected(devices []string) (bool, error) {
 	for _, d := range devices {
-		objPath := dbus.ObjectPath(d)
-		c.dbusClient.Object("org.freedesktop.NetworkManager", objPath)
-		setObject(c, "org.freedesktop.NetworkManager", objPath)
-		dType, err := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.Device.DeviceType")
+		dType, err := c.deviceType(d)
 		if err != nil {
-			fmt.Println("== wifi-connect: Error getting device type:", err)
-			continue
+			return false, err
 		}
-		state, err2 := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.Device.State")
-		if err2 != nil {
-			fmt.Println("== wifi-connect: Error getting device state:", err2)
-			continue
+		state, err := c.deviceState(d)
+		if err != nil {
+			return false, err
 		}
 		// only handle eth and wifi device type
-		if dbus.Variant.Value(dType) != uint32(1) && dbus.Variant.Value(dType) != uint32(2) {
+		if dType != DeviceTypeEthernet && dType != DeviceTypeWifi {
 			continue
 		}
-		if dbus.Variant.Value(state) == uint32(100) {
-			return true
+		if state == DeviceStateActivated {
+			return true, nil
 		}
 	}
-	return false
+	return false, nil
 }
 
 // ConnectedWifi checks if any passed wifi devices are connected
-func (c *Client) ConnectedWifi(wifiDevices []string) bool {
+func (c *Client) ConnectedWifi(wifiDevices []string) (bool, error) {
 	for _, d := range wifiDevices {
-		objPath := dbus.ObjectPath(d)
-		c.dbusClient.Object("org.freedesktop.NetworkManager", objPath)
-		setObject(c, "org.freedesktop.NetworkManager", objPath)
-		state, err := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.Device.State")
+		state, err := c.deviceState(d)
+		if err != nil {
+			return false, err
+		}
+		if state == DeviceStateActivated {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ConnectedEthernet checks if any passed ethernet devices are connected
+func (c *Client) ConnectedEthernet(devices []string) (bool, error) {
+	for _, d := range devices {
+		dType, err := c.deviceType(d)
 		if err != nil {
-			fmt.Println("== wifi-connect: Error getting device state:", err)
+			return false, err
+		}
+		if dType != DeviceTypeEthernet {
 			continue
 		}
-		if dbus.Variant.Value(state) == uint32(100) {
-			return true
+		state, err := c.deviceState(d)
+		if err != nil {
+			return false, err
+		}
+		if state == DeviceStateActivated {
+			return true, nil
 		}
 	}
-	return false
+	return false, nil
 }
 
 // DisconnectWifi disconnects every interface passed. return shows number of disconnect calls  made
-func (c *Client) DisconnectWifi(wifiDevices []string) int {
+func (c *Client) DisconnectWifi(wifiDevices []string) (int, error) {
 	ran := 0
 	for _, d := range wifiDevices {
-		objPath := dbus.ObjectPath(d)
-		c.dbusClient.Object("org.freedesktop.NetworkManager", objPath)
-		setObject(c, "org.freedesktop.NetworkManager", objPath)
-		c.dbusClient.BusObj.Call("org.freedesktop.NetworkManager.Device.Disconnect", 0)
+		obj := c.object(dbus.ObjectPath(d))
+		err := obj.Call("org.freedesktop.NetworkManager.Device.Disconnect", 0).Err
+		if errors.Is(err, ErrNoBus) {
+			return ran, opError("Disconnect", d, err)
+		}
+		// devices not connected refuse to disconnect, which is fine
 		ran++
 	}
-	return ran
+	return ran, nil
 }
 
-// SetIfaceManaged sets passed device to be managed/unmanaged by network manager, return iface set, if any
-func (c *Client) SetIfaceManaged(iface string, state bool, devices []string) string {
+// deviceManaged returns true if passed device is managed
+func (c *Client) deviceManaged(device string) (bool, error) {
+	obj := c.object(dbus.ObjectPath(device))
+	v, err := obj.GetProperty("org.freedesktop.NetworkManager.Device.Managed")
+	if err != nil {
+		return false, opError("get Managed", device, err)
+	}
+	managed, ok := v.Value().(bool)
+	if !ok {
+		return false, opError("get Managed", device, fmt.Errorf("unexpected type %T", v.Value()))
+	}
+	return managed, nil
+}
+
+// SetIfaceManaged sets passed device to be managed/unmanaged by network
+// manager, return iface set, if any. An interface not found among devices is
+// reported with ErrNoDevice
+func (c *Client) SetIfaceManaged(iface string, state bool, devices []string) (string, error) {
 	for _, d := range devices {
-		objPath := dbus.ObjectPath(d)
-		c.dbusClient.Object("org.freedesktop.NetworkManager", objPath)
-		setObject(c, "org.freedesktop.NetworkManager", objPath)
-		intface, err2 := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.Device.Interface")
-		if err2 != nil {
-			fmt.Printf("== wifi-connect: Error in SetIfaceManaged() geting interface: %v\n", err2)
-			return ""
-		}
-		if iface != intface.Value().(string) {
+		intface, err := c.deviceInterface(d)
+		if err != nil {
+			return "", err
+		}
+		if iface != intface {
 			continue
 		}
-		managed, err := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.Device.Managed")
+		managed, err := c.deviceManaged(d)
 		if err != nil {
-			fmt.Printf("== wifi-connect: Error in SetIfaceManaged() fetching device managed: %v\n", err)
-			return ""
+			return "", err
 		}
-		switch state {
-		case true:
-			if managed.Value().(bool) == true {
-				return "" //no need to set, already managed
-			}
-		case false:
-			if managed.Value().(bool) == false {
-				return "" //no need to set, already UNmanaged
-			}
+		if managed == state {
+			return "", nil //no need to set, already in state
 		}
 
-		c.dbusClient.BusObj.Call("org.freedesktop.DBus.Properties.Set", 0, "org.freedesktop.NetworkManager.Device", "Managed", dbus.MakeVariant(state))
-		// loop until interface is in desired managed state or max iters reached
-		idx := -1
-		for {
-			idx++
-			time.Sleep(1000 * time.Millisecond)
-			managedState, err := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.Device.State")
-			if err == nil {
-				switch state {
-				case true:
-					if managedState.Value() == uint32(30) { //NM_DEVICE_STATE_DISCONNECTED
-						return iface
-					}
-				case false:
-					if managedState.Value() == uint32(10) { //NM_DEVICE_STATE_UNMANAGED
-						return iface
-					}
-				}
-			}
-			if idx == 59 { //give it 60 iters ~= one minute
-				break
+		// subscribe before changing managed state so that no state change is missed
+		sub, err := c.Subscribe()
+		if err != nil {
+			fmt.Println("== wifi-connect: Cannot follow device state, polling instead:", err)
+		}
+		obj := c.object(dbus.ObjectPath(d))
+		err = obj.Call("org.freedesktop.DBus.Properties.Set", 0, "org.freedesktop.NetworkManager.Device", "Managed", dbus.MakeVariant(state)).Err
+		if err != nil {
+			if sub != nil {
+				sub.Close()
 			}
+			return "", opError("set Managed", d, err)
+		}
+		// wait until interface is in desired managed state or one minute passed
+		target := DeviceStateDisconnected
+		if !state {
+			target = DeviceStateUnmanaged
+		}
+		reached := c.waitDeviceState(sub, d, 60*time.Second, true, target)
+		if sub != nil {
+			sub.Close()
 		}
+		if reached {
+			return iface, nil
+		}
+		return "", opError("set Managed", d, ErrTimeout)
 	}
-	return "" //no iface state changed
+	return "", opError("set Managed", iface, ErrNoDevice)
 }
 
 // WifisManaged returns  map[iface]device of wifi iterfaces that are managed by network manager
 func (c *Client) WifisManaged(wifiDevices []string) (map[string]string, error) {
 	ifaces := make(map[string]string)
 	for _, d := range wifiDevices {
-		objPath := dbus.ObjectPath(d)
-		c.dbusClient.Object("org.freedesktop.NetworkManager", objPath)
-		setObject(c, "org.freedesktop.NetworkManager", objPath)
-		managed, err := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.Device.Managed")
+		managed, err := c.deviceManaged(d)
 		if err != nil {
-			fmt.Printf("== wifi-connect: Error in WifisManaged() getting device managed : %v\n", err)
 			return ifaces, err
 		}
-		iface, err2 := c.dbusClient.BusObj.GetProperty("org.freedesktop.NetworkManager.Device.Interface")
-		if err2 != nil {
-			fmt.Printf("== wifi-connect: Error in WifisManaged() getting device interface: %v\n", err)
-			return ifaces, err2
+		iface, err := c.deviceInterface(d)
+		if err != nil {
+			return ifaces, err
 		}
-		if managed.Value().(bool) == true {
-			ifaces[iface.Value().(string)] = d
+		if managed {
+			ifaces[iface] = d
 		}
 	}
 	return ifaces, nil
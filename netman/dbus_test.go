@@ -51,6 +51,15 @@ type mockObj struct {
 	ifaces      []string
 	managed     bool
 	connect     bool
+	settings    map[string]map[string]dbus.Variant
+	device      dbus.ObjectPath
+	ap          dbus.ObjectPath
+	apFlags     uint32
+	wpaFlags    uint32
+	rsnFlags    uint32
+	lastScan    int64
+	scanning    bool
+	deleted     []dbus.ObjectPath
 }
 
 func (mock *mockObj) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
@@ -72,6 +81,30 @@ func (mock *mockObj) Call(method string, flags dbus.Flags, args ...interface{})
 		mock.aps = append(mock.aps, ap2)
 		body := []interface{}{aps}
 		call.Body = body
+	case "org.freedesktop.DBus.Properties.GetAll":
+		props := make(map[string]dbus.Variant)
+		for _, p := range []string{"Ssid", "Flags", "WpaFlags", "RsnFlags", "HwAddress", "Strength", "Frequency", "MaxBitrate", "LastSeen"} {
+			v, err := mock.GetProperty(args[0].(string) + "." + p)
+			if err == nil {
+				props[p] = v
+			}
+		}
+		call.Body = []interface{}{props}
+	case "org.freedesktop.NetworkManager.AddAndActivateConnection":
+		mock.settings = args[0].(map[string]map[string]dbus.Variant)
+		mock.device = args[1].(dbus.ObjectPath)
+		mock.ap = args[2].(dbus.ObjectPath)
+		call.Body = []interface{}{dbus.ObjectPath("/s/new"), dbus.ObjectPath("/a/new")}
+	case "org.freedesktop.NetworkManager.Settings.ListConnections":
+		call.Body = []interface{}{[]dbus.ObjectPath{}}
+	case "org.freedesktop.NetworkManager.Settings.Connection.Delete":
+		mock.deleted = append(mock.deleted, mock.Path())
+	case "org.freedesktop.NetworkManager.Device.Wireless.RequestScan":
+		if mock.scanning {
+			call.Err = errors.New("scanning not allowed while already scanning")
+			break
+		}
+		mock.lastScan++
 	case "org.freedesktop.NetworkManager.Device.Disconnect":
 	}
 	return call
@@ -95,6 +128,24 @@ func (mock *mockObj) GetProperty(p string) (dbus.Variant, error) {
 		ssidB := []byte(ssid)
 		mock.ssids = append(mock.ssids, ssidB)
 		return dbus.MakeVariant(ssidB), nil
+	case "org.freedesktop.NetworkManager.Device.Wireless.LastScan":
+		return dbus.MakeVariant(mock.lastScan), nil
+	case "org.freedesktop.NetworkManager.AccessPoint.HwAddress":
+		return dbus.MakeVariant(fmt.Sprintf("00:11:22:33:44:%02d", len(mock.ssids))), nil
+	case "org.freedesktop.NetworkManager.AccessPoint.Strength":
+		return dbus.MakeVariant(uint8(20 * len(mock.ssids))), nil
+	case "org.freedesktop.NetworkManager.AccessPoint.Frequency":
+		return dbus.MakeVariant(uint32(2437)), nil
+	case "org.freedesktop.NetworkManager.AccessPoint.MaxBitrate":
+		return dbus.MakeVariant(uint32(54000)), nil
+	case "org.freedesktop.NetworkManager.AccessPoint.LastSeen":
+		return dbus.MakeVariant(int32(1000)), nil
+	case "org.freedesktop.NetworkManager.AccessPoint.Flags":
+		return dbus.MakeVariant(mock.apFlags), nil
+	case "org.freedesktop.NetworkManager.AccessPoint.WpaFlags":
+		return dbus.MakeVariant(mock.wpaFlags), nil
+	case "org.freedesktop.NetworkManager.AccessPoint.RsnFlags":
+		return dbus.MakeVariant(mock.rsnFlags), nil
 	case "org.freedesktop.NetworkManager.Device.State":
 		if mock.connect {
 			return dbus.MakeVariant(uint32(100)), nil
@@ -138,7 +189,10 @@ func (mock *mockObj) systemBus() (*dbus.Conn, error) {
 
 func TestGetDevices(t *testing.T) {
 	client := NewClient(&mockObj{})
-	devices := client.GetDevices()
+	devices, err := client.GetDevices()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 	found1 := false
 	found2 := false
 	found3 := false
@@ -160,8 +214,11 @@ func TestGetDevices(t *testing.T) {
 
 func TestGetWifiDevices(t *testing.T) {
 	client := NewClient(&mockObj{})
-	devices := client.GetDevices()
-	wifiDevices := client.GetWifiDevices(devices)
+	devices, _ := client.GetDevices()
+	wifiDevices, err := client.GetWifiDevices(devices)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 	found1 := false
 	found2 := false
 	for _, v := range devices {
@@ -183,10 +240,13 @@ func TestGetWifiDevices(t *testing.T) {
 
 func TestGetAPs(t *testing.T) {
 	client := NewClient(&mockObj{})
-	devices := client.GetDevices()
+	devices, _ := client.GetDevices()
 	ap2device := make(map[string]string)
-	wifiDevices := client.GetWifiDevices(devices)
-	aps := client.GetAccessPoints(wifiDevices, ap2device)
+	wifiDevices, _ := client.GetWifiDevices(devices)
+	aps, err := client.GetAccessPoints(wifiDevices, ap2device)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 	if len(aps) != 4 {
 		t.Errorf("4 APs  should have been found, but found: %d", len(aps))
 	}
@@ -195,12 +255,15 @@ func TestGetAPs(t *testing.T) {
 
 func TestGetSsids(t *testing.T) {
 	client := NewClient(&mockObj{})
-	devices := client.GetDevices()
-	wifiDevices := client.GetWifiDevices(devices)
+	devices, _ := client.GetDevices()
+	wifiDevices, _ := client.GetWifiDevices(devices)
 	ap2device := make(map[string]string)
 	ssid2ap := make(map[string]string)
-	aps := client.GetAccessPoints(wifiDevices, ap2device)
-	ssids := client.getSsids(aps, ssid2ap)
+	aps, _ := client.GetAccessPoints(wifiDevices, ap2device)
+	ssids, err := client.getSsids(aps, ssid2ap)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 	if len(ssids) != 4 {
 		t.Errorf("4 SSIDs should have been found, but found: %d", len(ssids))
 	}
@@ -209,7 +272,10 @@ func TestGetSsids(t *testing.T) {
 
 func TestSsids(t *testing.T) {
 	client := NewClient(&mockObj{})
-	ssids, _, _ := client.Ssids()
+	ssids, _, _, err := client.Ssids()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 	if len(ssids) != 4 {
 		t.Errorf("4 SSIDs should have been found, but found: %d", len(ssids))
 	}
@@ -220,10 +286,10 @@ func TestConnected(t *testing.T) {
 	mock := &mockObj{}
 	mock.connect = true
 	client := NewClient(mock)
-	if !client.Connected([]string{"d1"}) {
+	if connected, err := client.Connected([]string{"d1"}); !connected || err != nil {
 		t.Errorf("Should have found connected state, but did not")
 	}
-	if client.Connected([]string{}) {
+	if connected, _ := client.Connected([]string{}); connected {
 		t.Errorf("Should have found no connection since there are no devices, but did not")
 	}
 }
@@ -232,21 +298,33 @@ func TestConnectedWifi(t *testing.T) {
 	mock := &mockObj{}
 	mock.connect = true
 	client := NewClient(mock)
-	if !client.ConnectedWifi([]string{"d1"}) {
+	if connected, err := client.ConnectedWifi([]string{"d1"}); !connected || err != nil {
 		t.Errorf("Should have found Wificonnected state, but did not")
 	}
-	if client.ConnectedWifi([]string{}) {
+	if connected, _ := client.ConnectedWifi([]string{}); connected {
 		t.Errorf("Should have found no connection since there are no devices, but did not")
 	}
 }
 
+func TestConnectedEthernet(t *testing.T) {
+	mock := &mockObj{}
+	mock.connect = true
+	client := NewClient(mock)
+	if connected, _ := client.ConnectedEthernet([]string{"d1"}); connected {
+		t.Errorf("Should have found no ethernet connection since d1 is wifi, but did not")
+	}
+	if connected, err := client.ConnectedEthernet([]string{"d2", "d3"}); !connected || err != nil {
+		t.Errorf("Should have found ethernet connected state, but did not")
+	}
+}
+
 func TestiDiscconnectWifi(t *testing.T) {
 	client := NewClient(&mockObj{})
-	res := client.DisconnectWifi([]string{})
+	res, _ := client.DisconnectWifi([]string{})
 	if res != 0 {
 		t.Errorf("0 Disconnect call expected, but found: %d", res)
 	}
-	res = client.DisconnectWifi([]string{"d1"})
+	res, _ = client.DisconnectWifi([]string{"d1"})
 	if res != 1 {
 		t.Errorf("1 Disconnect call expected, but found: %d", res)
 	}
@@ -255,35 +333,35 @@ func TestiDiscconnectWifi(t *testing.T) {
 func TestSetIfaceManaged(t *testing.T) {
 	mock := &mockObj{}
 	client := NewClient(mock)
-	res := client.SetIfaceManaged("notaniface", true, []string{})
-	if res != "" {
+	res, err := client.SetIfaceManaged("notaniface", true, []string{})
+	if res != "" || !errors.Is(err, ErrNoDevice) {
 		t.Errorf("1: No iface expected, but found: %s", res)
 	}
-	res = client.SetIfaceManaged("iface2", true, []string{"d0", "d1"})
-	if res != "" {
+	res, err = client.SetIfaceManaged("iface2", true, []string{"d0", "d1"})
+	if res != "" || !errors.Is(err, ErrNoDevice) {
 		t.Errorf("2: No iface expected, but found: %s", res)
 	}
 	mock.ifaces = mock.ifaces[:0]
-	res = client.SetIfaceManaged("iface0", true, []string{"d0"})
-	if res != "iface0" {
+	res, err = client.SetIfaceManaged("iface0", true, []string{"d0"})
+	if res != "iface0" || err != nil {
 		t.Errorf("3: iface0 expected, but found: %s", res)
 	}
 	mock.ifaces = mock.ifaces[:0]
 	mock.managed = true
-	res = client.SetIfaceManaged("iface0", false, []string{"d0"})
-	if res != "iface0" {
+	res, err = client.SetIfaceManaged("iface0", false, []string{"d0"})
+	if res != "iface0" || err != nil {
 		t.Errorf("4: iface0 expected, but found: %s", res)
 	}
 	mock.ifaces = mock.ifaces[:0]
 	mock.managed = false
-	res = client.SetIfaceManaged("iface1", true, []string{"d0", "d1"})
-	if res != "iface1" {
+	res, err = client.SetIfaceManaged("iface1", true, []string{"d0", "d1"})
+	if res != "iface1" || err != nil {
 		t.Errorf("5: iface1 expected, but found: %s", res)
 	}
 	mock.ifaces = mock.ifaces[:0]
 	mock.managed = true
-	res = client.SetIfaceManaged("iface1", true, []string{"d0", "d1", "d3"})
-	if res != "" {
+	res, err = client.SetIfaceManaged("iface1", true, []string{"d0", "d1", "d3"})
+	if res != "" || err != nil {
 		t.Errorf("6: No iface excepted: %s", res)
 	}
 }
@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus"
+)
+
+// WifiCapabilities are the WirelessCapabilities flags of a wifi device
+// (NM_WIFI_DEVICE_CAP_*)
+type WifiCapabilities uint32
+
+// Wifi device capabilities
+const (
+	WifiCapWep40     WifiCapabilities = 0x1
+	WifiCapWep104    WifiCapabilities = 0x2
+	WifiCapTkip      WifiCapabilities = 0x4
+	WifiCapCcmp      WifiCapabilities = 0x8
+	WifiCapWpa       WifiCapabilities = 0x10
+	WifiCapRsn       WifiCapabilities = 0x20
+	WifiCapAp        WifiCapabilities = 0x40
+	WifiCapAdhoc     WifiCapabilities = 0x80
+	WifiCapFreqValid WifiCapabilities = 0x100
+	WifiCap2GHz      WifiCapabilities = 0x200
+	WifiCap5GHz      WifiCapabilities = 0x400
+	WifiCapMesh      WifiCapabilities = 0x1000
+)
+
+var wifiCapNames = []struct {
+	c    WifiCapabilities
+	name string
+}{
+	{WifiCapAp, "ap"},
+	{WifiCapAdhoc, "adhoc"},
+	{WifiCapMesh, "mesh"},
+	{WifiCap2GHz, "2.4ghz"},
+	{WifiCap5GHz, "5ghz"},
+	{WifiCapWpa, "wpa"},
+	{WifiCapRsn, "wpa2"},
+	{WifiCapWep40, "wep40"},
+	{WifiCapWep104, "wep104"},
+	{WifiCapTkip, "tkip"},
+	{WifiCapCcmp, "ccmp"},
+}
+
+// Ap returns true if the device supports AP mode
+func (c WifiCapabilities) Ap() bool {
+	return c&WifiCapAp != 0
+}
+
+// Band5GHz returns true if the device supports the 5 GHz band. Unknown if
+// the frequency flags are not valid
+func (c WifiCapabilities) Band5GHz() bool {
+	return c&WifiCapFreqValid != 0 && c&WifiCap5GHz != 0
+}
+
+// Names returns the names of the capabilities, eg. "ap" or "5ghz". The bands
+// are only listed if known
+func (c WifiCapabilities) Names() []string {
+	names := []string{}
+	for _, n := range wifiCapNames {
+		if (n.c == WifiCap2GHz || n.c == WifiCap5GHz) && c&WifiCapFreqValid == 0 {
+			continue
+		}
+		if c&n.c != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// deviceTypeNames are the names of the common device types
+var deviceTypeNames = map[uint32]string{
+	0:                  "unknown",
+	DeviceTypeEthernet: "ethernet",
+	DeviceTypeWifi:     "wifi",
+	5:                  "bluetooth",
+	8:                  "modem",
+	10:                 "bond",
+	11:                 "vlan",
+	13:                 "bridge",
+	14:                 "generic",
+	15:                 "team",
+	16:                 "tun",
+	29:                 "wireguard",
+	32:                 "loopback",
+}
+
+// DeviceTypeName returns the name of the device type t, eg. "wifi"
+func DeviceTypeName(t uint32) string {
+	if name, ok := deviceTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type %d", t)
+}
+
+var deviceStateNames = map[uint32]string{
+	DeviceStateUnknown:      "unknown",
+	DeviceStateUnmanaged:    "unmanaged",
+	DeviceStateUnavailable:  "unavailable",
+	DeviceStateDisconnected: "disconnected",
+	DeviceStatePrepare:      "prepare",
+	DeviceStateConfig:       "config",
+	DeviceStateNeedAuth:     "need-auth",
+	DeviceStateIPConfig:     "ip-config",
+	DeviceStateIPCheck:      "ip-check",
+	DeviceStateSecondaries:  "secondaries",
+	DeviceStateActivated:    "activated",
+	DeviceStateDeactivating: "deactivating",
+	DeviceStateFailed:       "failed",
+}
+
+// DeviceStateName returns the name of the device state s, eg. "activated"
+func DeviceStateName(s uint32) string {
+	if name, ok := deviceStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("state %d", s)
+}
+
+// Device describes a network device known to NetworkManager
+type Device struct {
+	Path      string
+	Interface string
+	Driver    string
+	HwAddress string
+	// Type is one of the DeviceType* values
+	Type uint32
+	// State is one of the DeviceState* values
+	State   uint32
+	Managed bool
+	// Capabilities are only set for wifi devices
+	Capabilities WifiCapabilities
+}
+
+// deviceProperty reads the property name of the Device interface of device
+// into v
+func (c *Client) deviceProperty(device string, name string, v interface{}) error {
+	obj := c.object(dbus.ObjectPath(device))
+	p, err := obj.GetProperty("org.freedesktop.NetworkManager.Device." + name)
+	if err != nil {
+		return opError("get "+name, device, err)
+	}
+	if err = dbus.Store([]interface{}{p.Value()}, v); err != nil {
+		return opError("get "+name, device, err)
+	}
+	return nil
+}
+
+// DeviceInfo returns the description of passed device
+func (c *Client) DeviceInfo(device string) (Device, error) {
+	d := Device{Path: device}
+	props := []struct {
+		name string
+		v    interface{}
+	}{
+		{"Interface", &d.Interface},
+		{"Driver", &d.Driver},
+		{"DeviceType", &d.Type},
+		{"State", &d.State},
+		{"Managed", &d.Managed},
+	}
+	for _, p := range props {
+		if err := c.deviceProperty(device, p.name, p.v); err != nil {
+			return d, err
+		}
+	}
+	// virtual devices may have none
+	d.HwAddress, _ = c.deviceHwAddress(device)
+	if d.Type == DeviceTypeWifi {
+		caps, err := c.wifiCapabilities(device)
+		if err != nil {
+			return d, err
+		}
+		d.Capabilities = caps
+	}
+	return d, nil
+}
+
+// Devices returns the description of all devices
+func (c *Client) Devices() ([]Device, error) {
+	paths, err := c.GetDevices()
+	if err != nil {
+		return nil, err
+	}
+	devices := []Device{}
+	for _, p := range paths {
+		d, err := c.DeviceInfo(p)
+		if err != nil {
+			return devices, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
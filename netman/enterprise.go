@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// Supported EAP methods
+const (
+	EapPeap = "peap"
+	EapTtls = "ttls"
+	EapTLS  = "tls"
+)
+
+// EnterpriseCredentials holds the 802.1X credentials needed to join a
+// WPA/WPA2-Enterprise network. Certificate and key fields are file paths
+type EnterpriseCredentials struct {
+	Eap                string
+	Identity           string
+	AnonymousIdentity  string
+	Password           string
+	CaCert             string
+	ClientCert         string
+	PrivateKey         string
+	PrivateKeyPassword string
+	// Phase2Auth is the inner authentication for PEAP and TTLS, eg. mschapv2
+	Phase2Auth string
+}
+
+// certPath encodes a certificate path the way NetworkManager expects it
+func certPath(path string) []byte {
+	return append([]byte("file://"+path), 0)
+}
+
+// settings returns the 802-1x settings section for the credentials
+func (e *EnterpriseCredentials) settings() (map[string]dbus.Variant, error) {
+	eap := strings.ToLower(e.Eap)
+	if e.Identity == "" {
+		return nil, fmt.Errorf("an identity is required for %s", eap)
+	}
+	s := make(map[string]dbus.Variant)
+	s["eap"] = dbus.MakeVariant([]string{eap})
+	s["identity"] = dbus.MakeVariant(e.Identity)
+	switch eap {
+	case EapPeap, EapTtls:
+		if e.Password == "" {
+			return nil, fmt.Errorf("a password is required for %s", eap)
+		}
+		s["password"] = dbus.MakeVariant(e.Password)
+		phase2 := strings.ToLower(e.Phase2Auth)
+		if phase2 == "" {
+			phase2 = "mschapv2"
+		}
+		s["phase2-auth"] = dbus.MakeVariant(phase2)
+		if e.AnonymousIdentity != "" {
+			s["anonymous-identity"] = dbus.MakeVariant(e.AnonymousIdentity)
+		}
+	case EapTLS:
+		if e.ClientCert == "" || e.PrivateKey == "" {
+			return nil, fmt.Errorf("a client certificate and private key are required for %s", eap)
+		}
+		s["client-cert"] = dbus.MakeVariant(certPath(e.ClientCert))
+		s["private-key"] = dbus.MakeVariant(certPath(e.PrivateKey))
+		if e.PrivateKeyPassword != "" {
+			s["private-key-password"] = dbus.MakeVariant(e.PrivateKeyPassword)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported EAP method: %q", e.Eap)
+	}
+	if e.CaCert != "" {
+		s["ca-cert"] = dbus.MakeVariant(certPath(e.CaCert))
+	}
+	return s, nil
+}
+
+// ConnectApEnterprise attempts to connect to an external WPA/WPA2-Enterprise AP.
+// An SSID that has not been scanned is joined as a hidden network. cfg
+// optionally sets the IP configuration
+func (c *Client) ConnectApEnterprise(ssid string, creds *EnterpriseCredentials, ap2device map[string]string, ssid2ap map[string]string, cfg *NetworkConfig) error {
+	eap, err := creds.settings()
+	if err != nil {
+		return err
+	}
+
+	inner1 := make(map[string]dbus.Variant)
+	inner1["security"] = dbus.MakeVariant("802-11-wireless-security")
+
+	inner2 := make(map[string]dbus.Variant)
+	inner2["key-mgmt"] = dbus.MakeVariant("wpa-eap")
+
+	outer := make(map[string]map[string]dbus.Variant)
+	outer["802-11-wireless"] = inner1
+	outer["802-11-wireless-security"] = inner2
+	outer["802-1x"] = eap
+	err = cfg.apply(outer)
+	if err != nil {
+		return err
+	}
+
+	ap, ok := ssid2ap[ssid]
+	if !ok {
+		device, err := c.defaultWifiDevice()
+		if err != nil {
+			return err
+		}
+		setHidden(outer, ssid)
+		return c.activate(ssid, outer, device, anyAp)
+	}
+	return c.activate(ssid, outer, ap2device[ap], ap)
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"testing"
+)
+
+func TestEnterpriseSettings(t *testing.T) {
+	creds := &EnterpriseCredentials{Eap: "PEAP", Identity: "user", Password: "secret", CaCert: "/etc/ca.pem"}
+	s, err := creds.settings()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if eap := s["eap"].Value().([]string); len(eap) != 1 || eap[0] != EapPeap {
+		t.Errorf("Expected peap eap method, got: %v", eap)
+	}
+	if s["phase2-auth"].Value().(string) != "mschapv2" {
+		t.Errorf("Expected default mschapv2 phase2, got: %v", s["phase2-auth"])
+	}
+	if string(s["ca-cert"].Value().([]byte)) != "file:///etc/ca.pem\x00" {
+		t.Errorf("CA cert not encoded properly: %q", s["ca-cert"].Value())
+	}
+	if _, ok := s["anonymous-identity"]; ok {
+		t.Errorf("Anonymous identity should not be set")
+	}
+
+	creds = &EnterpriseCredentials{Eap: EapTLS, Identity: "user", ClientCert: "/c.pem", PrivateKey: "/k.pem"}
+	s, err = creds.settings()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := s["password"]; ok {
+		t.Errorf("TLS should not set a password")
+	}
+	if string(s["private-key"].Value().([]byte)) != "file:///k.pem\x00" {
+		t.Errorf("Private key not encoded properly: %q", s["private-key"].Value())
+	}
+
+	invalid := []*EnterpriseCredentials{
+		{Eap: EapPeap, Password: "secret"},
+		{Eap: EapTtls, Identity: "user"},
+		{Eap: EapTLS, Identity: "user", ClientCert: "/c.pem"},
+		{Eap: "leap", Identity: "user", Password: "secret"},
+	}
+	for _, creds := range invalid {
+		if _, err := creds.settings(); err == nil {
+			t.Errorf("Expected error for credentials: %v", creds)
+		}
+	}
+}
+
+func TestConnectApEnterprise(t *testing.T) {
+	mock := &mockObj{connect: true}
+	client := NewClient(mock)
+	ap2device := map[string]string{"/ap/1": "/d/1"}
+	ssid2ap := map[string]string{"corp": "/ap/1"}
+	creds := &EnterpriseCredentials{Eap: EapTtls, Identity: "user", Password: "secret", Phase2Auth: "pap"}
+	err := client.ConnectApEnterprise("corp", creds, ap2device, ssid2ap, nil)
+	if err != nil {
+		t.Errorf("Unexpected error connecting: %v", err)
+	}
+	if mock.settings["802-11-wireless-security"]["key-mgmt"].Value().(string) != "wpa-eap" {
+		t.Errorf("Expected wpa-eap key management, got: %v", mock.settings["802-11-wireless-security"])
+	}
+	if mock.settings["802-1x"]["phase2-auth"].Value().(string) != "pap" {
+		t.Errorf("Expected pap phase2, got: %v", mock.settings["802-1x"])
+	}
+	if err := client.ConnectApEnterprise("corp", &EnterpriseCredentials{}, ap2device, ssid2ap, nil); err == nil {
+		t.Errorf("Expected error for empty credentials")
+	}
+}
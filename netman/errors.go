@@ -0,0 +1,215 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// Reasons a connection attempt fails
+var (
+	ErrBadSecret     = errors.New("wifi-connect: the passphrase or credentials were rejected")
+	ErrNoSecrets     = errors.New("wifi-connect: the network requires secrets that were not provided")
+	ErrSsidNotFound  = errors.New("wifi-connect: the network was not found")
+	ErrDhcpFailed    = errors.New("wifi-connect: cannot get an IP address from the network")
+	ErrTimeout       = errors.New("wifi-connect: timed out connecting to AP")
+	ErrConnectFailed = errors.New("wifi-connect: cannot connect to AP")
+)
+
+// Reasons an operation on NetworkManager fails, wrapped in an Error
+var (
+	ErrNoBus        = errors.New("wifi-connect: the system bus is not available")
+	ErrNoDevice     = errors.New("wifi-connect: no such network device")
+	ErrNoApMode     = errors.New("wifi-connect: the wifi device does not support AP mode")
+	ErrNotConnected = errors.New("wifi-connect: the device is not connected")
+	// ErrNoSecretRequest is returned when answering an unknown secret request
+	ErrNoSecretRequest = errors.New("wifi-connect: no such secret request")
+)
+
+// Error is returned when an operation on NetworkManager fails. Use
+// errors.Is to check for one of the Err* reasons
+type Error struct {
+	// Op is the operation that failed, eg. "GetDevices"
+	Op string
+	// Path is the object the operation was done on, if any
+	Path string
+	// Err is the reason of the failure, eg. ErrNoBus or a D-Bus error
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("wifi-connect: %s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("wifi-connect: %s %s: %v", e.Op, e.Path, e.Err)
+}
+
+// Unwrap returns the reason of the failure
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// opError returns err as the Error of op on the object at path, nil if err
+// is nil
+func opError(op string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Op: op, Path: path, Err: err}
+}
+
+// Device state reasons (NM_DEVICE_STATE_REASON_*)
+const (
+	deviceReasonIPConfigUnavailable  uint32 = 5
+	deviceReasonNoSecrets            uint32 = 7
+	deviceReasonSupplicantDisconnect uint32 = 8
+	deviceReasonSupplicantFailed     uint32 = 10
+	deviceReasonSupplicantTimeout    uint32 = 11
+	deviceReasonDhcpStartFailed      uint32 = 15
+	deviceReasonDhcpError            uint32 = 16
+	deviceReasonDhcpFailed           uint32 = 17
+	deviceReasonSsidNotFound         uint32 = 53
+)
+
+// Active connection states and state reasons (NM_ACTIVE_CONNECTION_STATE_*)
+const (
+	activeStateDeactivated         uint32 = 4
+	activeReasonDeviceDisconnected uint32 = 3
+	activeReasonIPConfigInvalid    uint32 = 5
+	activeReasonConnectTimeout     uint32 = 6
+	activeReasonNoSecrets          uint32 = 9
+	activeReasonLoginFailed        uint32 = 10
+)
+
+// deviceReasonError returns the error for a device failed with reason
+func deviceReasonError(reason uint32) error {
+	switch reason {
+	case deviceReasonNoSecrets:
+		return ErrNoSecrets
+	case deviceReasonSupplicantDisconnect, deviceReasonSupplicantFailed:
+		// the supplicant gives up when the handshake fails, which in
+		// practice means a wrong passphrase
+		return ErrBadSecret
+	case deviceReasonSupplicantTimeout:
+		return ErrTimeout
+	case deviceReasonIPConfigUnavailable, deviceReasonDhcpStartFailed, deviceReasonDhcpError, deviceReasonDhcpFailed:
+		return ErrDhcpFailed
+	case deviceReasonSsidNotFound:
+		return ErrSsidNotFound
+	}
+	return ErrConnectFailed
+}
+
+// activeReasonError returns the error for an active connection deactivated
+// with reason
+func activeReasonError(reason uint32) error {
+	switch reason {
+	case activeReasonNoSecrets:
+		return ErrNoSecrets
+	case activeReasonLoginFailed:
+		return ErrBadSecret
+	case activeReasonConnectTimeout:
+		return ErrTimeout
+	case activeReasonIPConfigInvalid:
+		return ErrDhcpFailed
+	}
+	return ErrConnectFailed
+}
+
+// deviceStateReason returns the StateReason property of passed device
+func (c *Client) deviceStateReason(device string) (uint32, error) {
+	objPath := dbus.ObjectPath(device)
+	obj := c.object(objPath)
+	v, err := obj.GetProperty("org.freedesktop.NetworkManager.Device.StateReason")
+	if err != nil {
+		return 0, opError("get StateReason", device, err)
+	}
+	// the property is a (state, reason) struct
+	s, ok := v.Value().([]interface{})
+	if !ok || len(s) < 2 {
+		return 0, opError("get StateReason", device, fmt.Errorf("unexpected type %T", v.Value()))
+	}
+	reason, ok := s[1].(uint32)
+	if !ok {
+		return 0, opError("get StateReason", device, fmt.Errorf("unexpected type %T", s[1]))
+	}
+	return reason, nil
+}
+
+// waitActivation waits until device is activated. If the device fails or the
+// active connection is deactivated before, the NetworkManager state reason is
+// returned as one of the Err* values. ErrTimeout is returned if timeout
+// expires. Without a subscription the device state is polled instead
+func (c *Client) waitActivation(sub *Subscription, device string, active string, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	if sub == nil {
+		tick := time.NewTicker(pollInterval)
+		defer tick.Stop()
+		// the device may still be failed from a previous attempt
+		started := false
+		for {
+			select {
+			case <-tick.C:
+				s, err := c.deviceState(device)
+				if err != nil {
+					continue
+				}
+				switch {
+				case s == DeviceStateActivated:
+					return nil
+				case s == DeviceStateFailed && started:
+					reason, err := c.deviceStateReason(device)
+					if err != nil {
+						return ErrConnectFailed
+					}
+					return deviceReasonError(reason)
+				case s != DeviceStateFailed:
+					started = true
+				}
+			case <-deadline:
+				return ErrTimeout
+			}
+		}
+	}
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return ErrConnectFailed
+			}
+			switch {
+			case e.Type == DeviceStateChanged && e.Path == device && e.State == DeviceStateActivated:
+				return nil
+			case e.Type == DeviceStateChanged && e.Path == device && e.State == DeviceStateFailed:
+				return deviceReasonError(e.Reason)
+			case e.Type == ActiveConnectionStateChanged && e.Path == active && e.State == activeStateDeactivated:
+				// the device reason is more accurate, wait for it
+				if e.Reason == activeReasonDeviceDisconnected {
+					continue
+				}
+				return activeReasonError(e.Reason)
+			}
+		case <-deadline:
+			return ErrTimeout
+		}
+	}
+}
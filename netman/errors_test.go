@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// mockFailingDevice is a device going through states and failing with reason
+type mockFailingDevice struct {
+	*mockObj
+	states []uint32
+	reason uint32
+}
+
+func (m *mockFailingDevice) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	return m
+}
+
+func (m *mockFailingDevice) GetProperty(p string) (dbus.Variant, error) {
+	switch p {
+	case "org.freedesktop.NetworkManager.Device.State":
+		s := m.states[0]
+		if len(m.states) > 1 {
+			m.states = m.states[1:]
+		}
+		return dbus.MakeVariant(s), nil
+	case "org.freedesktop.NetworkManager.Device.StateReason":
+		return dbus.MakeVariant([]interface{}{DeviceStateFailed, m.reason}), nil
+	}
+	return m.mockObj.GetProperty(p)
+}
+
+func TestDeviceReasonError(t *testing.T) {
+	cases := map[uint32]error{
+		deviceReasonNoSecrets:            ErrNoSecrets,
+		deviceReasonSupplicantDisconnect: ErrBadSecret,
+		deviceReasonSupplicantTimeout:    ErrTimeout,
+		deviceReasonDhcpFailed:           ErrDhcpFailed,
+		deviceReasonSsidNotFound:         ErrSsidNotFound,
+		1:                                ErrConnectFailed,
+	}
+	for reason, expected := range cases {
+		if err := deviceReasonError(reason); err != expected {
+			t.Errorf("Reason %d: expected %v, got %v", reason, expected, err)
+		}
+	}
+}
+
+func TestWaitActivation(t *testing.T) {
+	client, signals := newSignalClient(&mockObj{})
+	sub, _ := client.Subscribe()
+	defer sub.Close()
+	go func() {
+		signals.emit(deviceStateSignal("/d/1", DeviceStateConfig, DeviceStatePrepare, 0))
+		signals.emit(deviceStateSignal("/d/1", DeviceStateFailed, DeviceStateNeedAuth, deviceReasonSupplicantDisconnect))
+	}()
+	if err := client.waitActivation(sub, "/d/1", "/a/1", time.Second); err != ErrBadSecret {
+		t.Errorf("Expected ErrBadSecret, got %v", err)
+	}
+
+	// the active connection reason is used unless the device one is better
+	go func() {
+		signals.emit(&dbus.Signal{
+			Path: "/a/1",
+			Name: "org.freedesktop.NetworkManager.Connection.Active.StateChanged",
+			Body: []interface{}{activeStateDeactivated, activeReasonDeviceDisconnected},
+		})
+		signals.emit(&dbus.Signal{
+			Path: "/a/1",
+			Name: "org.freedesktop.NetworkManager.Connection.Active.StateChanged",
+			Body: []interface{}{activeStateDeactivated, activeReasonNoSecrets},
+		})
+	}()
+	if err := client.waitActivation(sub, "/d/1", "/a/1", time.Second); err != ErrNoSecrets {
+		t.Errorf("Expected ErrNoSecrets, got %v", err)
+	}
+
+	if err := client.waitActivation(sub, "/d/1", "/a/1", 50*time.Millisecond); err != ErrTimeout {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestWaitActivationPolling(t *testing.T) {
+	// a failed state left from a previous attempt is skipped
+	mock := &mockFailingDevice{
+		mockObj: &mockObj{},
+		states:  []uint32{DeviceStateFailed, DeviceStatePrepare, DeviceStateIPConfig, DeviceStateFailed},
+		reason:  deviceReasonDhcpFailed,
+	}
+	client := NewClient(mock)
+	if err := client.waitActivation(nil, "/d/1", "/a/1", time.Second); err != ErrDhcpFailed {
+		t.Errorf("Expected ErrDhcpFailed, got %v", err)
+	}
+}
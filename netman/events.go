@@ -0,0 +1,352 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// NetworkManager device states (NM_DEVICE_STATE_*)
+const (
+	DeviceStateUnknown      uint32 = 0
+	DeviceStateUnmanaged    uint32 = 10
+	DeviceStateUnavailable  uint32 = 20
+	DeviceStateDisconnected uint32 = 30
+	DeviceStatePrepare      uint32 = 40
+	DeviceStateConfig       uint32 = 50
+	DeviceStateNeedAuth     uint32 = 60
+	DeviceStateIPConfig     uint32 = 70
+	DeviceStateIPCheck      uint32 = 80
+	DeviceStateSecondaries  uint32 = 90
+	DeviceStateActivated    uint32 = 100
+	DeviceStateDeactivating uint32 = 110
+	DeviceStateFailed       uint32 = 120
+)
+
+// NetworkManager device types (NM_DEVICE_TYPE_*)
+const (
+	DeviceTypeEthernet uint32 = 1
+	DeviceTypeWifi     uint32 = 2
+)
+
+// EventType identifies the kind of NetworkManager signal an Event comes from
+type EventType int
+
+// Enum of events delivered by a Subscription
+const (
+	ManagerStateChanged EventType = 0 + iota
+	DeviceStateChanged
+	ActiveConnectionStateChanged
+	PropertiesChanged
+	DeviceAdded
+	DeviceRemoved
+	// ManagerStarted and ManagerStopped are sent when the NetworkManager
+	// service (re)starts or goes away. Device paths change across restarts
+	ManagerStarted
+	ManagerStopped
+)
+
+// Event is a typed NetworkManager D-Bus signal
+type Event struct {
+	Type EventType
+	// Path of the object emitting the signal, or the added/removed device
+	Path string
+	// Interface owning the changed properties, only for PropertiesChanged
+	Interface string
+	State     uint32
+	OldState  uint32
+	Reason    uint32
+	// Properties holds the changed values, only for PropertiesChanged
+	Properties map[string]dbus.Variant
+}
+
+// Signaler allows mocking the godbus signal subscription functions
+type Signaler interface {
+	AddMatch(rule string) error
+	RemoveMatch(rule string) error
+	Signal(ch chan<- *dbus.Signal)
+	RemoveSignal(ch chan<- *dbus.Signal)
+}
+
+// busSignaler is the runtime Signaler on top of a bus connection
+type busSignaler struct {
+	conn *dbus.Conn
+}
+
+func (b *busSignaler) AddMatch(rule string) error {
+	return b.conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule).Err
+}
+
+func (b *busSignaler) RemoveMatch(rule string) error {
+	return b.conn.BusObject().Call("org.freedesktop.DBus.RemoveMatch", 0, rule).Err
+}
+
+func (b *busSignaler) Signal(ch chan<- *dbus.Signal) {
+	b.conn.Signal(ch)
+}
+
+func (b *busSignaler) RemoveSignal(ch chan<- *dbus.Signal) {
+	b.conn.RemoveSignal(ch)
+}
+
+const (
+	matchRule = "type='signal',sender='org.freedesktop.NetworkManager'"
+	ownerRule = "type='signal',sender='org.freedesktop.DBus',member='NameOwnerChanged',arg0='org.freedesktop.NetworkManager'"
+)
+
+// pollInterval is the device state polling period used when signals
+// are not available
+var pollInterval = 1000 * time.Millisecond
+
+// Subscription delivers NetworkManager events until it is closed
+type Subscription struct {
+	signals Signaler
+	raw     chan *dbus.Signal
+	events  chan Event
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Subscribe registers for NetworkManager StateChanged and PropertiesChanged
+// signals and returns a Subscription delivering them as typed events
+func (c *Client) Subscribe() (*Subscription, error) {
+	signals := c.dbusClient.signals
+	if signals == nil && c.dbusClient.bus != nil {
+		conn, err := c.dbusClient.bus.Conn()
+		if err != nil {
+			return nil, opError("subscribe", "", err)
+		}
+		signals = &busSignaler{conn: conn}
+	}
+	if signals == nil {
+		return nil, errors.New("no D-Bus signal source available")
+	}
+	for _, rule := range []string{matchRule, ownerRule} {
+		err := signals.AddMatch(rule)
+		if err != nil {
+			return nil, opError("subscribe", "", fmt.Errorf("cannot add match rule: %v", err))
+		}
+	}
+	s := &Subscription{
+		signals: signals,
+		raw:     make(chan *dbus.Signal, 16),
+		events:  make(chan Event, 16),
+		done:    make(chan struct{}),
+	}
+	s.signals.Signal(s.raw)
+	go s.pump()
+	return s, nil
+}
+
+// Events returns the channel events are delivered to. It is closed
+// when the subscription is closed or the bus connection is lost
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops delivering events and releases the signal subscription
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *Subscription) pump() {
+	defer close(s.events)
+	for {
+		select {
+		case sig, ok := <-s.raw:
+			if !ok {
+				// the bus connection is closed
+				return
+			}
+			e, ok := parseSignal(sig)
+			if !ok {
+				continue
+			}
+			select {
+			case s.events <- e:
+			case <-s.done:
+				s.release()
+				return
+			}
+		case <-s.done:
+			s.release()
+			return
+		}
+	}
+}
+
+// release unregisters the signal channel. godbus may be blocked delivering
+// to it, so keep draining until it is removed
+func (s *Subscription) release() {
+	removed := make(chan struct{})
+	go func() {
+		s.signals.RemoveSignal(s.raw)
+		s.signals.RemoveMatch(matchRule)
+		s.signals.RemoveMatch(ownerRule)
+		close(removed)
+	}()
+	for {
+		select {
+		case _, ok := <-s.raw:
+			if !ok {
+				// the bus connection is closed, nothing is delivered anymore
+				<-removed
+				return
+			}
+		case <-removed:
+			return
+		}
+	}
+}
+
+// parseSignal converts a raw D-Bus signal into an Event. Returns false
+// for signals that are not of interest
+func parseSignal(sig *dbus.Signal) (Event, bool) {
+	if sig == nil {
+		return Event{}, false
+	}
+	e := Event{Path: string(sig.Path)}
+	switch sig.Name {
+	case "org.freedesktop.NetworkManager.StateChanged":
+		if len(sig.Body) < 1 {
+			return e, false
+		}
+		e.Type = ManagerStateChanged
+		e.State, _ = sig.Body[0].(uint32)
+	case "org.freedesktop.NetworkManager.Device.StateChanged":
+		if len(sig.Body) < 3 {
+			return e, false
+		}
+		e.Type = DeviceStateChanged
+		e.State, _ = sig.Body[0].(uint32)
+		e.OldState, _ = sig.Body[1].(uint32)
+		e.Reason, _ = sig.Body[2].(uint32)
+	case "org.freedesktop.NetworkManager.Connection.Active.StateChanged":
+		if len(sig.Body) < 2 {
+			return e, false
+		}
+		e.Type = ActiveConnectionStateChanged
+		e.State, _ = sig.Body[0].(uint32)
+		e.Reason, _ = sig.Body[1].(uint32)
+	case "org.freedesktop.NetworkManager.DeviceAdded":
+		if len(sig.Body) < 1 {
+			return e, false
+		}
+		e.Type = DeviceAdded
+		path, _ := sig.Body[0].(dbus.ObjectPath)
+		e.Path = string(path)
+	case "org.freedesktop.NetworkManager.DeviceRemoved":
+		if len(sig.Body) < 1 {
+			return e, false
+		}
+		e.Type = DeviceRemoved
+		path, _ := sig.Body[0].(dbus.ObjectPath)
+		e.Path = string(path)
+	case "org.freedesktop.DBus.NameOwnerChanged":
+		if len(sig.Body) < 3 {
+			return e, false
+		}
+		if name, _ := sig.Body[0].(string); name != "org.freedesktop.NetworkManager" {
+			return e, false
+		}
+		e.Type = ManagerStopped
+		if owner, _ := sig.Body[2].(string); owner != "" {
+			e.Type = ManagerStarted
+		}
+	case "org.freedesktop.DBus.Properties.PropertiesChanged":
+		if len(sig.Body) < 2 {
+			return e, false
+		}
+		e.Type = PropertiesChanged
+		e.Interface, _ = sig.Body[0].(string)
+		e.Properties, _ = sig.Body[1].(map[string]dbus.Variant)
+	default:
+		return e, false
+	}
+	return e, true
+}
+
+// deviceState returns the current state of the passed device
+func (c *Client) deviceState(device string) (uint32, error) {
+	objPath := dbus.ObjectPath(device)
+	obj := c.object(objPath)
+	state, err := obj.GetProperty("org.freedesktop.NetworkManager.Device.State")
+	if err != nil {
+		return DeviceStateUnknown, opError("get State", device, err)
+	}
+	s, ok := state.Value().(uint32)
+	if !ok {
+		return DeviceStateUnknown, opError("get State", device, fmt.Errorf("unexpected type %T", state.Value()))
+	}
+	return s, nil
+}
+
+func stateIn(state uint32, states []uint32) bool {
+	for _, s := range states {
+		if state == s {
+			return true
+		}
+	}
+	return false
+}
+
+// waitDeviceState waits until device reaches one of the passed states or timeout
+// expires. When check is true the current state is read first. State changes
+// come from sub; without a subscription the device state is polled instead
+func (c *Client) waitDeviceState(sub *Subscription, device string, timeout time.Duration, check bool, states ...uint32) bool {
+	if check {
+		if s, err := c.deviceState(device); err == nil && stateIn(s, states) {
+			return true
+		}
+	}
+	deadline := time.After(timeout)
+	if sub == nil {
+		tick := time.NewTicker(pollInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				if s, err := c.deviceState(device); err == nil && stateIn(s, states) {
+					return true
+				}
+			case <-deadline:
+				return false
+			}
+		}
+	}
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return false
+			}
+			if e.Type == DeviceStateChanged && e.Path == device && stateIn(e.State, states) {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
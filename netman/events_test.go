@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+func init() {
+	pollInterval = 10 * time.Millisecond
+}
+
+// mockSignals is a Signaler delivering signals injected by the tests
+type mockSignals struct {
+	mu    sync.Mutex
+	rules []string
+	chans []chan<- *dbus.Signal
+}
+
+func (m *mockSignals) AddMatch(rule string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules = append(m.rules, rule)
+	return nil
+}
+
+func (m *mockSignals) RemoveMatch(rule string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, r := range m.rules {
+		if r == rule {
+			m.rules = append(m.rules[:i], m.rules[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockSignals) Signal(ch chan<- *dbus.Signal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chans = append(m.chans, ch)
+}
+
+func (m *mockSignals) RemoveSignal(ch chan<- *dbus.Signal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, c := range m.chans {
+		if c == ch {
+			m.chans = append(m.chans[:i], m.chans[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *mockSignals) emit(sig *dbus.Signal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.chans {
+		ch <- sig
+	}
+}
+
+func (m *mockSignals) subscribers() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.chans)
+}
+
+func deviceStateSignal(device string, state, old, reason uint32) *dbus.Signal {
+	return &dbus.Signal{
+		Sender: "org.freedesktop.NetworkManager",
+		Path:   dbus.ObjectPath(device),
+		Name:   "org.freedesktop.NetworkManager.Device.StateChanged",
+		Body:   []interface{}{state, old, reason},
+	}
+}
+
+func newSignalClient(mock dbus.BusObject) (*Client, *mockSignals) {
+	signals := &mockSignals{}
+	client := NewClient(mock)
+	client.dbusClient.signals = signals
+	return client, signals
+}
+
+func TestParseSignal(t *testing.T) {
+	e, ok := parseSignal(deviceStateSignal("/d/1", DeviceStateActivated, DeviceStateIPConfig, 0))
+	if !ok || e.Type != DeviceStateChanged || e.Path != "/d/1" || e.State != DeviceStateActivated || e.OldState != DeviceStateIPConfig {
+		t.Errorf("Device state change not parsed properly: %v", e)
+	}
+	e, ok = parseSignal(&dbus.Signal{
+		Path: "/org/freedesktop/NetworkManager/AccessPoint/1",
+		Name: "org.freedesktop.DBus.Properties.PropertiesChanged",
+		Body: []interface{}{"org.freedesktop.NetworkManager.AccessPoint", map[string]dbus.Variant{"Strength": dbus.MakeVariant(uint8(70))}, []string{}},
+	})
+	if !ok || e.Type != PropertiesChanged || e.Interface != "org.freedesktop.NetworkManager.AccessPoint" {
+		t.Errorf("Properties change not parsed properly: %v", e)
+	}
+	if _, ok := e.Properties["Strength"]; !ok {
+		t.Errorf("Changed property not found: %v", e.Properties)
+	}
+	e, ok = parseSignal(&dbus.Signal{
+		Path: "/org/freedesktop/NetworkManager",
+		Name: "org.freedesktop.NetworkManager.DeviceAdded",
+		Body: []interface{}{dbus.ObjectPath("/d/4")},
+	})
+	if !ok || e.Type != DeviceAdded || e.Path != "/d/4" {
+		t.Errorf("Device added not parsed properly: %v", e)
+	}
+	if _, ok := parseSignal(&dbus.Signal{Name: "org.freedesktop.NetworkManager.Device.StateChanged"}); ok {
+		t.Errorf("Signal without body should have been ignored")
+	}
+	if _, ok := parseSignal(&dbus.Signal{Name: "org.freedesktop.Other.Signal"}); ok {
+		t.Errorf("Unknown signal should have been ignored")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	if _, err := NewClient(&mockObj{}).Subscribe(); err == nil {
+		t.Errorf("Subscribe should fail without a signal source")
+	}
+	client, signals := newSignalClient(&mockObj{})
+	sub, err := client.Subscribe()
+	if err != nil {
+		t.Fatalf("Unexpected error subscribing: %v", err)
+	}
+	if len(signals.rules) != 2 || signals.subscribers() != 1 {
+		t.Errorf("Subscribe should add one match rule and one channel")
+	}
+	go signals.emit(deviceStateSignal("/d/1", DeviceStateDisconnected, DeviceStateActivated, 0))
+	select {
+	case e := <-sub.Events():
+		if e.Type != DeviceStateChanged || e.State != DeviceStateDisconnected {
+			t.Errorf("Unexpected event: %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("No event received")
+	}
+	sub.Close()
+	if _, ok := <-sub.Events(); ok {
+		t.Errorf("Events channel should be closed after Close")
+	}
+	if len(signals.rules) != 0 || signals.subscribers() != 0 {
+		t.Errorf("Close should remove match rule and channel")
+	}
+}
+
+func TestWaitDeviceState(t *testing.T) {
+	client, signals := newSignalClient(&mockObj{})
+	sub, _ := client.Subscribe()
+	defer sub.Close()
+	go func() {
+		signals.emit(deviceStateSignal("/d/2", DeviceStateActivated, DeviceStateIPConfig, 0))
+		signals.emit(deviceStateSignal("/d/1", DeviceStateConfig, DeviceStatePrepare, 0))
+		signals.emit(deviceStateSignal("/d/1", DeviceStateActivated, DeviceStateIPConfig, 0))
+	}()
+	if !client.waitDeviceState(sub, "/d/1", time.Second, false, DeviceStateActivated) {
+		t.Errorf("Device should have reached activated state")
+	}
+	if client.waitDeviceState(sub, "/d/1", 50*time.Millisecond, false, DeviceStateActivated) {
+		t.Errorf("Device should not reach activated state again")
+	}
+	// current state is read when requested
+	mock := &mockObj{connect: true}
+	client, _ = newSignalClient(mock)
+	if !client.waitDeviceState(nil, "/d/1", 50*time.Millisecond, true, DeviceStateActivated) {
+		t.Errorf("Device current state should have been used")
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	client, signals := newSignalClient(&mockObj{})
+	m, err := client.NewMonitor()
+	if err != nil {
+		t.Fatalf("Unexpected error creating monitor: %v", err)
+	}
+	defer m.Close()
+	if m.Connected() || m.ConnectedWifi() {
+		t.Errorf("No device should be connected yet")
+	}
+	signals.emit(deviceStateSignal("/d/1", DeviceStateActivated, DeviceStateIPConfig, 0))
+	select {
+	case <-m.Changes():
+	case <-time.After(time.Second):
+		t.Fatalf("No change notified")
+	}
+	if !m.ConnectedWifi() {
+		t.Errorf("Wifi device should be connected")
+	}
+	signals.emit(deviceStateSignal("/d/1", DeviceStateDisconnected, DeviceStateActivated, 0))
+	<-m.Changes()
+	if m.ConnectedWifi() {
+		t.Errorf("Wifi device should be disconnected")
+	}
+	signals.emit(deviceStateSignal("/d/3", DeviceStateActivated, DeviceStateIPConfig, 0))
+	<-m.Changes()
+	if !m.Connected() || m.ConnectedWifi() || !m.ConnectedEthernet() {
+		t.Errorf("Only ethernet device should be connected")
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// Exclusion describes access points to leave out of scan results, like the
+// device's own AP or the setup APs of sibling devices. Patterns are SSID
+// shell patterns, eg. "Ubuntu-*"
+type Exclusion struct {
+	Ssids    []string
+	Bssids   []string
+	Patterns []string
+}
+
+// Excludes returns true if passed access point is excluded
+func (e *Exclusion) Excludes(s SSID) bool {
+	if e == nil {
+		return false
+	}
+	for _, ssid := range e.Ssids {
+		if sameSsid(s.Ssid, ssid) {
+			return true
+		}
+	}
+	for _, bssid := range e.Bssids {
+		if bssid != "" && strings.EqualFold(s.Bssid, bssid) {
+			return true
+		}
+	}
+	for _, p := range e.Patterns {
+		if matched, err := path.Match(p, strings.TrimSpace(s.Ssid)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// SetExclusion sets the access points to leave out of scan results, nil
+// to return them all
+func (c *Client) SetExclusion(e *Exclusion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exclusion = e
+}
+
+// getExclusion returns the access points to leave out of scan results
+func (c *Client) getExclusion() *Exclusion {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exclusion
+}
+
+// HwAddress returns the hardware address of passed interface
+func (c *Client) HwAddress(iface string) (string, error) {
+	device, err := c.DeviceByInterface(iface)
+	if err != nil {
+		return "", err
+	}
+	return c.deviceHwAddress(device)
+}
+
+// deviceHwAddress returns the hardware address of passed device.
+// Device.HwAddress is only there since NetworkManager 1.24, older versions
+// have it on the interface of the device type
+func (c *Client) deviceHwAddress(device string) (string, error) {
+	obj := c.object(dbus.ObjectPath(device))
+	var err error
+	for _, iface := range []string{"Device", "Device.Wireless", "Device.Wired"} {
+		var v dbus.Variant
+		v, err = obj.GetProperty("org.freedesktop.NetworkManager." + iface + ".HwAddress")
+		if err != nil {
+			continue
+		}
+		if address, ok := v.Value().(string); ok && address != "" {
+			return address, nil
+		}
+		err = errors.New("no hardware address")
+	}
+	return "", opError("get HwAddress", device, err)
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestExcludes(t *testing.T) {
+	var none *Exclusion
+	if none.Excludes(SSID{Ssid: "home"}) {
+		t.Errorf("Nil exclusion should not exclude anything")
+	}
+	e := &Exclusion{Ssids: []string{"Ubuntu"}, Bssids: []string{"00:11:22:33:44:55"}, Patterns: []string{"setup-*"}}
+	for _, s := range []SSID{{Ssid: "Ubuntu "}, {Ssid: "home", Bssid: "00:11:22:33:44:55"}, {Ssid: "setup-1234"}} {
+		if !e.Excludes(s) {
+			t.Errorf("Access point %v should have been excluded", s)
+		}
+	}
+	if e.Excludes(SSID{Ssid: "home", Bssid: "00:11:22:33:44:66"}) {
+		t.Errorf("Access point should not have been excluded")
+	}
+	e = &Exclusion{Bssids: []string{""}}
+	if e.Excludes(SSID{Ssid: "home"}) {
+		t.Errorf("An empty BSSID should not exclude access points without BSSID")
+	}
+}
+
+func TestSsidsExcluded(t *testing.T) {
+	mock := &mockScan{aps: map[dbus.ObjectPath]map[string]dbus.Variant{
+		"/ap/1": scannedAp("home", 40, 0),
+		"/ap/2": scannedAp("Ubuntu", 80, 0),
+		"/ap/3": scannedAp("setup-1", 60, 0),
+	}}
+	client := NewClient(mock)
+	client.SetExclusion(&Exclusion{Ssids: []string{"Ubuntu"}, Patterns: []string{"setup-*"}})
+	ssid2ap := make(map[string]string)
+	ssids, _ := client.getSsids([]string{"/ap/1", "/ap/2", "/ap/3"}, ssid2ap)
+	if len(ssids) != 1 || ssids[0].Ssid != "home" {
+		t.Errorf("Only home network should have been found, but found: %v", ssids)
+	}
+	if _, ok := ssid2ap["Ubuntu"]; ok {
+		t.Errorf("Excluded access point should not be used for activation")
+	}
+}
+
+// mockHwAddress reports the hardware address of the devices of mockRadios
+type mockHwAddress struct {
+	mockRadios
+	// legacy reports it on the Wireless interface only, as NetworkManager
+	// before 1.24 does
+	legacy bool
+	// missing reports none
+	missing bool
+}
+
+func (m *mockHwAddress) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	m.path = path
+	return m
+}
+
+func (m *mockHwAddress) GetProperty(p string) (dbus.Variant, error) {
+	if m.missing && strings.HasSuffix(p, ".HwAddress") {
+		return dbus.Variant{}, errors.New("no such property")
+	}
+	if (p == "org.freedesktop.NetworkManager.Device.HwAddress" && !m.legacy) ||
+		(p == "org.freedesktop.NetworkManager.Device.Wireless.HwAddress" && m.legacy) {
+		return dbus.MakeVariant("00:11:22:33:44:0" + string(m.path[len(m.path)-1])), nil
+	}
+	if p == "org.freedesktop.NetworkManager.Device.HwAddress" {
+		return dbus.Variant{}, errors.New("no such property")
+	}
+	return m.mockRadios.GetProperty(p)
+}
+
+func TestHwAddress(t *testing.T) {
+	client := NewClient(&mockHwAddress{})
+	address, err := client.HwAddress("mlan0")
+	if err != nil || address != "00:11:22:33:44:02" {
+		t.Errorf("Unexpected hardware address %q: %v", address, err)
+	}
+	if _, err = client.HwAddress("eth7"); err == nil {
+		t.Errorf("Unknown interface should fail")
+	}
+
+	client = NewClient(&mockHwAddress{legacy: true})
+	address, err = client.HwAddress("mlan0")
+	if err != nil || address != "00:11:22:33:44:02" {
+		t.Errorf("Unexpected hardware address with an older NetworkManager %q: %v", address, err)
+	}
+
+	client = NewClient(&mockHwAddress{missing: true})
+	if address, err = client.HwAddress("mlan0"); err == nil {
+		t.Errorf("Unknown hardware address should fail, got %q", address)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fakenm
+
+import (
+	"errors"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	agentManagerPath  = nmPath + "/AgentManager"
+	agentManagerIface = nmIface + ".AgentManager"
+	agentPath         = nmPath + "/SecretAgent"
+	agentIface        = nmIface + ".SecretAgent"
+)
+
+// GetSecrets flags (NM_SECRET_AGENT_GET_SECRETS_FLAG_*)
+const (
+	SecretsAllowInteraction uint32 = 0x1
+	SecretsRequestNew       uint32 = 0x2
+)
+
+// ErrNoAgent is returned when asking for secrets without registered agent
+var ErrNoAgent = errors.New("no secret agent registered")
+
+// exportAgentManager exports the AgentManager, which keeps the last agent
+// registered
+func (nm *NetworkManager) exportAgentManager() {
+	nm.conn.ExportMethodTable(map[string]interface{}{
+		"Register": func(sender dbus.Sender, identifier string) *dbus.Error {
+			nm.mu.Lock()
+			defer nm.mu.Unlock()
+			if err := nm.check("Register"); err != nil {
+				return err
+			}
+			nm.agent = string(sender)
+			return nil
+		},
+		"Unregister": func(sender dbus.Sender) *dbus.Error {
+			nm.mu.Lock()
+			defer nm.mu.Unlock()
+			if nm.agent == string(sender) {
+				nm.agent = ""
+			}
+			return nil
+		},
+	}, agentManagerPath, agentManagerIface)
+}
+
+// Agent returns the unique bus name of the registered secret agent, empty if
+// none
+func (nm *NetworkManager) Agent() string {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	return nm.agent
+}
+
+// agentObject returns the registered agent and the settings of connection
+func (nm *NetworkManager) agentObject(connection dbus.ObjectPath) (dbus.BusObject, map[string]map[string]dbus.Variant, error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if nm.agent == "" {
+		return nil, nil, ErrNoAgent
+	}
+	settings, ok := nm.connections[connection]
+	if !ok {
+		return nil, nil, errors.New("no such connection")
+	}
+	return nm.conn.Object(nm.agent, agentPath), settings, nil
+}
+
+// RequestSecrets asks the registered agent for the secrets of setting of
+// connection, as NetworkManager does when activating it
+func (nm *NetworkManager) RequestSecrets(connection dbus.ObjectPath, setting string, hints []string, flags uint32) (map[string]map[string]dbus.Variant, error) {
+	obj, settings, err := nm.agentObject(connection)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]map[string]dbus.Variant)
+	err = obj.Call(agentIface+".GetSecrets", 0, settings, connection, setting, hints, flags).Store(&secrets)
+	return secrets, err
+}
+
+// CancelSecrets cancels the pending secret request of setting of connection
+func (nm *NetworkManager) CancelSecrets(connection dbus.ObjectPath, setting string) error {
+	obj, _, err := nm.agentObject(connection)
+	if err != nil {
+		return err
+	}
+	return obj.Call(agentIface+".CancelGetSecrets", 0, connection, setting).Err
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fakenm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// ErrNoDaemon is returned by StartBus if dbus-daemon is not installed, tests
+// should be skipped then
+var ErrNoDaemon = errors.New("dbus-daemon not found")
+
+// busConfig lets every connection own any name and talk to any other
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-BUS Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// Bus is a private message bus, run by its own dbus-daemon
+type Bus struct {
+	cmd     *exec.Cmd
+	dir     string
+	address string
+}
+
+// StartBus starts a private message bus. Close it when no longer needed
+func StartBus() (*Bus, error) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		return nil, ErrNoDaemon
+	}
+	dir, err := ioutil.TempDir("", "fakenm")
+	if err != nil {
+		return nil, err
+	}
+	socket := filepath.Join(dir, "bus")
+	config := filepath.Join(dir, "bus.conf")
+	err = ioutil.WriteFile(config, []byte(fmt.Sprintf(busConfig, socket)), 0600)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--nopidfile")
+	err = cmd.Start()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	b := &Bus{cmd: cmd, dir: dir, address: "unix:path=" + socket}
+	// the daemon is ready once it accepts connections
+	for i := 0; i < 500; i++ {
+		if c, err := net.Dial("unix", socket); err == nil {
+			c.Close()
+			return b, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.Close()
+	return nil, errors.New("dbus-daemon did not start")
+}
+
+// Address returns the address of the bus
+func (b *Bus) Address() string {
+	return b.address
+}
+
+// Connect returns a new connection to the bus
+func (b *Bus) Connect() (*dbus.Conn, error) {
+	conn, err := dbus.Dial(b.address)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Auth(nil)
+	if err == nil {
+		err = conn.Hello()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Close stops the bus daemon
+func (b *Bus) Close() {
+	b.cmd.Process.Kill()
+	b.cmd.Wait()
+	os.RemoveAll(b.dir)
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fakenm
+
+import (
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// Checkpoint creation flags (NM_CHECKPOINT_CREATE_FLAG_*)
+const (
+	checkpointDestroyAll           uint32 = 0x1
+	checkpointDeleteNewConnections uint32 = 0x2
+)
+
+// Rollback results (NM_ROLLBACK_RESULT_*)
+const (
+	rollbackOk       uint32 = 0
+	rollbackNoDevice uint32 = 1
+)
+
+// savedDevice is the state of a device when a checkpoint was created
+type savedDevice struct {
+	unmanaged  bool
+	connection dbus.ObjectPath
+	ap         dbus.ObjectPath
+}
+
+// checkpoint is the configuration saved by CheckpointCreate
+type checkpoint struct {
+	flags       uint32
+	connections map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	devices     map[dbus.ObjectPath]savedDevice
+	timer       *time.Timer
+}
+
+// Checkpoints returns the number of checkpoints not rolled back or destroyed
+func (nm *NetworkManager) Checkpoints() int {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	return len(nm.checkpoints)
+}
+
+func noCheckpoint() *dbus.Error {
+	return dbus.NewError(nmIface+".InvalidArguments", []interface{}{"no such checkpoint"})
+}
+
+func (nm *NetworkManager) checkpointCreate(devices []dbus.ObjectPath, timeout uint32, flags uint32) (dbus.ObjectPath, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("CheckpointCreate"); err != nil {
+		return "/", err
+	}
+	if len(nm.checkpoints) > 0 {
+		if flags&checkpointDestroyAll == 0 {
+			return "/", dbus.NewError(nmIface+".InvalidArguments", []interface{}{"a checkpoint already exists"})
+		}
+		for path := range nm.checkpoints {
+			nm.destroyCheckpoint(path)
+		}
+	}
+	if len(devices) == 0 {
+		for _, d := range nm.devices {
+			devices = append(devices, d.path)
+		}
+	}
+	cp := &checkpoint{
+		flags:       flags,
+		connections: make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant),
+		devices:     make(map[dbus.ObjectPath]savedDevice),
+	}
+	for path, settings := range nm.connections {
+		cp.connections[path] = settings
+	}
+	for _, path := range devices {
+		d := nm.device(path)
+		if d == nil {
+			return "/", dbus.NewError(nmIface+".UnknownDevice", []interface{}{"no such device"})
+		}
+		saved := savedDevice{unmanaged: d.state == StateUnmanaged, connection: "/", ap: "/"}
+		if a, ok := nm.actives[d.active]; ok {
+			saved.connection, saved.ap = a.connection, a.ap
+		}
+		cp.devices[path] = saved
+	}
+	path := nm.newPath("Checkpoint")
+	nm.checkpoints[path] = cp
+	if timeout > 0 {
+		cp.timer = time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+			nm.mu.Lock()
+			defer nm.mu.Unlock()
+			if nm.checkpoints[path] == cp {
+				nm.rollback(path)
+			}
+		})
+	}
+	return path, nil
+}
+
+func (nm *NetworkManager) checkpointRollback(path dbus.ObjectPath) (map[string]uint32, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("CheckpointRollback"); err != nil {
+		return nil, err
+	}
+	if _, ok := nm.checkpoints[path]; !ok {
+		return nil, noCheckpoint()
+	}
+	return nm.rollback(path), nil
+}
+
+func (nm *NetworkManager) checkpointDestroy(path dbus.ObjectPath) *dbus.Error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("CheckpointDestroy"); err != nil {
+		return err
+	}
+	if _, ok := nm.checkpoints[path]; !ok {
+		return noCheckpoint()
+	}
+	nm.destroyCheckpoint(path)
+	return nil
+}
+
+// destroyCheckpoint forgets the checkpoint at path. Must be called locked
+func (nm *NetworkManager) destroyCheckpoint(path dbus.ObjectPath) {
+	if cp := nm.checkpoints[path]; cp.timer != nil {
+		cp.timer.Stop()
+	}
+	delete(nm.checkpoints, path)
+}
+
+// rollback restores the connections and devices saved at path, and returns
+// the result per device. Must be called locked
+func (nm *NetworkManager) rollback(path dbus.ObjectPath) map[string]uint32 {
+	cp := nm.checkpoints[path]
+	nm.destroyCheckpoint(path)
+	for p := range nm.connections {
+		if _, ok := cp.connections[p]; !ok && cp.flags&checkpointDeleteNewConnections != 0 {
+			delete(nm.connections, p)
+			nm.conn.Export(nil, p, connectionIface)
+		}
+	}
+	for p, settings := range cp.connections {
+		if _, ok := nm.connections[p]; !ok {
+			nm.exportConnection(p)
+		}
+		nm.connections[p] = settings
+	}
+	results := make(map[string]uint32)
+	for p, saved := range cp.devices {
+		d := nm.device(p)
+		if d == nil {
+			results[string(p)] = rollbackNoDevice
+			continue
+		}
+		results[string(p)] = rollbackOk
+		current := dbus.ObjectPath("/")
+		if a, ok := nm.actives[d.active]; ok {
+			current = a.connection
+		}
+		if current == saved.connection && (d.state == StateUnmanaged) == saved.unmanaged {
+			continue
+		}
+		if d.active != "/" {
+			nm.setActiveState(d.active, activeDeactivated, activeUserDisconnected)
+			d.active = "/"
+		}
+		if saved.unmanaged {
+			nm.setState(d, StateUnmanaged, ReasonNowUnmanaged)
+			continue
+		}
+		nm.setState(d, StateDisconnected, ReasonUserRequested)
+		if _, ok := nm.connections[saved.connection]; ok {
+			nm.startActivation(saved.connection, p, saved.ap)
+		}
+	}
+	return results
+}
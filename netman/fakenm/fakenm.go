@@ -0,0 +1,761 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package fakenm is a fake NetworkManager D-Bus service for integration
+// tests. It exports the Manager, Device, Wireless, AccessPoint, Settings and
+// active connection interfaces on a private bus, with scriptable devices,
+// access points and failures
+package fakenm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	busName         = "org.freedesktop.NetworkManager"
+	nmPath          = "/org/freedesktop/NetworkManager"
+	settingsPath    = nmPath + "/Settings"
+	nmIface         = "org.freedesktop.NetworkManager"
+	deviceIface     = nmIface + ".Device"
+	wirelessIface   = deviceIface + ".Wireless"
+	apIface         = nmIface + ".AccessPoint"
+	ip4Iface        = nmIface + ".IP4Config"
+	ip6Iface        = nmIface + ".IP6Config"
+	settingsIface   = nmIface + ".Settings"
+	connectionIface = settingsIface + ".Connection"
+	activeIface     = nmIface + ".Connection.Active"
+	propsIface      = "org.freedesktop.DBus.Properties"
+)
+
+// Device types (NM_DEVICE_TYPE_*)
+const (
+	TypeEthernet uint32 = 1
+	TypeWifi     uint32 = 2
+)
+
+// Device states (NM_DEVICE_STATE_*)
+const (
+	StateUnmanaged    uint32 = 10
+	StateDisconnected uint32 = 30
+	StatePrepare      uint32 = 40
+	StateConfig       uint32 = 50
+	StateNeedAuth     uint32 = 60
+	StateIPConfig     uint32 = 70
+	StateActivated    uint32 = 100
+	StateDeactivating uint32 = 110
+	StateFailed       uint32 = 120
+)
+
+// Device state reasons (NM_DEVICE_STATE_REASON_*)
+const (
+	ReasonNone                 uint32 = 0
+	ReasonNowManaged           uint32 = 2
+	ReasonNowUnmanaged         uint32 = 3
+	ReasonNoSecrets            uint32 = 7
+	ReasonSupplicantDisconnect uint32 = 8
+	ReasonDhcpFailed           uint32 = 17
+	ReasonUserRequested        uint32 = 39
+	ReasonSsidNotFound         uint32 = 53
+)
+
+// Connectivity states (NM_CONNECTIVITY_*)
+const (
+	ConnectivityUnknown uint32 = 0
+	ConnectivityNone    uint32 = 1
+	ConnectivityPortal  uint32 = 2
+	ConnectivityLimited uint32 = 3
+	ConnectivityFull    uint32 = 4
+)
+
+// Active connection states and reasons (NM_ACTIVE_CONNECTION_STATE_*)
+const (
+	activeActivating         uint32 = 1
+	activeActivated          uint32 = 2
+	activeDeactivated        uint32 = 4
+	activeUserDisconnected   uint32 = 2
+	activeDeviceDisconnected uint32 = 3
+)
+
+// secretKeys are the settings not returned by GetSettings
+var secretKeys = []string{"psk", "wep-key0", "wep-key1", "wep-key2", "wep-key3", "password", "private-key-password"}
+
+// Device describes a network device to add
+type Device struct {
+	Interface string
+	Type      uint32
+	Driver    string
+	HwAddress string
+	// Capabilities are the WirelessCapabilities, eg. 0x40 if AP capable
+	Capabilities uint32
+	// Unmanaged devices start in the unmanaged state
+	Unmanaged bool
+	// IP4 and IP6 are the IP configurations the device gets once activated
+	IP4 *IPConfig
+	IP6 *IPConfig
+}
+
+// IPConfig is the IP configuration of an activated device
+type IPConfig struct {
+	// Addresses are in CIDR notation, eg. 192.168.1.10/24
+	Addresses []string
+	Gateway   string
+	DNS       []string
+}
+
+// AccessPoint describes an access point a wifi device finds
+type AccessPoint struct {
+	Ssid      string
+	Bssid     string
+	Strength  uint8
+	Frequency uint32
+	Flags     uint32
+	WpaFlags  uint32
+	RsnFlags  uint32
+	// Hidden access points do not announce their ssid
+	Hidden bool
+	// Passphrase is required to connect, none for open networks
+	Passphrase string
+}
+
+type device struct {
+	Device
+	path     dbus.ObjectPath
+	state    uint32
+	reason   uint32
+	aps      []dbus.ObjectPath
+	lastScan int64
+	active   dbus.ObjectPath
+	ip4      dbus.ObjectPath
+	ip6      dbus.ObjectPath
+}
+
+type active struct {
+	connection dbus.ObjectPath
+	device     dbus.ObjectPath
+	ap         dbus.ObjectPath
+	state      uint32
+}
+
+// NetworkManager is the fake service. Its state can be changed while clients
+// use it
+type NetworkManager struct {
+	conn *dbus.Conn
+	// Delay is the time each activation step takes
+	Delay time.Duration
+
+	mu          sync.Mutex
+	devices     []*device
+	aps         map[dbus.ObjectPath]*AccessPoint
+	ipConfigs   map[dbus.ObjectPath]*IPConfig
+	connections map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	actives     map[dbus.ObjectPath]*active
+	checkpoints map[dbus.ObjectPath]*checkpoint
+	failing     map[string]bool
+	failReason  uint32
+	// connectivity is ConnectivityFull unless set otherwise
+	connectivity uint32
+	next         int
+	// agent is the unique name of the registered secret agent
+	agent string
+}
+
+// New starts a fake NetworkManager on passed bus, without devices
+func New(b *Bus) (*NetworkManager, error) {
+	conn, err := b.Connect()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.RequestName(busName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return nil, fmt.Errorf("cannot own %s: %v", busName, err)
+	}
+	nm := &NetworkManager{
+		conn:         conn,
+		Delay:        10 * time.Millisecond,
+		aps:          make(map[dbus.ObjectPath]*AccessPoint),
+		ipConfigs:    make(map[dbus.ObjectPath]*IPConfig),
+		connections:  make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant),
+		actives:      make(map[dbus.ObjectPath]*active),
+		checkpoints:  make(map[dbus.ObjectPath]*checkpoint),
+		failing:      make(map[string]bool),
+		connectivity: ConnectivityFull,
+	}
+	conn.ExportMethodTable(map[string]interface{}{
+		"GetDevices":               nm.getDevices,
+		"GetAllDevices":            nm.getDevices,
+		"ActivateConnection":       nm.activateConnection,
+		"AddAndActivateConnection": nm.addAndActivateConnection,
+		"CheckConnectivity":        nm.checkConnectivity,
+		"CheckpointCreate":         nm.checkpointCreate,
+		"CheckpointRollback":       nm.checkpointRollback,
+		"CheckpointDestroy":        nm.checkpointDestroy,
+	}, nmPath, nmIface)
+	conn.ExportMethodTable(map[string]interface{}{
+		"ListConnections": nm.listConnections,
+		"AddConnection":   nm.addConnection,
+	}, settingsPath, settingsIface)
+	nm.exportProperties(nmPath)
+	nm.exportAgentManager()
+	return nm, nil
+}
+
+// Close disconnects the service from the bus
+func (nm *NetworkManager) Close() {
+	nm.conn.Close()
+}
+
+// newPath returns a new object path under NetworkManager's one
+func (nm *NetworkManager) newPath(kind string) dbus.ObjectPath {
+	nm.next++
+	return dbus.ObjectPath(fmt.Sprintf("%s/%s/%d", nmPath, kind, nm.next))
+}
+
+// Fail makes calls to passed method, eg. "RequestScan", fail until Recover
+// is called
+func (nm *NetworkManager) Fail(method string) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.failing[method] = true
+}
+
+// Recover makes calls to passed method succeed again
+func (nm *NetworkManager) Recover(method string) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	delete(nm.failing, method)
+}
+
+// FailActivations makes the next activations fail with passed device state
+// reason, ReasonNone to let them succeed again
+func (nm *NetworkManager) FailActivations(reason uint32) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.failReason = reason
+}
+
+// check returns an error if method is set to fail. Must be called locked
+func (nm *NetworkManager) check(method string) *dbus.Error {
+	if nm.failing[method] {
+		return dbus.NewError(nmIface+".Failed", []interface{}{method + " failed"})
+	}
+	return nil
+}
+
+// AddDevice adds a device and returns its path
+func (nm *NetworkManager) AddDevice(d Device) dbus.ObjectPath {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	dev := &device{Device: d, path: nm.newPath("Devices"), state: StateDisconnected, active: "/"}
+	if d.Unmanaged {
+		dev.state = StateUnmanaged
+	}
+	nm.devices = append(nm.devices, dev)
+	nm.conn.ExportMethodTable(map[string]interface{}{
+		"Disconnect": func() *dbus.Error { return nm.disconnect(dev) },
+	}, dev.path, deviceIface)
+	if d.Type == TypeWifi {
+		nm.conn.ExportMethodTable(map[string]interface{}{
+			"GetAccessPoints":    func() ([]dbus.ObjectPath, *dbus.Error) { return nm.accessPoints(dev, false) },
+			"GetAllAccessPoints": func() ([]dbus.ObjectPath, *dbus.Error) { return nm.accessPoints(dev, true) },
+			"RequestScan":        func(options map[string]dbus.Variant) *dbus.Error { return nm.requestScan(dev) },
+		}, dev.path, wirelessIface)
+	}
+	nm.exportProperties(dev.path)
+	dev.ip4 = nm.addIPConfig("IP4Config", d.IP4)
+	dev.ip6 = nm.addIPConfig("IP6Config", d.IP6)
+	nm.conn.Emit(nmPath, nmIface+".DeviceAdded", dev.path)
+	return dev.path
+}
+
+// addIPConfig exports config and returns its path, "/" if config is nil.
+// Must be called locked
+func (nm *NetworkManager) addIPConfig(kind string, config *IPConfig) dbus.ObjectPath {
+	if config == nil {
+		return "/"
+	}
+	path := nm.newPath(kind)
+	nm.ipConfigs[path] = config
+	nm.exportProperties(path)
+	return path
+}
+
+// AddAccessPoint adds an access point found by passed wifi device and returns
+// its path
+func (nm *NetworkManager) AddAccessPoint(device dbus.ObjectPath, ap AccessPoint) dbus.ObjectPath {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	path := nm.newPath("AccessPoint")
+	nm.aps[path] = &ap
+	if d := nm.device(device); d != nil {
+		d.aps = append(d.aps, path)
+	}
+	nm.exportProperties(path)
+	return path
+}
+
+// AddConnection adds a saved connection and returns its path
+func (nm *NetworkManager) AddConnection(settings map[string]map[string]dbus.Variant) dbus.ObjectPath {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	return nm.saveConnection(settings)
+}
+
+// Connections returns the saved connections, with their secrets
+func (nm *NetworkManager) Connections() map[dbus.ObjectPath]map[string]map[string]dbus.Variant {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	connections := make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant)
+	for path, settings := range nm.connections {
+		connections[path] = settings
+	}
+	return connections
+}
+
+// State returns the state of passed device
+func (nm *NetworkManager) State(device dbus.ObjectPath) uint32 {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if d := nm.device(device); d != nil {
+		return d.state
+	}
+	return 0
+}
+
+// SetState changes the state of passed device as if changed outside of the
+// clients, eg. a cable being plugged in
+func (nm *NetworkManager) SetState(device dbus.ObjectPath, state uint32, reason uint32) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if d := nm.device(device); d != nil {
+		nm.setState(d, state, reason)
+	}
+}
+
+// SetConnectivity changes the connectivity NetworkManager reports, one of
+// the Connectivity* values
+func (nm *NetworkManager) SetConnectivity(connectivity uint32) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.connectivity = connectivity
+	nm.conn.Emit(nmPath, propsIface+".PropertiesChanged", nmIface,
+		map[string]dbus.Variant{"Connectivity": dbus.MakeVariant(connectivity)}, []string{})
+}
+
+// device returns the device at path. Must be called locked
+func (nm *NetworkManager) device(path dbus.ObjectPath) *device {
+	for _, d := range nm.devices {
+		if d.path == path {
+			return d
+		}
+	}
+	return nil
+}
+
+// setState changes the state of d and signals it. Must be called locked
+func (nm *NetworkManager) setState(d *device, state uint32, reason uint32) {
+	old := d.state
+	d.state, d.reason = state, reason
+	nm.conn.Emit(d.path, deviceIface+".StateChanged", state, old, reason)
+	nm.conn.Emit(d.path, propsIface+".PropertiesChanged", deviceIface,
+		map[string]dbus.Variant{"State": dbus.MakeVariant(state)}, []string{})
+}
+
+// setActiveState changes the state of active connection a and signals it.
+// Must be called locked
+func (nm *NetworkManager) setActiveState(path dbus.ObjectPath, state uint32, reason uint32) {
+	a, ok := nm.actives[path]
+	if !ok {
+		return
+	}
+	a.state = state
+	nm.conn.Emit(path, activeIface+".StateChanged", state, reason)
+	if state == activeDeactivated {
+		delete(nm.actives, path)
+	}
+}
+
+// saveConnection stores settings as a new connection. Must be called locked
+func (nm *NetworkManager) saveConnection(settings map[string]map[string]dbus.Variant) dbus.ObjectPath {
+	path := nm.newPath("Settings")
+	conn, ok := settings["connection"]
+	if !ok {
+		conn = make(map[string]dbus.Variant)
+		settings["connection"] = conn
+	}
+	if _, ok := conn["uuid"]; !ok {
+		conn["uuid"] = dbus.MakeVariant(fmt.Sprintf("00000000-0000-0000-0000-%012d", nm.next))
+	}
+	if _, ok := conn["type"]; !ok {
+		conn["type"] = dbus.MakeVariant("802-11-wireless")
+	}
+	if _, ok := conn["id"]; !ok {
+		ssid, _ := settings["802-11-wireless"]["ssid"].Value().([]byte)
+		conn["id"] = dbus.MakeVariant(string(ssid))
+	}
+	nm.connections[path] = settings
+	nm.exportConnection(path)
+	return path
+}
+
+// exportConnection exports the methods of the connection at path. Must be
+// called locked
+func (nm *NetworkManager) exportConnection(path dbus.ObjectPath) {
+	nm.conn.ExportMethodTable(map[string]interface{}{
+		"GetSettings": func() (map[string]map[string]dbus.Variant, *dbus.Error) { return nm.getSettings(path, false, "") },
+		"GetSecrets": func(section string) (map[string]map[string]dbus.Variant, *dbus.Error) {
+			return nm.getSettings(path, true, section)
+		},
+		"Update": func(settings map[string]map[string]dbus.Variant) *dbus.Error { return nm.update(path, settings) },
+		"Delete": func() *dbus.Error { return nm.delete(path) },
+	}, path, connectionIface)
+}
+
+func isSecret(key string) bool {
+	for _, k := range secretKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// getSettings returns the settings of a connection without secrets, or only
+// the secrets of section
+func (nm *NetworkManager) getSettings(path dbus.ObjectPath, secrets bool, section string) (map[string]map[string]dbus.Variant, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("GetSettings"); err != nil && !secrets {
+		return nil, err
+	}
+	settings, ok := nm.connections[path]
+	if !ok {
+		return nil, dbus.NewError(settingsIface+".InvalidConnection", []interface{}{"no such connection"})
+	}
+	out := make(map[string]map[string]dbus.Variant)
+	for name, values := range settings {
+		if secrets && name != section {
+			continue
+		}
+		out[name] = make(map[string]dbus.Variant)
+		for k, v := range values {
+			if isSecret(k) == secrets {
+				out[name][k] = v
+			}
+		}
+	}
+	return out, nil
+}
+
+func (nm *NetworkManager) update(path dbus.ObjectPath, settings map[string]map[string]dbus.Variant) *dbus.Error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("Update"); err != nil {
+		return err
+	}
+	if _, ok := nm.connections[path]; !ok {
+		return dbus.NewError(settingsIface+".InvalidConnection", []interface{}{"no such connection"})
+	}
+	nm.connections[path] = settings
+	return nil
+}
+
+func (nm *NetworkManager) delete(path dbus.ObjectPath) *dbus.Error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("Delete"); err != nil {
+		return err
+	}
+	delete(nm.connections, path)
+	nm.conn.Export(nil, path, connectionIface)
+	return nil
+}
+
+func (nm *NetworkManager) listConnections() ([]dbus.ObjectPath, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("ListConnections"); err != nil {
+		return nil, err
+	}
+	var paths []dbus.ObjectPath
+	for path := range nm.connections {
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func (nm *NetworkManager) addConnection(settings map[string]map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("AddConnection"); err != nil {
+		return "/", err
+	}
+	return nm.saveConnection(settings), nil
+}
+
+func (nm *NetworkManager) checkConnectivity() (uint32, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("CheckConnectivity"); err != nil {
+		return 0, err
+	}
+	return nm.connectivity, nil
+}
+
+func (nm *NetworkManager) getDevices() ([]dbus.ObjectPath, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("GetDevices"); err != nil {
+		return nil, err
+	}
+	var paths []dbus.ObjectPath
+	for _, d := range nm.devices {
+		paths = append(paths, d.path)
+	}
+	return paths, nil
+}
+
+func (nm *NetworkManager) accessPoints(d *device, all bool) ([]dbus.ObjectPath, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("GetAllAccessPoints"); err != nil {
+		return nil, err
+	}
+	var paths []dbus.ObjectPath
+	for _, path := range d.aps {
+		if all || !nm.aps[path].Hidden {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// requestScan completes a scan after Delay, updating LastScan
+func (nm *NetworkManager) requestScan(d *device) *dbus.Error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("RequestScan"); err != nil {
+		return err
+	}
+	if d.state == StateUnmanaged {
+		return dbus.NewError(deviceIface+".NotAllowed", []interface{}{"scanning not allowed while unavailable"})
+	}
+	go func() {
+		time.Sleep(nm.Delay)
+		nm.mu.Lock()
+		defer nm.mu.Unlock()
+		d.lastScan++
+		nm.conn.Emit(d.path, propsIface+".PropertiesChanged", wirelessIface,
+			map[string]dbus.Variant{"LastScan": dbus.MakeVariant(d.lastScan)}, []string{})
+	}()
+	return nil
+}
+
+func (nm *NetworkManager) disconnect(d *device) *dbus.Error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("Disconnect"); err != nil {
+		return err
+	}
+	if d.active == "/" {
+		return dbus.NewError(deviceIface+".NotActive", []interface{}{"device is not active"})
+	}
+	nm.setActiveState(d.active, activeDeactivated, activeUserDisconnected)
+	d.active = "/"
+	nm.setState(d, StateDeactivating, ReasonUserRequested)
+	nm.setState(d, StateDisconnected, ReasonUserRequested)
+	return nil
+}
+
+// setManaged changes the managed state of d after Delay
+func (nm *NetworkManager) setManaged(d *device, managed bool) {
+	go func() {
+		time.Sleep(nm.Delay)
+		nm.mu.Lock()
+		defer nm.mu.Unlock()
+		switch {
+		case managed && d.state == StateUnmanaged:
+			nm.setState(d, StateDisconnected, ReasonNowManaged)
+		case !managed && d.state != StateUnmanaged:
+			if d.active != "/" {
+				nm.setActiveState(d.active, activeDeactivated, activeDeviceDisconnected)
+				d.active = "/"
+			}
+			nm.setState(d, StateUnmanaged, ReasonNowUnmanaged)
+		}
+	}()
+}
+
+func (nm *NetworkManager) activateConnection(connection, device, specific dbus.ObjectPath) (dbus.ObjectPath, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("ActivateConnection"); err != nil {
+		return "/", err
+	}
+	if _, ok := nm.connections[connection]; !ok {
+		return "/", dbus.NewError(nmIface+".UnknownConnection", []interface{}{"no such connection"})
+	}
+	return nm.startActivation(connection, device, specific)
+}
+
+func (nm *NetworkManager) addAndActivateConnection(settings map[string]map[string]dbus.Variant, device, specific dbus.ObjectPath) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("AddAndActivateConnection"); err != nil {
+		return "/", "/", err
+	}
+	if nm.device(device) == nil {
+		return "/", "/", dbus.NewError(nmIface+".UnknownDevice", []interface{}{"no such device"})
+	}
+	// like NetworkManager, complete the ssid from the access point
+	if ap, ok := nm.aps[specific]; ok {
+		if _, ok := settings["802-11-wireless"]; !ok {
+			settings["802-11-wireless"] = make(map[string]dbus.Variant)
+		}
+		if _, ok := settings["802-11-wireless"]["ssid"]; !ok {
+			settings["802-11-wireless"]["ssid"] = dbus.MakeVariant([]byte(ap.Ssid))
+		}
+	}
+	connection := nm.saveConnection(settings)
+	a, err := nm.startActivation(connection, device, specific)
+	return connection, a, err
+}
+
+// startActivation creates the active connection and activates it in the
+// background. Must be called locked
+func (nm *NetworkManager) startActivation(connection, device, specific dbus.ObjectPath) (dbus.ObjectPath, *dbus.Error) {
+	d := nm.device(device)
+	if d == nil {
+		return "/", dbus.NewError(nmIface+".UnknownDevice", []interface{}{"no such device"})
+	}
+	if d.state == StateUnmanaged {
+		return "/", dbus.NewError(nmIface+".UnmanagedDevice", []interface{}{"device is not managed"})
+	}
+	if d.active != "/" {
+		nm.setActiveState(d.active, activeDeactivated, activeDeviceDisconnected)
+	}
+	path := nm.newPath("ActiveConnection")
+	nm.actives[path] = &active{connection: connection, device: device, ap: specific, state: activeActivating}
+	d.active = path
+	nm.exportProperties(path)
+	go nm.activate(d, path)
+	return path, nil
+}
+
+// activationFailure returns the reason the activation of a fails, ReasonNone
+// if it succeeds. Must be called locked
+func (nm *NetworkManager) activationFailure(d *device, a *active) uint32 {
+	if nm.failReason != ReasonNone {
+		return nm.failReason
+	}
+	settings := nm.connections[a.connection]
+	ssid, _ := settings["802-11-wireless"]["ssid"].Value().([]byte)
+	var ap *AccessPoint
+	for _, path := range d.aps {
+		if (a.ap == "/" || a.ap == path) && nm.aps[path].Ssid == string(ssid) {
+			ap = nm.aps[path]
+			break
+		}
+	}
+	if ap == nil {
+		return ReasonSsidNotFound
+	}
+	if ap.Passphrase == "" {
+		return ReasonNone
+	}
+	var secret string
+	for _, section := range []string{"802-11-wireless-security", "802-1x"} {
+		for _, k := range secretKeys {
+			if v, ok := settings[section][k].Value().(string); ok {
+				secret = v
+			}
+		}
+	}
+	switch {
+	case secret == "":
+		return ReasonNoSecrets
+	case secret != ap.Passphrase:
+		return ReasonSupplicantDisconnect
+	}
+	return ReasonNone
+}
+
+// activate goes through the device activation states, failing or succeeding
+// as scripted
+func (nm *NetworkManager) activate(d *device, path dbus.ObjectPath) {
+	// step moves d to state after Delay, returns false if the activation
+	// was superseded meanwhile
+	step := func(state uint32, reason uint32) bool {
+		time.Sleep(nm.Delay)
+		nm.mu.Lock()
+		defer nm.mu.Unlock()
+		if d.active != path {
+			return false
+		}
+		nm.setState(d, state, reason)
+		return true
+	}
+	if !step(StatePrepare, ReasonNone) || !step(StateConfig, ReasonNone) {
+		return
+	}
+	nm.mu.Lock()
+	a, ok := nm.actives[path]
+	reason := ReasonNone
+	if ok {
+		reason = nm.activationFailure(d, a)
+	}
+	nm.mu.Unlock()
+	if !ok {
+		return
+	}
+	if reason != ReasonNone {
+		if reason == ReasonNoSecrets || reason == ReasonSupplicantDisconnect {
+			step(StateNeedAuth, ReasonNone)
+		}
+		if !step(StateFailed, reason) {
+			return
+		}
+		nm.mu.Lock()
+		nm.setActiveState(path, activeDeactivated, activeDeviceDisconnected)
+		d.active = "/"
+		nm.mu.Unlock()
+		time.Sleep(nm.Delay)
+		nm.mu.Lock()
+		if d.active == "/" && d.state == StateFailed {
+			nm.setState(d, StateDisconnected, ReasonNone)
+		}
+		nm.mu.Unlock()
+		return
+	}
+	if !step(StateIPConfig, ReasonNone) {
+		return
+	}
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if d.active != path {
+		return
+	}
+	if settings, ok := nm.connections[a.connection]; ok {
+		settings["connection"]["timestamp"] = dbus.MakeVariant(uint64(time.Now().Unix()))
+	}
+	nm.setState(d, StateActivated, ReasonNone)
+	nm.setActiveState(path, activeActivated, ReasonNone)
+}
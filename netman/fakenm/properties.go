@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fakenm
+
+import (
+	"net"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// exportProperties exports the Properties interface of the object at path
+func (nm *NetworkManager) exportProperties(path dbus.ObjectPath) {
+	nm.conn.ExportMethodTable(map[string]interface{}{
+		"Get": func(iface, name string) (dbus.Variant, *dbus.Error) {
+			nm.mu.Lock()
+			defer nm.mu.Unlock()
+			props, err := nm.properties(path, iface)
+			if err != nil {
+				return dbus.Variant{}, err
+			}
+			v, ok := props[name]
+			if !ok {
+				return dbus.Variant{}, unknownProperty(name)
+			}
+			return v, nil
+		},
+		"GetAll": func(iface string) (map[string]dbus.Variant, *dbus.Error) {
+			nm.mu.Lock()
+			defer nm.mu.Unlock()
+			return nm.properties(path, iface)
+		},
+		"Set": func(iface, name string, value dbus.Variant) *dbus.Error {
+			return nm.setProperty(path, iface, name, value)
+		},
+	}, path, propsIface)
+}
+
+func unknownProperty(name string) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []interface{}{"no such property " + name})
+}
+
+func unknownInterface(iface string) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface", []interface{}{"no such interface " + iface})
+}
+
+// properties returns the properties of iface of the object at path. Must be
+// called locked
+func (nm *NetworkManager) properties(path dbus.ObjectPath, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	if err := nm.check("Get"); err != nil {
+		return nil, err
+	}
+	if path == nmPath && iface == nmIface {
+		return nm.managerProperties(), nil
+	}
+	if d := nm.device(path); d != nil {
+		switch {
+		case iface == deviceIface:
+			return deviceProperties(d), nil
+		case iface == wirelessIface && d.Type == TypeWifi:
+			ap, bitrate := dbus.ObjectPath("/"), uint32(0)
+			if a, ok := nm.actives[d.active]; ok && d.state == StateActivated {
+				ap, bitrate = a.ap, 54000
+			}
+			return map[string]dbus.Variant{
+				"WirelessCapabilities": dbus.MakeVariant(d.Capabilities),
+				"LastScan":             dbus.MakeVariant(d.lastScan),
+				"AccessPoints":         dbus.MakeVariant(d.aps),
+				"HwAddress":            dbus.MakeVariant(d.HwAddress),
+				"ActiveAccessPoint":    dbus.MakeVariant(ap),
+				"Bitrate":              dbus.MakeVariant(bitrate),
+			}, nil
+		}
+		return nil, unknownInterface(iface)
+	}
+	if config, ok := nm.ipConfigs[path]; ok && (iface == ip4Iface || iface == ip6Iface) {
+		return ipConfigProperties(config, iface == ip6Iface), nil
+	}
+	if ap, ok := nm.aps[path]; ok && iface == apIface {
+		return apProperties(ap), nil
+	}
+	if a, ok := nm.actives[path]; ok && iface == activeIface {
+		return map[string]dbus.Variant{
+			"Connection":     dbus.MakeVariant(a.connection),
+			"SpecificObject": dbus.MakeVariant(a.ap),
+			"Devices":        dbus.MakeVariant([]dbus.ObjectPath{a.device}),
+			"State":          dbus.MakeVariant(a.state),
+		}, nil
+	}
+	return nil, unknownInterface(iface)
+}
+
+// managerProperties returns the NetworkManager properties. Must be called
+// locked
+func (nm *NetworkManager) managerProperties() map[string]dbus.Variant {
+	devices := []dbus.ObjectPath{}
+	for _, d := range nm.devices {
+		devices = append(devices, d.path)
+	}
+	actives := []dbus.ObjectPath{}
+	for path := range nm.actives {
+		actives = append(actives, path)
+	}
+	return map[string]dbus.Variant{
+		"Devices":           dbus.MakeVariant(devices),
+		"AllDevices":        dbus.MakeVariant(devices),
+		"ActiveConnections": dbus.MakeVariant(actives),
+		"Version":           dbus.MakeVariant("1.2.0"),
+		"Connectivity":      dbus.MakeVariant(nm.connectivity),
+	}
+}
+
+// stateReason is the (state, reason) struct of the StateReason property
+type stateReason struct {
+	State  uint32
+	Reason uint32
+}
+
+// activatedPath returns path if d is activated, else "/"
+func activatedPath(d *device, path dbus.ObjectPath) dbus.ObjectPath {
+	if d.state != StateActivated {
+		return "/"
+	}
+	return path
+}
+
+// ipConfigProperties returns the IP4Config or IP6Config properties of config
+func ipConfigProperties(config *IPConfig, v6 bool) map[string]dbus.Variant {
+	addresses := []map[string]dbus.Variant{}
+	for _, a := range config.Addresses {
+		ip, network, err := net.ParseCIDR(a)
+		if err != nil {
+			continue
+		}
+		prefix, _ := network.Mask.Size()
+		addresses = append(addresses, map[string]dbus.Variant{
+			"address": dbus.MakeVariant(ip.String()),
+			"prefix":  dbus.MakeVariant(uint32(prefix)),
+		})
+	}
+	props := map[string]dbus.Variant{
+		"AddressData": dbus.MakeVariant(addresses),
+		"Gateway":     dbus.MakeVariant(config.Gateway),
+	}
+	if v6 {
+		// IPv6 name servers are only reported as raw addresses
+		servers := [][]byte{}
+		for _, s := range config.DNS {
+			servers = append(servers, []byte(net.ParseIP(s).To16()))
+		}
+		props["Nameservers"] = dbus.MakeVariant(servers)
+		return props
+	}
+	servers := []map[string]dbus.Variant{}
+	for _, s := range config.DNS {
+		servers = append(servers, map[string]dbus.Variant{"address": dbus.MakeVariant(s)})
+	}
+	props["NameserverData"] = dbus.MakeVariant(servers)
+	return props
+}
+
+func deviceProperties(d *device) map[string]dbus.Variant {
+	return map[string]dbus.Variant{
+		"Interface":        dbus.MakeVariant(d.Interface),
+		"DeviceType":       dbus.MakeVariant(d.Type),
+		"Driver":           dbus.MakeVariant(d.Driver),
+		"HwAddress":        dbus.MakeVariant(d.HwAddress),
+		"State":            dbus.MakeVariant(d.state),
+		"StateReason":      dbus.MakeVariant(stateReason{d.state, d.reason}),
+		"Managed":          dbus.MakeVariant(d.state != StateUnmanaged),
+		"ActiveConnection": dbus.MakeVariant(d.active),
+		"Ip4Config":        dbus.MakeVariant(activatedPath(d, d.ip4)),
+		"Ip6Config":        dbus.MakeVariant(activatedPath(d, d.ip6)),
+	}
+}
+
+func apProperties(ap *AccessPoint) map[string]dbus.Variant {
+	ssid := []byte(ap.Ssid)
+	if ap.Hidden {
+		ssid = []byte{}
+	}
+	bssid := ap.Bssid
+	if hw, err := net.ParseMAC(bssid); err == nil {
+		bssid = hw.String()
+	}
+	return map[string]dbus.Variant{
+		"Ssid":       dbus.MakeVariant(ssid),
+		"HwAddress":  dbus.MakeVariant(bssid),
+		"Strength":   dbus.MakeVariant(ap.Strength),
+		"Frequency":  dbus.MakeVariant(ap.Frequency),
+		"MaxBitrate": dbus.MakeVariant(uint32(54000)),
+		"Flags":      dbus.MakeVariant(ap.Flags),
+		"WpaFlags":   dbus.MakeVariant(ap.WpaFlags),
+		"RsnFlags":   dbus.MakeVariant(ap.RsnFlags),
+		"Mode":       dbus.MakeVariant(uint32(2)),
+		"LastSeen":   dbus.MakeVariant(int32(time.Now().Unix())),
+	}
+}
+
+// setProperty sets a writable property, only the Managed one of devices
+func (nm *NetworkManager) setProperty(path dbus.ObjectPath, iface, name string, value dbus.Variant) *dbus.Error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if err := nm.check("Set"); err != nil {
+		return err
+	}
+	d := nm.device(path)
+	if d == nil || iface != deviceIface {
+		return unknownInterface(iface)
+	}
+	if name != "Managed" {
+		return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly", []interface{}{name + " is read only"})
+	}
+	managed, ok := value.Value().(bool)
+	if !ok {
+		return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"Managed is a boolean"})
+	}
+	nm.setManaged(d, managed)
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+
+	"github.com/godbus/dbus"
+)
+
+// anyAp lets NetworkManager choose the access point to activate on
+const anyAp = "/"
+
+// defaultWifiDevice returns the first wifi device
+func (c *Client) defaultWifiDevice() (string, error) {
+	wifiDevices, err := c.wifiDevices()
+	if err != nil {
+		return "", err
+	}
+	if len(wifiDevices) == 0 {
+		return "", opError("find wifi device", "", ErrNoDevice)
+	}
+	return wifiDevices[0], nil
+}
+
+// setHidden sets the explicit ssid of a hidden network in passed settings, so
+// that NetworkManager probes for it instead of looking for a scanned AP
+func setHidden(outer map[string]map[string]dbus.Variant, ssid string) {
+	inner1, ok := outer["802-11-wireless"]
+	if !ok {
+		inner1 = make(map[string]dbus.Variant)
+		outer["802-11-wireless"] = inner1
+	}
+	inner1["ssid"] = dbus.MakeVariant([]byte(ssid))
+	inner1["hidden"] = dbus.MakeVariant(true)
+	inner1["mode"] = dbus.MakeVariant("infrastructure")
+}
+
+// ConnectHiddenAp attempts to connect to an AP that does not broadcast its
+// SSID. As such AP cannot be scanned its security must be passed. If device
+// is empty the first wifi device is used. cfg optionally sets the IP
+// configuration
+func (c *Client) ConnectHiddenAp(ssid string, p string, sec Security, device string, cfg *NetworkConfig) error {
+	if ssid == "" {
+		return errors.New("no SSID provided")
+	}
+	outer, err := wirelessSettings(sec, p)
+	if err != nil {
+		return err
+	}
+	err = cfg.apply(outer)
+	if err != nil {
+		return err
+	}
+	if device == "" {
+		device, err = c.defaultWifiDevice()
+		if err != nil {
+			return err
+		}
+	}
+	setHidden(outer, ssid)
+	return c.activate(ssid, outer, device, anyAp)
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"testing"
+)
+
+func TestConnectHiddenAp(t *testing.T) {
+	mock := &mockObj{connect: true}
+	client := NewClient(mock)
+	if err := client.ConnectHiddenAp("", "secret", SecurityWpaPsk, "", nil); err == nil {
+		t.Errorf("Expected error for empty SSID")
+	}
+	if err := client.ConnectHiddenAp("hidden", "", SecurityWpaPsk, "", nil); err == nil {
+		t.Errorf("Expected error for missing passphrase")
+	}
+	err := client.ConnectHiddenAp("hidden", "secret", SecuritySae, "", nil)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	wireless := mock.settings["802-11-wireless"]
+	if string(wireless["ssid"].Value().([]byte)) != "hidden" || !wireless["hidden"].Value().(bool) {
+		t.Errorf("Expected hidden ssid settings, got: %v", wireless)
+	}
+	if mock.settings["802-11-wireless-security"]["key-mgmt"].Value().(string) != "sae" {
+		t.Errorf("Expected sae security, got: %v", mock.settings["802-11-wireless-security"])
+	}
+	if mock.device != "/d/1" || mock.ap != anyAp {
+		t.Errorf("Expected activation on first wifi device without AP, got: %v %v", mock.device, mock.ap)
+	}
+}
+
+func TestConnectApNotScanned(t *testing.T) {
+	mock := &mockObj{connect: true}
+	client := NewClient(mock)
+	err := client.ConnectAp("hidden", "", map[string]string{}, map[string]string{}, nil)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !mock.settings["802-11-wireless"]["hidden"].Value().(bool) {
+		t.Errorf("Not scanned SSID should be joined as hidden network")
+	}
+	if _, ok := mock.settings["802-11-wireless-security"]; ok {
+		t.Errorf("Hidden network without passphrase should be open")
+	}
+}
+
+func TestParseSecurity(t *testing.T) {
+	for sec := SecurityOpen; sec <= SecurityEnterprise; sec++ {
+		if s, err := ParseSecurity(sec.String()); err != nil || s != sec {
+			t.Errorf("Cannot parse %v: %v", sec, err)
+		}
+	}
+	if _, err := ParseSecurity("wpa4"); err == nil {
+		t.Errorf("Expected error for unknown security")
+	}
+}
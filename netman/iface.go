@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus"
+)
+
+// deviceInterface returns the interface name of passed device
+func (c *Client) deviceInterface(device string) (string, error) {
+	objPath := dbus.ObjectPath(device)
+	obj := c.object(objPath)
+	iface, err := obj.GetProperty("org.freedesktop.NetworkManager.Device.Interface")
+	if err != nil {
+		return "", opError("get Interface", device, err)
+	}
+	name, ok := iface.Value().(string)
+	if !ok {
+		return "", opError("get Interface", device, fmt.Errorf("unexpected type %T", iface.Value()))
+	}
+	return name, nil
+}
+
+// wifiCapabilities returns the capabilities of passed wifi device
+func (c *Client) wifiCapabilities(device string) (WifiCapabilities, error) {
+	objPath := dbus.ObjectPath(device)
+	obj := c.object(objPath)
+	caps, err := obj.GetProperty("org.freedesktop.NetworkManager.Device.Wireless.WirelessCapabilities")
+	if err != nil {
+		return 0, opError("get WirelessCapabilities", device, err)
+	}
+	flags, ok := caps.Value().(uint32)
+	if !ok {
+		return 0, opError("get WirelessCapabilities", device, fmt.Errorf("unexpected type %T", caps.Value()))
+	}
+	return WifiCapabilities(flags), nil
+}
+
+// apCapable returns true if passed wifi device supports AP mode
+func (c *Client) apCapable(device string) bool {
+	caps, err := c.wifiCapabilities(device)
+	return err == nil && caps.Ap()
+}
+
+// WifiInterface returns the wifi interface to host the AP on. If configured
+// is not empty it is returned if it is a wifi interface supporting AP mode,
+// else the first wifi interface supporting AP mode is selected
+func (c *Client) WifiInterface(configured string) (string, error) {
+	wifiDevices, err := c.wifiDevices()
+	if err != nil {
+		return "", err
+	}
+	if len(wifiDevices) == 0 {
+		return "", opError("find wifi device", "", ErrNoDevice)
+	}
+	for _, d := range wifiDevices {
+		iface, err := c.deviceInterface(d)
+		if err != nil {
+			return "", err
+		}
+		if configured != "" {
+			if iface != configured {
+				continue
+			}
+			if !c.apCapable(d) {
+				return "", opError("use wifi device", configured, ErrNoApMode)
+			}
+			return iface, nil
+		}
+		if c.apCapable(d) {
+			return iface, nil
+		}
+	}
+	if configured != "" {
+		return "", opError("find wifi device", configured, ErrNoDevice)
+	}
+	return "", opError("find wifi device supporting AP mode", "", ErrNoDevice)
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+// mockRadios has the wifi interfaces of devices /d/1 and /d/2, only the
+// second one supporting AP mode
+type mockRadios struct {
+	mockObj
+	path dbus.ObjectPath
+}
+
+func (m *mockRadios) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	m.path = path
+	return m
+}
+
+func (m *mockRadios) GetProperty(p string) (dbus.Variant, error) {
+	switch p {
+	case "org.freedesktop.NetworkManager.Device.DeviceType":
+		if m.path == "/d/3" {
+			return dbus.MakeVariant(DeviceTypeEthernet), nil
+		}
+		return dbus.MakeVariant(DeviceTypeWifi), nil
+	case "org.freedesktop.NetworkManager.Device.Interface":
+		if m.path == "/d/1" {
+			return dbus.MakeVariant("wlp1s0"), nil
+		}
+		return dbus.MakeVariant("mlan0"), nil
+	case "org.freedesktop.NetworkManager.Device.Driver":
+		return dbus.MakeVariant("mwifiex"), nil
+	case "org.freedesktop.NetworkManager.Device.HwAddress":
+		return dbus.MakeVariant("00:11:22:33:44:55"), nil
+	case "org.freedesktop.NetworkManager.Device.Wireless.WirelessCapabilities":
+		if m.path == "/d/2" {
+			return dbus.MakeVariant(uint32(0x47)), nil
+		}
+		return dbus.MakeVariant(uint32(0x7)), nil
+	}
+	return m.mockObj.GetProperty(p)
+}
+
+func TestWifiInterface(t *testing.T) {
+	client := NewClient(&mockRadios{})
+	iface, err := client.WifiInterface("")
+	if err != nil || iface != "mlan0" {
+		t.Errorf("AP capable interface should have been selected, got %q: %v", iface, err)
+	}
+	iface, err = client.WifiInterface("mlan0")
+	if err != nil || iface != "mlan0" {
+		t.Errorf("Configured interface should have been used, got %q: %v", iface, err)
+	}
+	if _, err = client.WifiInterface("wlp1s0"); !errors.Is(err, ErrNoApMode) {
+		t.Errorf("Configured interface without AP mode should be refused, got %v", err)
+	}
+	if _, err = client.WifiInterface("eth0"); err == nil {
+		t.Errorf("Unknown interface should not be accepted")
+	}
+}
+
+func TestDeviceInfo(t *testing.T) {
+	client := NewClient(&mockRadios{})
+	d, err := client.DeviceInfo("/d/2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Interface != "mlan0" || d.Driver != "mwifiex" || d.Type != DeviceTypeWifi || !d.Capabilities.Ap() || d.Capabilities.Band5GHz() {
+		t.Errorf("Unexpected device: %+v", d)
+	}
+	if names := d.Capabilities.Names(); len(names) != 4 || names[0] != "ap" {
+		t.Errorf("Unexpected capabilities: %v", names)
+	}
+	if caps := WifiCapAp | WifiCapFreqValid | WifiCap5GHz; !caps.Band5GHz() || caps.Names()[1] != "5ghz" {
+		t.Errorf("5 GHz band should be supported: %v", caps.Names())
+	}
+	if DeviceTypeName(DeviceTypeEthernet) != "ethernet" || DeviceStateName(DeviceStateActivated) != "activated" {
+		t.Errorf("Unexpected type or state names")
+	}
+}
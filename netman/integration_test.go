@@ -0,0 +1,405 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/UCWifiConnect/netman/fakenm"
+	"github.com/godbus/dbus"
+)
+
+// fakeNetwork is a fake NetworkManager with a wifi and an ethernet device,
+// and a client connected to it
+type fakeNetwork struct {
+	bus   *fakenm.Bus
+	nm    *fakenm.NetworkManager
+	conn  *dbus.Conn
+	c     *Client
+	wifi  dbus.ObjectPath
+	ether dbus.ObjectPath
+}
+
+func newFakeNetwork(t *testing.T) *fakeNetwork {
+	bus, err := fakenm.StartBus()
+	if err == fakenm.ErrNoDaemon {
+		t.Skip("dbus-daemon is not installed")
+	}
+	if err != nil {
+		t.Fatalf("Cannot start bus: %v", err)
+	}
+	nm, err := fakenm.New(bus)
+	if err != nil {
+		bus.Close()
+		t.Fatalf("Cannot start fake NetworkManager: %v", err)
+	}
+	conn, err := bus.Connect()
+	if err != nil {
+		nm.Close()
+		bus.Close()
+		t.Fatalf("Cannot connect to bus: %v", err)
+	}
+	f := &fakeNetwork{bus: bus, nm: nm, conn: conn, c: NewBusClient(conn)}
+	f.wifi = nm.AddDevice(fakenm.Device{Interface: "wlan0", Type: fakenm.TypeWifi, HwAddress: "00:11:22:33:44:55", Driver: "iwlwifi", Capabilities: uint32(WifiCapAp),
+		IP4: &fakenm.IPConfig{Addresses: []string{"192.168.1.10/24"}, Gateway: "192.168.1.1", DNS: []string{"192.168.1.1"}},
+		IP6: &fakenm.IPConfig{Addresses: []string{"2001:db8::10/64"}, DNS: []string{"2001:db8::1"}}})
+	f.ether = nm.AddDevice(fakenm.Device{Interface: "eth0", Type: fakenm.TypeEthernet})
+	nm.AddAccessPoint(f.wifi, fakenm.AccessPoint{Ssid: "home", Bssid: "00:11:22:33:44:01", Strength: 40, Frequency: 2412,
+		Flags: apFlagsPrivacy, RsnFlags: apSecKeyMgmtPsk, Passphrase: "secret12"})
+	nm.AddAccessPoint(f.wifi, fakenm.AccessPoint{Ssid: "home", Bssid: "00:11:22:33:44:02", Strength: 80, Frequency: 5180,
+		Flags: apFlagsPrivacy, RsnFlags: apSecKeyMgmtPsk, Passphrase: "secret12"})
+	nm.AddAccessPoint(f.wifi, fakenm.AccessPoint{Ssid: "cafe", Bssid: "00:11:22:33:44:03", Strength: 60, Frequency: 2437})
+	nm.AddAccessPoint(f.wifi, fakenm.AccessPoint{Ssid: "lab", Bssid: "00:11:22:33:44:04", Strength: 50, Frequency: 2462,
+		Flags: apFlagsPrivacy, RsnFlags: apSecKeyMgmtPsk, Passphrase: "secret12", Hidden: true})
+	return f
+}
+
+func (f *fakeNetwork) Close() {
+	f.conn.Close()
+	f.nm.Close()
+	f.bus.Close()
+}
+
+func TestFakeScan(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+
+	if iface, err := f.c.WifiInterface(""); err != nil || iface != "wlan0" {
+		t.Errorf("Expected wlan0, got %q: %v", iface, err)
+	}
+	ssids, _, ssid2ap, err := f.c.ScannedSsids(time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(ssids) != 2 || ssids[0].Ssid != "home" || len(ssids[0].BSSes) != 2 || ssids[1].Ssid != "cafe" {
+		t.Errorf("Unexpected networks: %v", ssids)
+	}
+	if ssids[0].Security != SecurityWpaPsk || ssids[1].Security != SecurityOpen {
+		t.Errorf("Unexpected securities: %v", ssids)
+	}
+	if _, ok := ssid2ap["lab"]; ok {
+		t.Errorf("Hidden network should not be listed")
+	}
+
+	f.nm.Fail("RequestScan")
+	if ssids, _, _, err := f.c.ScannedSsids(time.Second); err != nil || len(ssids) != 2 {
+		t.Errorf("Cached results should be returned when scanning fails: %v", ssids)
+	}
+}
+
+func TestFakeManaged(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+
+	wifis, err := f.c.wifiDevices()
+	if err != nil || len(wifis) != 1 || wifis[0] != string(f.wifi) {
+		t.Fatalf("Unexpected wifi devices: %v", wifis)
+	}
+	if iface, err := f.c.SetIfaceManaged("wlan0", false, wifis); err != nil || iface != "wlan0" {
+		t.Errorf("wlan0 should have been unmanaged")
+	}
+	if f.nm.State(f.wifi) != fakenm.StateUnmanaged {
+		t.Errorf("Unexpected state %d", f.nm.State(f.wifi))
+	}
+	if ifaces, err := f.c.WifisManaged(wifis); err != nil || len(ifaces) != 0 {
+		t.Errorf("No wifi should be managed: %v %v", ifaces, err)
+	}
+	if iface, err := f.c.SetIfaceManaged("wlan0", true, wifis); err != nil || iface != "wlan0" {
+		t.Errorf("wlan0 should have been managed")
+	}
+	if ifaces, err := f.c.WifisManaged(wifis); err != nil || ifaces["wlan0"] != string(f.wifi) {
+		t.Errorf("wlan0 should be managed: %v %v", ifaces, err)
+	}
+}
+
+func TestFakeDevices(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+
+	devices, err := f.c.Devices()
+	if err != nil || len(devices) != 2 {
+		t.Fatalf("2 devices expected, got %v: %v", devices, err)
+	}
+	wifi := devices[0]
+	if wifi.Interface != "wlan0" || wifi.Driver != "iwlwifi" || wifi.HwAddress != "00:11:22:33:44:55" ||
+		wifi.State != DeviceStateDisconnected || !wifi.Managed || !wifi.Capabilities.Ap() {
+		t.Errorf("Unexpected wifi device: %+v", wifi)
+	}
+	if ether := devices[1]; ether.Interface != "eth0" || ether.Type != DeviceTypeEthernet || ether.Capabilities != 0 {
+		t.Errorf("Unexpected ethernet device: %+v", ether)
+	}
+	if _, err := f.c.WifiInterface("wlan0"); err != nil {
+		t.Errorf("wlan0 supports AP mode: %v", err)
+	}
+}
+
+func TestFakeConnectivity(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+
+	if c, err := f.c.CheckConnectivity(); err != nil || c != ConnectivityFull {
+		t.Errorf("Full connectivity expected, got %v: %v", c, err)
+	}
+	f.nm.SetConnectivity(fakenm.ConnectivityPortal)
+	if c, err := f.c.CheckConnectivity(); err != nil || c != ConnectivityPortal {
+		t.Errorf("Portal connectivity expected, got %v: %v", c, err)
+	}
+	if c, err := f.c.Connectivity(); err != nil || c != ConnectivityPortal {
+		t.Errorf("Portal connectivity property expected, got %v: %v", c, err)
+	}
+	f.nm.Fail("CheckConnectivity")
+	if c, err := f.c.CheckConnectivity(); err == nil || c != ConnectivityUnknown {
+		t.Errorf("Failed check should give unknown connectivity, got %v: %v", c, err)
+	}
+}
+
+func TestFakeConnect(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+
+	_, ap2device, ssid2ap, err := f.c.Ssids()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := f.c.ConnectAp("home", "wrong123", ap2device, ssid2ap, nil); err != ErrBadSecret {
+		t.Errorf("Expected a bad secret error, got: %v", err)
+	}
+	if len(f.nm.Connections()) != 0 {
+		t.Errorf("Failed profile should have been deleted: %v", f.nm.Connections())
+	}
+
+	err = f.c.ConnectAp("home", "secret12", ap2device, ssid2ap, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	devices, _ := f.c.GetDevices()
+	wifis, _ := f.c.GetWifiDevices(devices)
+	wifi, _ := f.c.ConnectedWifi(wifis)
+	ethernet, _ := f.c.ConnectedEthernet(devices)
+	if !wifi || ethernet {
+		t.Errorf("Only wifi should be connected")
+	}
+	if len(f.nm.Connections()) != 1 {
+		t.Errorf("Profile should have been saved: %v", f.nm.Connections())
+	}
+
+	// a wrong passphrase does not spoil the saved profile
+	if err = f.c.ConnectAp("home", "wrong123", ap2device, ssid2ap, nil); err != ErrBadSecret {
+		t.Errorf("Expected a bad secret error, got: %v", err)
+	}
+	for _, settings := range f.nm.Connections() {
+		if psk := settings["802-11-wireless-security"]["psk"].Value(); psk != "secret12" {
+			t.Errorf("The saved passphrase should have been restored, got: %v", psk)
+		}
+	}
+
+	// the saved profile is reused
+	if err = f.c.ConnectAp("home", "secret12", ap2device, ssid2ap, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(f.nm.Connections()) != 1 {
+		t.Errorf("Profile should have been reused: %v", f.nm.Connections())
+	}
+
+	status, err := f.c.Status("wlan0")
+	if err != nil {
+		t.Fatalf("Unexpected status error: %v", err)
+	}
+	if status.Ssid != "home" || status.Bssid != "00:11:22:33:44:02" || status.Strength != 80 || status.Frequency != 5180 || status.Bitrate != 54000 {
+		t.Errorf("Unexpected access point status: %+v", status)
+	}
+	if len(status.IPv4.Addresses) != 1 || status.IPv4.Addresses[0] != "192.168.1.10/24" || status.IPv4.Gateway != "192.168.1.1" ||
+		len(status.IPv4.DNS) != 1 || status.IPv4.DNS[0] != "192.168.1.1" {
+		t.Errorf("Unexpected IPv4 status: %+v", status.IPv4)
+	}
+	if len(status.IPv6.Addresses) != 1 || status.IPv6.Addresses[0] != "2001:db8::10/64" || len(status.IPv6.DNS) != 1 || status.IPv6.DNS[0] != "2001:db8::1" {
+		t.Errorf("Unexpected IPv6 status: %+v", status.IPv6)
+	}
+
+	n, err := f.c.DisconnectWifi(wifis)
+	if wifi, _ = f.c.ConnectedWifi(wifis); err != nil || n != 1 || wifi {
+		t.Errorf("Wifi should have been disconnected")
+	}
+	if _, err = f.c.Status("wlan0"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected a not connected error, got: %v", err)
+	}
+
+	if err = f.c.ConnectHiddenAp("lab", "secret12", SecurityWpaPsk, "", nil); err != nil {
+		t.Errorf("Unexpected error joining hidden network: %v", err)
+	}
+	if err = f.c.ConnectHiddenAp("nowhere", "secret12", SecurityWpaPsk, "", nil); err != ErrSsidNotFound {
+		t.Errorf("Expected a not found error, got: %v", err)
+	}
+
+	f.nm.FailActivations(fakenm.ReasonDhcpFailed)
+	if err = f.c.ConnectAp("cafe", "", ap2device, ssid2ap, nil); err != ErrDhcpFailed {
+		t.Errorf("Expected a DHCP error, got: %v", err)
+	}
+}
+
+// waitSsid waits until wlan0 is connected to ssid
+func (f *fakeNetwork) waitSsid(t *testing.T, ssid string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, err := f.c.Status("wlan0")
+		if err == nil && status.Ssid == ssid {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("wlan0 not connected to %s: %+v, %v", ssid, status, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFakeCheckpoint(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+
+	_, ap2device, ssid2ap, err := f.c.Ssids()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = f.c.ConnectAp("home", "secret12", ap2device, ssid2ap, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// rolling back deletes the new profile and reconnects the previous one
+	checkpoint, err := f.c.CheckpointCreate(nil, time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected checkpoint error: %v", err)
+	}
+	if err = f.c.ConnectAp("cafe", "", ap2device, ssid2ap, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(f.nm.Connections()) != 2 {
+		t.Errorf("Profile should have been saved: %v", f.nm.Connections())
+	}
+	if err = f.c.CheckpointRollback(checkpoint); err != nil {
+		t.Errorf("Unexpected rollback error: %v", err)
+	}
+	if len(f.nm.Connections()) != 1 || f.nm.Checkpoints() != 0 {
+		t.Errorf("Profile should have been deleted: %v", f.nm.Connections())
+	}
+	f.waitSsid(t, "home")
+	if err = f.c.CheckpointRollback(checkpoint); err == nil {
+		t.Errorf("Rolling back twice should fail")
+	}
+
+	// destroying keeps the new configuration
+	if checkpoint, err = f.c.CheckpointCreate(nil, time.Minute); err != nil {
+		t.Fatalf("Unexpected checkpoint error: %v", err)
+	}
+	if err = f.c.ConnectAp("cafe", "", ap2device, ssid2ap, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = f.c.CheckpointDestroy(checkpoint); err != nil {
+		t.Errorf("Unexpected destroy error: %v", err)
+	}
+	if len(f.nm.Connections()) != 2 || f.nm.Checkpoints() != 0 {
+		t.Errorf("Profile should have been kept: %v", f.nm.Connections())
+	}
+	f.waitSsid(t, "cafe")
+
+	// NetworkManager rolls back by itself after the timeout
+	if _, err = f.c.CheckpointCreate(nil, time.Second); err != nil {
+		t.Fatalf("Unexpected checkpoint error: %v", err)
+	}
+	if err = f.c.ConnectAp("home", "secret12", ap2device, ssid2ap, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	time.Sleep(1200 * time.Millisecond)
+	f.waitSsid(t, "cafe")
+}
+
+func TestFakeRoam(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+
+	_, ap2device, ssid2ap, err := f.c.Ssids()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, ssid := range []string{"home", "cafe"} {
+		if err = f.c.ConnectAp(ssid, map[string]string{"home": "secret12"}[ssid], ap2device, ssid2ap, nil); err != nil {
+			t.Fatalf("Unexpected error connecting to %s: %v", ssid, err)
+		}
+	}
+
+	// the strongest BSS of home is stronger by the margin than cafe
+	to, found, err := f.c.FindRoamCandidate("wlan0", 15)
+	if err != nil || !found || to.Ssid != "home" || to.Bssid != "00:11:22:33:44:02" {
+		t.Fatalf("Unexpected candidate %+v, %v, %v", to, found, err)
+	}
+	if err = f.c.Roam("wlan0", to); err != nil {
+		t.Fatalf("Unexpected roam error: %v", err)
+	}
+	status, err := f.c.Status("wlan0")
+	if err != nil || status.Bssid != "00:11:22:33:44:02" {
+		t.Errorf("Should have roamed: %+v, %v", status, err)
+	}
+	if len(f.nm.Connections()) != 2 {
+		t.Errorf("Saved profiles should have been used: %v", f.nm.Connections())
+	}
+	if _, found, err = f.c.FindRoamCandidate("wlan0", 15); err != nil || found {
+		t.Errorf("No candidate expected from the strongest BSS: %v", err)
+	}
+}
+
+func TestFakeMonitor(t *testing.T) {
+	f := newFakeNetwork(t)
+	defer f.Close()
+
+	m, err := f.c.NewMonitor()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer m.Close()
+	if m.Connected() {
+		t.Errorf("Nothing should be connected yet")
+	}
+
+	f.nm.SetState(f.ether, fakenm.StateActivated, fakenm.ReasonNone)
+	if !waitChanges(m, m.ConnectedEthernet) || m.ConnectedWifi() {
+		t.Errorf("Only ethernet should be connected")
+	}
+
+	// devices added later are followed too
+	usb := f.nm.AddDevice(fakenm.Device{Interface: "wlan1", Type: fakenm.TypeWifi})
+	time.Sleep(100 * time.Millisecond)
+	f.nm.SetState(usb, fakenm.StateActivated, fakenm.ReasonNone)
+	if !waitChanges(m, m.ConnectedWifi) {
+		t.Errorf("Added wifi device should be connected")
+	}
+}
+
+// waitChanges waits until the monitor notifies a change after which cond is
+// true, false if it does not happen within 2 seconds
+func waitChanges(m *Monitor, cond func() bool) bool {
+	deadline := time.After(2 * time.Second)
+	for !cond() {
+		select {
+		case <-m.Changes():
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
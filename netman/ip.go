@@ -0,0 +1,319 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// IP configuration methods. Dhcp and ignore only apply to IPv6
+const (
+	IPMethodAuto   = "auto"
+	IPMethodManual = "manual"
+	IPMethodDhcp   = "dhcp"
+	IPMethodIgnore = "ignore"
+)
+
+// IPv6 privacy extensions modes
+const (
+	IPv6PrivacyDisabled        = "disabled"
+	IPv6PrivacyPreferPublic    = "prefer-public"
+	IPv6PrivacyPreferTemporary = "prefer-temporary"
+)
+
+// ip6Privacy maps the privacy extensions modes to the ip6-privacy values
+var ip6Privacy = map[string]int32{
+	IPv6PrivacyDisabled:        0,
+	IPv6PrivacyPreferPublic:    1,
+	IPv6PrivacyPreferTemporary: 2,
+}
+
+// IPConfig is the IP configuration of a connection. Addresses are in CIDR
+// notation, eg. 192.168.1.10/24
+type IPConfig struct {
+	Method    string
+	Addresses []string
+	Gateway   string
+	DNS       []string
+	DNSSearch []string
+	// Privacy is the IPv6 privacy extensions mode, empty for the
+	// NetworkManager default
+	Privacy string
+}
+
+// NetworkConfig holds the optional IP configuration to join a network with.
+// A nil configuration keeps the saved one, or uses automatic configuration
+// for new networks
+type NetworkConfig struct {
+	IPv4 *IPConfig
+	IPv6 *IPConfig
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseIPConfig returns the IP configuration from passed method and comma
+// separated lists. An empty method means auto
+func parseIPConfig(method, addresses, gateway, dns, search string) *IPConfig {
+	method = strings.ToLower(strings.TrimSpace(method))
+	if method == "" {
+		method = IPMethodAuto
+	}
+	return &IPConfig{
+		Method:    method,
+		Addresses: splitList(addresses),
+		Gateway:   strings.TrimSpace(gateway),
+		DNS:       splitList(dns),
+		DNSSearch: splitList(search),
+	}
+}
+
+// isDefault returns true if the configuration is plain auto
+func (ip *IPConfig) isDefault() bool {
+	return ip.Method == IPMethodAuto && len(ip.Addresses) == 0 && ip.Gateway == "" &&
+		len(ip.DNS) == 0 && len(ip.DNSSearch) == 0 && ip.Privacy == ""
+}
+
+// ParseIPConfig returns the IPv4 configuration from passed method and comma
+// separated lists of addresses, DNS servers and search domains. An empty
+// method means auto. Returns nil if nothing but auto is set
+func ParseIPConfig(method, addresses, gateway, dns, search string) (*IPConfig, error) {
+	ip := parseIPConfig(method, addresses, gateway, dns, search)
+	if _, err := ip.ipv4Settings(); err != nil {
+		return nil, err
+	}
+	if ip.isDefault() {
+		return nil, nil
+	}
+	return ip, nil
+}
+
+// ParseIPv6Config returns the IPv6 configuration like ParseIPConfig, plus the
+// privacy extensions mode
+func ParseIPv6Config(method, addresses, gateway, dns, search, privacy string) (*IPConfig, error) {
+	ip := parseIPConfig(method, addresses, gateway, dns, search)
+	ip.Privacy = strings.ToLower(strings.TrimSpace(privacy))
+	if _, err := ip.ipv6Settings(); err != nil {
+		return nil, err
+	}
+	if ip.isDefault() {
+		return nil, nil
+	}
+	return ip, nil
+}
+
+// ipv4Uint32 encodes an IPv4 address the way NetworkManager expects it in
+// the dns property: the network byte order bytes read as a host integer
+func ipv4Uint32(ip net.IP) uint32 {
+	return binary.NativeEndian.Uint32(ip.To4())
+}
+
+// familyName returns the name of the IPv4 or IPv6 family for messages
+func familyName(v6 bool) string {
+	if v6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// parseAddress parses a single address of the IPv4 or IPv6 family
+func parseAddress(s string, v6 bool) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil || (ip.To4() == nil) != v6 {
+		return nil, fmt.Errorf("invalid %s address: %q", familyName(v6), s)
+	}
+	return ip, nil
+}
+
+// settings returns the ipv4 or ipv6 settings section for the configuration
+func (ip *IPConfig) settings(v6 bool) (map[string]dbus.Variant, error) {
+	family := familyName(v6)
+	method := ip.Method
+	if method == "" {
+		method = IPMethodAuto
+	}
+	s := make(map[string]dbus.Variant)
+	switch {
+	case method == IPMethodManual:
+		if len(ip.Addresses) == 0 {
+			return nil, fmt.Errorf("an address is required for manual %s configuration", family)
+		}
+		var addresses []map[string]dbus.Variant
+		for _, a := range ip.Addresses {
+			addr, network, err := net.ParseCIDR(a)
+			if err != nil || (addr.To4() == nil) != v6 {
+				return nil, fmt.Errorf("invalid %s address: %q", family, a)
+			}
+			prefix, _ := network.Mask.Size()
+			addresses = append(addresses, map[string]dbus.Variant{
+				"address": dbus.MakeVariant(addr.String()),
+				"prefix":  dbus.MakeVariant(uint32(prefix)),
+			})
+		}
+		s["address-data"] = dbus.MakeVariant(addresses)
+		if ip.Gateway != "" {
+			gw, err := parseAddress(ip.Gateway, v6)
+			if err != nil {
+				return nil, err
+			}
+			s["gateway"] = dbus.MakeVariant(gw.String())
+		}
+	case method == IPMethodAuto || (v6 && method == IPMethodDhcp):
+		if len(ip.Addresses) > 0 || ip.Gateway != "" {
+			return nil, fmt.Errorf("addresses and gateway require manual %s configuration", family)
+		}
+		if len(ip.DNS) > 0 {
+			// only use the passed DNS servers
+			s["ignore-auto-dns"] = dbus.MakeVariant(true)
+		}
+	case v6 && method == IPMethodIgnore:
+		if len(ip.Addresses) > 0 || ip.Gateway != "" || len(ip.DNS) > 0 || len(ip.DNSSearch) > 0 {
+			return nil, errors.New("IPv6 settings cannot be set when it is ignored")
+		}
+	default:
+		return nil, fmt.Errorf("unknown %s method: %q", family, ip.Method)
+	}
+	s["method"] = dbus.MakeVariant(method)
+
+	if len(ip.DNS) > 0 {
+		var dns4 []uint32
+		var dns6 [][]byte
+		for _, d := range ip.DNS {
+			server, err := parseAddress(d, v6)
+			if err != nil {
+				return nil, err
+			}
+			if v6 {
+				dns6 = append(dns6, []byte(server.To16()))
+			} else {
+				dns4 = append(dns4, ipv4Uint32(server))
+			}
+		}
+		if v6 {
+			s["dns"] = dbus.MakeVariant(dns6)
+		} else {
+			s["dns"] = dbus.MakeVariant(dns4)
+		}
+	}
+	if len(ip.DNSSearch) > 0 {
+		s["dns-search"] = dbus.MakeVariant(ip.DNSSearch)
+	}
+
+	if ip.Privacy != "" {
+		privacy, ok := ip6Privacy[ip.Privacy]
+		if !v6 || !ok {
+			return nil, fmt.Errorf("invalid %s privacy: %q", family, ip.Privacy)
+		}
+		s["ip6-privacy"] = dbus.MakeVariant(privacy)
+	}
+	return s, nil
+}
+
+// ipv4Settings returns the ipv4 settings section for the configuration
+func (ip *IPConfig) ipv4Settings() (map[string]dbus.Variant, error) {
+	return ip.settings(false)
+}
+
+// ipv6Settings returns the ipv6 settings section for the configuration
+func (ip *IPConfig) ipv6Settings() (map[string]dbus.Variant, error) {
+	return ip.settings(true)
+}
+
+// apply adds the IP settings sections of the configuration to passed
+// connection settings. Nothing is added for a nil configuration
+func (cfg *NetworkConfig) apply(outer map[string]map[string]dbus.Variant) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.IPv4 != nil {
+		ipv4, err := cfg.IPv4.ipv4Settings()
+		if err != nil {
+			return err
+		}
+		outer["ipv4"] = ipv4
+	}
+	if cfg.IPv6 != nil {
+		ipv6, err := cfg.IPv6.ipv6Settings()
+		if err != nil {
+			return err
+		}
+		outer["ipv6"] = ipv6
+	}
+	return nil
+}
+
+// DeviceByInterface returns the device of passed interface name
+func (c *Client) DeviceByInterface(iface string) (string, error) {
+	devices, err := c.GetDevices()
+	if err != nil {
+		return "", err
+	}
+	for _, d := range devices {
+		name, err := c.deviceInterface(d)
+		if err != nil {
+			return "", err
+		}
+		if name == iface {
+			return d, nil
+		}
+	}
+	return "", opError("find device", iface, ErrNoDevice)
+}
+
+// IP6Addresses returns the IPv6 addresses, in CIDR notation, the passed
+// interface has got, as reported by its Ip6Config
+func (c *Client) IP6Addresses(iface string) ([]string, error) {
+	device, err := c.DeviceByInterface(iface)
+	if err != nil {
+		return nil, err
+	}
+	obj := c.object(dbus.ObjectPath(device))
+	v, err := obj.GetProperty("org.freedesktop.NetworkManager.Device.Ip6Config")
+	if err != nil {
+		return nil, opError("get Ip6Config", device, err)
+	}
+	config, _ := v.Value().(dbus.ObjectPath)
+	if config == "" || config == "/" {
+		// not configured
+		return nil, nil
+	}
+	obj = c.object(config)
+	v, err = obj.GetProperty("org.freedesktop.NetworkManager.IP6Config.AddressData")
+	if err != nil {
+		return nil, opError("get AddressData", string(config), err)
+	}
+	addresses, err := addressData(v)
+	if err != nil {
+		return nil, opError("get AddressData", string(config), err)
+	}
+	return addresses, nil
+}
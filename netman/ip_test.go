@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestParseIPConfig(t *testing.T) {
+	if ip, err := ParseIPConfig("", "", "", "", ""); ip != nil || err != nil {
+		t.Errorf("Empty configuration should be nil, got %v: %v", ip, err)
+	}
+	if _, err := ParseIPConfig("static", "", "", "", ""); err == nil {
+		t.Errorf("Unknown method should not be accepted")
+	}
+	ip, err := ParseIPConfig("Manual", "192.168.1.10/24, 10.0.0.2/8", "192.168.1.1", "8.8.8.8,", "example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ip.Method != IPMethodManual || len(ip.Addresses) != 2 || len(ip.DNS) != 1 || ip.DNSSearch[0] != "example.com" {
+		t.Errorf("Configuration not parsed properly: %v", ip)
+	}
+}
+
+func TestIPv4Settings(t *testing.T) {
+	ip := &IPConfig{
+		Method:    IPMethodManual,
+		Addresses: []string{"192.168.1.10/24"},
+		Gateway:   "192.168.1.1",
+		DNS:       []string{"1.2.3.4"},
+		DNSSearch: []string{"example.com"},
+	}
+	s, err := ip.ipv4Settings()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	addresses := s["address-data"].Value().([]map[string]dbus.Variant)
+	if len(addresses) != 1 || addresses[0]["address"].Value().(string) != "192.168.1.10" || addresses[0]["prefix"].Value().(uint32) != 24 {
+		t.Errorf("Unexpected addresses: %v", addresses)
+	}
+	if s["gateway"].Value().(string) != "192.168.1.1" || s["method"].Value().(string) != "manual" {
+		t.Errorf("Unexpected settings: %v", s)
+	}
+	// the bytes of 1.2.3.4 as they are in memory
+	if dns := s["dns"].Value().([]uint32); len(dns) != 1 || dns[0] != binary.NativeEndian.Uint32([]byte{1, 2, 3, 4}) {
+		t.Errorf("Unexpected DNS servers: %v", dns)
+	}
+
+	invalid := []*IPConfig{
+		{Method: IPMethodManual},
+		{Method: IPMethodManual, Addresses: []string{"192.168.1.10"}},
+		{Method: IPMethodManual, Addresses: []string{"fe80::1/64"}},
+		{Method: IPMethodManual, Addresses: []string{"192.168.1.10/24"}, Gateway: "gw"},
+		{Method: IPMethodAuto, Addresses: []string{"192.168.1.10/24"}},
+		{Method: IPMethodAuto, DNS: []string{"dns"}},
+	}
+	for _, ip := range invalid {
+		if _, err := ip.ipv4Settings(); err == nil {
+			t.Errorf("Expected error for configuration: %v", ip)
+		}
+	}
+}
+
+func TestConnectApStaticIP(t *testing.T) {
+	mock := &mockObj{connect: true}
+	client := NewClient(mock)
+	ip, _ := ParseIPConfig("auto", "", "", "9.9.9.9", "")
+	cfg := &NetworkConfig{IPv4: ip}
+	err := client.ConnectAp("ssid0", "", map[string]string{"/ap/1": "/d/1"}, map[string]string{"ssid0": "/ap/1"}, cfg)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	ipv4 := mock.settings["ipv4"]
+	if ipv4["method"].Value().(string) != "auto" || !ipv4["ignore-auto-dns"].Value().(bool) {
+		t.Errorf("Expected DHCP with custom DNS, got: %v", ipv4)
+	}
+}
+
+func TestIPv6Settings(t *testing.T) {
+	ip, err := ParseIPv6Config("manual", "2001:db8::10/64", "2001:db8::1", "2001:4860:4860::8888", "", "prefer-temporary")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	s, err := ip.ipv6Settings()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	addresses := s["address-data"].Value().([]map[string]dbus.Variant)
+	if addresses[0]["address"].Value().(string) != "2001:db8::10" || addresses[0]["prefix"].Value().(uint32) != 64 {
+		t.Errorf("Unexpected addresses: %v", addresses)
+	}
+	if dns := s["dns"].Value().([][]byte); len(dns) != 1 || len(dns[0]) != 16 {
+		t.Errorf("Unexpected DNS servers: %v", dns)
+	}
+	if s["ip6-privacy"].Value().(int32) != 2 {
+		t.Errorf("Unexpected privacy: %v", s["ip6-privacy"])
+	}
+	if ip, err := ParseIPv6Config("ignore", "", "", "", "", ""); err != nil || ip.Method != IPMethodIgnore {
+		t.Errorf("Ignored IPv6 should be accepted, got %v: %v", ip, err)
+	}
+
+	invalid := [][]string{
+		{"manual", "192.168.1.10/24", "", "", "", ""},
+		{"dhcp", "", "", "8.8.8.8", "", ""},
+		{"ignore", "", "", "2001:4860:4860::8888", "", ""},
+		{"auto", "", "", "", "", "always"},
+		{"disabled", "", "", "", "", ""},
+	}
+	for _, args := range invalid {
+		if _, err := ParseIPv6Config(args[0], args[1], args[2], args[3], args[4], args[5]); err == nil {
+			t.Errorf("Expected error for configuration: %v", args)
+		}
+	}
+	if _, err := ParseIPConfig("ignore", "", "", "", ""); err == nil {
+		t.Errorf("IPv4 cannot be ignored")
+	}
+}
+
+// mockIP6Config reports IPv6 addresses for the mlan0 interface of mockRadios
+type mockIP6Config struct {
+	mockRadios
+}
+
+func (m *mockIP6Config) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	m.path = path
+	return m
+}
+
+func (m *mockIP6Config) GetProperty(p string) (dbus.Variant, error) {
+	switch p {
+	case "org.freedesktop.NetworkManager.Device.Ip6Config":
+		if m.path == "/d/2" {
+			return dbus.MakeVariant(dbus.ObjectPath("/ip6/1")), nil
+		}
+		return dbus.MakeVariant(dbus.ObjectPath("/")), nil
+	case "org.freedesktop.NetworkManager.IP6Config.AddressData":
+		return dbus.MakeVariant([]map[string]dbus.Variant{
+			{"address": dbus.MakeVariant("2001:db8::10"), "prefix": dbus.MakeVariant(uint32(64))},
+			{"address": dbus.MakeVariant("fe80::1"), "prefix": dbus.MakeVariant(uint32(64))},
+		}), nil
+	}
+	return m.mockRadios.GetProperty(p)
+}
+
+func TestIP6Addresses(t *testing.T) {
+	client := NewClient(&mockIP6Config{})
+	addresses, err := client.IP6Addresses("mlan0")
+	if err != nil || len(addresses) != 2 || addresses[0] != "2001:db8::10/64" {
+		t.Errorf("Unexpected IPv6 addresses %v: %v", addresses, err)
+	}
+	addresses, err = client.IP6Addresses("wlp1s0")
+	if err != nil || len(addresses) != 0 {
+		t.Errorf("Not configured interface should have no addresses, got %v: %v", addresses, err)
+	}
+	if _, err = client.IP6Addresses("eth7"); err == nil {
+		t.Errorf("Unknown interface should fail")
+	}
+}
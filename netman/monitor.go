@@ -0,0 +1,226 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type deviceStatus struct {
+	devType uint32
+	state   uint32
+}
+
+// Monitor keeps the type and state of every NetworkManager device up to date
+// from D-Bus signals, so connection state can be checked without querying the
+// bus. It follows NetworkManager restarts and subscribes again, with backoff,
+// when the bus connection is lost
+type Monitor struct {
+	client  *Client
+	mu      sync.Mutex
+	sub     *Subscription
+	devices map[string]deviceStatus
+	changes chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewMonitor reads the current devices once and then follows their state
+// changes. Close it when no longer needed
+func (c *Client) NewMonitor() (*Monitor, error) {
+	sub, err := c.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	// own copy of the client so that the monitor goroutine does not
+	// change the test bus object under the caller's feet
+	m := &Monitor{
+		client:  &Client{dbusClient: c.dbusClient},
+		sub:     sub,
+		devices: make(map[string]deviceStatus),
+		changes: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	m.sync()
+	go m.run()
+	return m, nil
+}
+
+// Changes returns a channel that receives a value whenever a device is added,
+// removed or changes state. Notifications are coalesced, so a receiver should
+// re-read the state it is interested in
+func (m *Monitor) Changes() <-chan struct{} {
+	return m.changes
+}
+
+// Connected returns true if any ethernet or wifi device is activated
+func (m *Monitor) Connected() bool {
+	return m.activated(DeviceTypeEthernet, DeviceTypeWifi)
+}
+
+// ConnectedWifi returns true if any wifi device is activated
+func (m *Monitor) ConnectedWifi() bool {
+	return m.activated(DeviceTypeWifi)
+}
+
+// ConnectedEthernet returns true if any ethernet device is activated
+func (m *Monitor) ConnectedEthernet() bool {
+	return m.activated(DeviceTypeEthernet)
+}
+
+// Close stops following device state changes
+func (m *Monitor) Close() {
+	m.once.Do(func() {
+		close(m.done)
+	})
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sub.Close()
+}
+
+func (m *Monitor) activated(types ...uint32) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.devices {
+		if stateIn(d.devType, types) && d.state == DeviceStateActivated {
+			return true
+		}
+	}
+	return false
+}
+
+// sync reads all devices again, forgetting them if they cannot be read
+func (m *Monitor) sync() {
+	m.mu.Lock()
+	m.devices = make(map[string]deviceStatus)
+	m.mu.Unlock()
+	devices, err := m.client.GetDevices()
+	if err != nil {
+		fmt.Println("== wifi-connect: Cannot read network devices:", err)
+		return
+	}
+	for _, d := range devices {
+		m.addDevice(d)
+	}
+}
+
+func (m *Monitor) addDevice(device string) {
+	devType, err := m.client.deviceType(device)
+	if err != nil {
+		return
+	}
+	state, _ := m.client.deviceState(device)
+	m.mu.Lock()
+	m.devices[device] = deviceStatus{devType: devType, state: state}
+	m.mu.Unlock()
+}
+
+func (m *Monitor) notify() {
+	select {
+	case m.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Monitor) run() {
+	for {
+		m.mu.Lock()
+		sub := m.sub
+		m.mu.Unlock()
+		m.follow(sub)
+		if !m.resubscribe() {
+			return
+		}
+		m.sync()
+		m.notify()
+	}
+}
+
+// resubscribe subscribes again after the subscription ended because the bus
+// connection was lost. Returns false if the monitor is closed meanwhile
+func (m *Monitor) resubscribe() bool {
+	sub := subscribeAgain(m.client, m.done)
+	if sub == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.done:
+		sub.Close()
+		return false
+	default:
+	}
+	m.sub = sub
+	return true
+}
+
+// subscribeAgain subscribes with c, with backoff, until it succeeds. Returns
+// nil if done is closed meanwhile
+func subscribeAgain(c *Client, done <-chan struct{}) *Subscription {
+	delay, max := c.dbusClient.bus.backoff()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-time.After(delay):
+		}
+		sub, err := c.Subscribe()
+		if err == nil {
+			return sub
+		}
+		delay *= 2
+		if delay > max {
+			delay = max
+		}
+	}
+}
+
+// follow applies the events of sub until it ends
+func (m *Monitor) follow(sub *Subscription) {
+	for e := range sub.Events() {
+		switch e.Type {
+		case DeviceStateChanged:
+			m.mu.Lock()
+			d, ok := m.devices[e.Path]
+			if ok {
+				d.state = e.State
+				m.devices[e.Path] = d
+			}
+			m.mu.Unlock()
+			if !ok {
+				m.addDevice(e.Path)
+			}
+			m.notify()
+		case DeviceAdded:
+			m.addDevice(e.Path)
+			m.notify()
+		case DeviceRemoved:
+			m.mu.Lock()
+			delete(m.devices, e.Path)
+			m.mu.Unlock()
+			m.notify()
+		case ManagerStarted, ManagerStopped:
+			// the devices of a previous instance are gone
+			m.sync()
+			m.notify()
+		}
+	}
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// signalWindow is the number of samples a SignalMonitor keeps
+const signalWindow = 10
+
+// SignalSample is the signal of the access point a wifi interface was
+// connected to at some time
+type SignalSample struct {
+	Time  time.Time
+	Bssid string
+	// Strength is the signal quality in percent
+	Strength uint8
+	// Bitrate is in Kb/s
+	Bitrate uint32
+}
+
+// SignalMonitor samples the signal and bitrate of the access point a wifi
+// interface is connected to, in the background
+type SignalMonitor struct {
+	client   *Client
+	iface    string
+	interval time.Duration
+
+	mu      sync.Mutex
+	samples []SignalSample
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewSignalMonitor samples the connection of the wifi interface iface every
+// interval. Close it when no longer needed
+func (c *Client) NewSignalMonitor(iface string, interval time.Duration) *SignalMonitor {
+	m := &SignalMonitor{
+		client:   &Client{dbusClient: c.dbusClient},
+		iface:    iface,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// Samples returns the last samples, oldest first. Samples are only taken
+// while connected
+func (m *SignalMonitor) Samples() []SignalSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SignalSample{}, m.samples...)
+}
+
+// Reset forgets the samples taken, eg. after moving to another access point
+func (m *SignalMonitor) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = nil
+}
+
+// Close stops sampling
+func (m *SignalMonitor) Close() {
+	m.once.Do(func() {
+		close(m.done)
+	})
+}
+
+func (m *SignalMonitor) run() {
+	tick := time.NewTicker(m.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-tick.C:
+		}
+		status, err := m.client.Status(m.iface)
+		if err != nil {
+			// not connected, older samples are of another connection
+			m.Reset()
+			continue
+		}
+		m.mu.Lock()
+		m.samples = append(m.samples, SignalSample{Time: time.Now(), Bssid: status.Bssid, Strength: status.Strength, Bitrate: status.Bitrate})
+		if len(m.samples) > signalWindow {
+			m.samples = m.samples[len(m.samples)-signalWindow:]
+		}
+		m.mu.Unlock()
+	}
+}
+
+// WeakSignal returns true if the last count samples are of the same access
+// point and all below threshold percent, so that a single drop does not
+// trigger roaming
+func WeakSignal(samples []SignalSample, threshold uint8, count int) bool {
+	if count < 1 || len(samples) < count {
+		return false
+	}
+	last := samples[len(samples)-count:]
+	for _, s := range last {
+		if s.Bssid != last[0].Bssid || s.Strength >= threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// hasProfile returns true if one of profiles is for ssid
+func hasProfile(profiles []Profile, ssid string) bool {
+	for _, p := range profiles {
+		if sameSsid(p.Ssid, ssid) {
+			return true
+		}
+	}
+	return false
+}
+
+// roamCandidate returns the strongest BSS of the networks with a saved
+// profile that is at least margin percent stronger than the current access
+// point
+func roamCandidate(current *Status, ssids []SSID, profiles []Profile, margin uint8) (SSID, bool) {
+	var best SSID
+	found := false
+	for _, s := range ssids {
+		if !hasProfile(profiles, s.Ssid) {
+			continue
+		}
+		for _, b := range s.BSSes {
+			if strings.EqualFold(b.Bssid, current.Bssid) {
+				continue
+			}
+			if int(b.Strength) < int(current.Strength)+int(margin) {
+				continue
+			}
+			if !found || b.Strength > best.Strength {
+				best, found = b, true
+			}
+		}
+	}
+	return best, found
+}
+
+// FindRoamCandidate scans with the wifi interface iface and returns the
+// strongest access point of a saved network that is at least margin percent
+// stronger than the one iface is connected to. The access point may be of
+// the current network. found is false if there is none
+func (c *Client) FindRoamCandidate(iface string, margin uint8) (candidate SSID, found bool, err error) {
+	status, err := c.Status(iface)
+	if err != nil {
+		return SSID{}, false, err
+	}
+	device, err := c.DeviceByInterface(iface)
+	if err != nil {
+		return SSID{}, false, err
+	}
+	if err = c.Scan([]string{device}, ScanTimeout); err != nil {
+		fmt.Println("== wifi-connect: Error scanning:", err)
+	}
+	ssids, ap2device, _, err := c.Ssids()
+	if err != nil {
+		return SSID{}, false, err
+	}
+	profiles, err := c.SavedProfiles()
+	if err != nil {
+		return SSID{}, false, err
+	}
+	// only the access points the interface found can be joined with it
+	for i := range ssids {
+		bsses := []SSID{}
+		for _, b := range ssids[i].BSSes {
+			if ap2device[b.ApPath] == device {
+				bsses = append(bsses, b)
+			}
+		}
+		ssids[i].BSSes = bsses
+	}
+	candidate, found = roamCandidate(status, ssids, profiles, margin)
+	return candidate, found, nil
+}
+
+// Roam moves the wifi interface iface to the access point to, with the saved
+// profile of its network, and waits until connected
+func (c *Client) Roam(iface string, to SSID) error {
+	device, err := c.DeviceByInterface(iface)
+	if err != nil {
+		return err
+	}
+	profiles, err := c.ProfilesBySsid(to.Ssid)
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return opError("roam", to.Ssid, errors.New("no saved profile"))
+	}
+	profile := profiles[newestProfile(profiles)]
+
+	// subscribe before activating so that no state change is missed
+	sub, err := c.Subscribe()
+	if err != nil {
+		fmt.Println("== wifi-connect: Cannot follow connection state, polling instead:", err)
+	} else {
+		defer sub.Close()
+	}
+	obj := c.object(nmPath)
+	var active dbus.ObjectPath
+	err = obj.Call("org.freedesktop.NetworkManager.ActivateConnection", 0, dbus.ObjectPath(profile.Path),
+		dbus.ObjectPath(device), dbus.ObjectPath(to.ApPath)).Store(&active)
+	if err != nil {
+		return opError("roam", to.Ssid, err)
+	}
+	return c.waitActivation(sub, device, string(active), activateTimeout)
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import "testing"
+
+func TestWeakSignal(t *testing.T) {
+	sample := func(bssid string, strength uint8) SignalSample {
+		return SignalSample{Bssid: bssid, Strength: strength}
+	}
+	tests := []struct {
+		samples []SignalSample
+		weak    bool
+	}{
+		{nil, false},
+		{[]SignalSample{sample("a", 20), sample("a", 25)}, false},
+		{[]SignalSample{sample("a", 20), sample("a", 25), sample("a", 10)}, true},
+		{[]SignalSample{sample("a", 80), sample("a", 20), sample("a", 25), sample("a", 10)}, true},
+		// a single good sample resets the count
+		{[]SignalSample{sample("a", 20), sample("a", 40), sample("a", 10)}, false},
+		// samples of another access point do not count
+		{[]SignalSample{sample("b", 20), sample("a", 25), sample("a", 10)}, false},
+	}
+	for i, tt := range tests {
+		if weak := WeakSignal(tt.samples, 30, 3); weak != tt.weak {
+			t.Errorf("Case %d: expected weak %v", i, tt.weak)
+		}
+	}
+}
+
+func TestRoamCandidate(t *testing.T) {
+	bss := func(ssid string, bssid string, strength uint8) SSID {
+		return SSID{Ssid: ssid, Bssid: bssid, Strength: strength, ApPath: "/" + bssid}
+	}
+	network := func(bsses ...SSID) SSID {
+		s := bsses[0]
+		s.BSSes = bsses
+		return s
+	}
+	current := &Status{Ssid: "home", Bssid: "01", Strength: 20}
+	profiles := []Profile{{Ssid: "home"}, {Ssid: " cafe "}}
+
+	tests := []struct {
+		ssids []SSID
+		bssid string
+	}{
+		// not stronger by the margin
+		{[]SSID{network(bss("home", "01", 20), bss("home", "02", 30))}, ""},
+		// another BSS of the network
+		{[]SSID{network(bss("home", "02", 50), bss("home", "01", 20))}, "02"},
+		// the strongest saved network
+		{[]SSID{network(bss("cafe", "03", 70)), network(bss("home", "02", 50), bss("home", "01", 20))}, "03"},
+		// saved and scanned SSIDs are compared without surrounding spaces
+		{[]SSID{network(bss(" cafe", "03", 70))}, "03"},
+		// networks without saved profile are not joined
+		{[]SSID{network(bss("airport", "04", 90)), network(bss("home", "02", 50))}, "02"},
+	}
+	for i, tt := range tests {
+		s, found := roamCandidate(current, tt.ssids, profiles, 15)
+		if found != (tt.bssid != "") || s.Bssid != tt.bssid {
+			t.Errorf("Case %d: expected %q, got %q (found %v)", i, tt.bssid, s.Bssid, found)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// ScanTimeout is the default time to wait for scan results
+const ScanTimeout = 15 * time.Second
+
+// legacyScanWait is the time given to NetworkManager versions without the
+// LastScan property to complete a scan
+var legacyScanWait = 5 * time.Second
+
+// lastScan returns the LastScan property of passed wifi device, which is the
+// time in ms since boot (CLOCK_BOOTTIME) of the last completed scan
+func (c *Client) lastScan(device string) (int64, error) {
+	objPath := dbus.ObjectPath(device)
+	obj := c.object(objPath)
+	v, err := obj.GetProperty("org.freedesktop.NetworkManager.Device.Wireless.LastScan")
+	if err != nil {
+		return 0, opError("get LastScan", device, err)
+	}
+	last, ok := v.Value().(int64)
+	if !ok {
+		return 0, opError("get LastScan", device, fmt.Errorf("unexpected type %T", v.Value()))
+	}
+	return last, nil
+}
+
+// Scan requests an active scan on passed wifi devices and waits until all of
+// them report fresh results or timeout expires
+func (c *Client) Scan(wifiDevices []string, timeout time.Duration) error {
+	// subscribe before requesting so that no scan completion is missed
+	sub, err := c.Subscribe()
+	if err != nil {
+		fmt.Println("== wifi-connect: Cannot follow scan state, polling instead:", err)
+	} else {
+		defer sub.Close()
+	}
+
+	pending := make(map[string]int64)
+	legacy := false
+	for _, d := range wifiDevices {
+		last, err := c.lastScan(d)
+		if err != nil {
+			legacy = true
+		}
+		objPath := dbus.ObjectPath(d)
+		obj := c.object(objPath)
+		err = obj.Call("org.freedesktop.NetworkManager.Device.Wireless.RequestScan", 0, map[string]dbus.Variant{}).Err
+		if err != nil {
+			// most likely a scan is in progress or has just been done, so
+			// results will be or already are fresh
+			fmt.Printf("== wifi-connect: Scan request on %s not done: %v\n", d, err)
+			continue
+		}
+		pending[d] = last
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if legacy {
+		// no way to know when the scan is done, give it some time
+		wait := legacyScanWait
+		if timeout < wait {
+			wait = timeout
+		}
+		time.Sleep(wait)
+		return nil
+	}
+	deadline := time.After(timeout)
+	if sub == nil {
+		tick := time.NewTicker(pollInterval)
+		defer tick.Stop()
+		for len(pending) > 0 {
+			select {
+			case <-tick.C:
+				for d, before := range pending {
+					if last, err := c.lastScan(d); err == nil && last > before {
+						delete(pending, d)
+					}
+				}
+			case <-deadline:
+				return fmt.Errorf("scan timed out after %v", timeout)
+			}
+		}
+		return nil
+	}
+	for len(pending) > 0 {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return fmt.Errorf("scan state subscription closed")
+			}
+			before, waiting := pending[e.Path]
+			if !waiting || e.Type != PropertiesChanged {
+				continue
+			}
+			if v, ok := e.Properties["LastScan"]; ok {
+				if last, ok := v.Value().(int64); ok && last > before {
+					delete(pending, e.Path)
+				}
+			}
+		case <-deadline:
+			return fmt.Errorf("scan timed out after %v", timeout)
+		}
+	}
+	return nil
+}
+
+// ScannedSsids performs an active scan on all wifi devices and returns
+// the fresh SSIDs, like Ssids. If the scan fails the last results are
+// returned
+func (c *Client) ScannedSsids(timeout time.Duration) ([]SSID, map[string]string, map[string]string, error) {
+	wifiDevices, err := c.wifiDevices()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	err = c.Scan(wifiDevices, timeout)
+	if err != nil {
+		fmt.Println("== wifi-connect: Error scanning:", err)
+	}
+	return c.Ssids()
+}
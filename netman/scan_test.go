@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+func TestScan(t *testing.T) {
+	mock := &mockObj{}
+	client := NewClient(mock)
+	if err := client.Scan([]string{"/d/1"}, time.Second); err != nil {
+		t.Errorf("Unexpected error scanning: %v", err)
+	}
+	if mock.lastScan != 1 {
+		t.Errorf("Expected one scan request, got %d", mock.lastScan)
+	}
+	// a scan in progress is not an error
+	mock.scanning = true
+	if err := client.Scan([]string{"/d/1"}, time.Second); err != nil {
+		t.Errorf("Unexpected error scanning: %v", err)
+	}
+}
+
+func TestScanSignals(t *testing.T) {
+	client, signals := newSignalClient(&mockObj{})
+	lastScan := func(v int64) *dbus.Signal {
+		return &dbus.Signal{
+			Path: "/d/1",
+			Name: "org.freedesktop.DBus.Properties.PropertiesChanged",
+			Body: []interface{}{"org.freedesktop.NetworkManager.Device.Wireless", map[string]dbus.Variant{"LastScan": dbus.MakeVariant(v)}, []string{}},
+		}
+	}
+	done := make(chan error)
+	go func() {
+		done <- client.Scan([]string{"/d/1"}, time.Second)
+	}()
+	for signals.subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// mock starts with LastScan 0
+	signals.emit(lastScan(0))
+	signals.emit(lastScan(5))
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error scanning: %v", err)
+	}
+
+	go func() {
+		done <- client.Scan([]string{"/d/1"}, 50*time.Millisecond)
+	}()
+	if err := <-done; err == nil {
+		t.Errorf("Scan without results should time out")
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// Access point flags (NM_802_11_AP_FLAGS_*)
+const (
+	apFlagsPrivacy uint32 = 0x1
+)
+
+// Access point security flags (NM_802_11_AP_SEC_*)
+const (
+	apSecKeyMgmtPsk   uint32 = 0x100
+	apSecKeyMgmt8021X uint32 = 0x200
+	apSecKeyMgmtSae   uint32 = 0x400
+	apSecKeyMgmtOwe   uint32 = 0x800
+	apSecKeyMgmtOweTm uint32 = 0x1000
+	apSecEapSuiteB192 uint32 = 0x2000
+)
+
+// Security is the class of security an access point requires
+type Security int
+
+// Enum of security classes
+const (
+	SecurityOpen Security = 0 + iota
+	SecurityWep
+	SecurityWpaPsk
+	SecuritySae
+	SecurityOwe
+	SecurityEnterprise
+)
+
+func (s Security) String() string {
+	switch s {
+	case SecurityOpen:
+		return "open"
+	case SecurityWep:
+		return "wep"
+	case SecurityWpaPsk:
+		return "wpa-psk"
+	case SecuritySae:
+		return "sae"
+	case SecurityOwe:
+		return "owe"
+	case SecurityEnterprise:
+		return "enterprise"
+	}
+	return "unknown"
+}
+
+// ParseSecurity returns the security class named as returned by String
+func ParseSecurity(s string) (Security, error) {
+	for sec := SecurityOpen; sec <= SecurityEnterprise; sec++ {
+		if sec.String() == strings.ToLower(s) {
+			return sec, nil
+		}
+	}
+	return SecurityOpen, fmt.Errorf("unknown security: %q", s)
+}
+
+// NeedsPassphrase returns true if a passphrase is required to join
+func (s Security) NeedsPassphrase() bool {
+	return s == SecurityWep || s == SecurityWpaPsk || s == SecuritySae
+}
+
+// classifySecurity returns the security class from the access point Flags,
+// WpaFlags and RsnFlags properties. WPA3 transition networks are joined
+// with WPA-PSK, which is supported by more supplicants
+func classifySecurity(flags, wpaFlags, rsnFlags uint32) Security {
+	sec := wpaFlags | rsnFlags
+	switch {
+	case sec&(apSecKeyMgmt8021X|apSecEapSuiteB192) != 0:
+		return SecurityEnterprise
+	case sec&apSecKeyMgmtPsk != 0:
+		return SecurityWpaPsk
+	case sec&apSecKeyMgmtSae != 0:
+		return SecuritySae
+	case sec&(apSecKeyMgmtOwe|apSecKeyMgmtOweTm) != 0:
+		return SecurityOwe
+	case flags&apFlagsPrivacy != 0 && sec == 0:
+		return SecurityWep
+	}
+	return SecurityOpen
+}
+
+// apSecurity reads the security flags of passed access point
+func (c *Client) apSecurity(ap string) (Security, error) {
+	s, err := c.accessPoint(ap)
+	if err != nil {
+		return SecurityOpen, err
+	}
+	return s.Security, nil
+}
+
+// wepKeyType returns the NetworkManager wep-key-type for passed key:
+// 1 for hex or ascii keys, 2 for passphrases to be hashed
+func wepKeyType(key string) uint32 {
+	switch len(key) {
+	case 5, 13:
+		return 1
+	case 10, 26:
+		if _, err := hex.DecodeString(key); err == nil {
+			return 1
+		}
+	}
+	return 2
+}
+
+// securitySettings returns the 802-11-wireless-security settings section for
+// passed security class and passphrase, or nil for open networks
+func securitySettings(sec Security, p string) (map[string]dbus.Variant, error) {
+	if sec.NeedsPassphrase() && p == "" {
+		return nil, fmt.Errorf("a passphrase is required for %v networks", sec)
+	}
+	s := make(map[string]dbus.Variant)
+	switch sec {
+	case SecurityOpen:
+		return nil, nil
+	case SecurityWep:
+		s["key-mgmt"] = dbus.MakeVariant("none")
+		s["auth-alg"] = dbus.MakeVariant("open")
+		s["wep-key0"] = dbus.MakeVariant(p)
+		s["wep-key-type"] = dbus.MakeVariant(wepKeyType(p))
+	case SecurityWpaPsk:
+		s["key-mgmt"] = dbus.MakeVariant("wpa-psk")
+		s["psk"] = dbus.MakeVariant(p)
+	case SecuritySae:
+		s["key-mgmt"] = dbus.MakeVariant("sae")
+		s["psk"] = dbus.MakeVariant(p)
+	case SecurityOwe:
+		s["key-mgmt"] = dbus.MakeVariant("owe")
+	case SecurityEnterprise:
+		return nil, errors.New("enterprise networks require 802.1X credentials")
+	default:
+		return nil, fmt.Errorf("unsupported security: %v", sec)
+	}
+	return s, nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"testing"
+)
+
+func TestClassifySecurity(t *testing.T) {
+	cases := []struct {
+		flags, wpa, rsn uint32
+		expected        Security
+	}{
+		{0, 0, 0, SecurityOpen},
+		{apFlagsPrivacy, 0, 0, SecurityWep},
+		{apFlagsPrivacy, apSecKeyMgmtPsk, 0, SecurityWpaPsk},
+		{apFlagsPrivacy, 0, apSecKeyMgmtPsk, SecurityWpaPsk},
+		{apFlagsPrivacy, 0, apSecKeyMgmtSae, SecuritySae},
+		{apFlagsPrivacy, 0, apSecKeyMgmtPsk | apSecKeyMgmtSae, SecurityWpaPsk},
+		{0, 0, apSecKeyMgmtOwe, SecurityOwe},
+		{apFlagsPrivacy, 0, apSecKeyMgmt8021X, SecurityEnterprise},
+		{apFlagsPrivacy, apSecKeyMgmt8021X, 0, SecurityEnterprise},
+	}
+	for _, c := range cases {
+		if sec := classifySecurity(c.flags, c.wpa, c.rsn); sec != c.expected {
+			t.Errorf("Flags %x/%x/%x: expected %v, got %v", c.flags, c.wpa, c.rsn, c.expected, sec)
+		}
+	}
+}
+
+func TestSecuritySettings(t *testing.T) {
+	s, err := securitySettings(SecurityOpen, "")
+	if err != nil || s != nil {
+		t.Errorf("Open networks should not have security settings: %v %v", s, err)
+	}
+	if _, err := securitySettings(SecurityWpaPsk, ""); err == nil {
+		t.Errorf("WPA-PSK without passphrase should fail")
+	}
+	if _, err := securitySettings(SecurityEnterprise, "secret"); err == nil {
+		t.Errorf("Enterprise without credentials should fail")
+	}
+	s, _ = securitySettings(SecuritySae, "secret")
+	if s["key-mgmt"].Value().(string) != "sae" || s["psk"].Value().(string) != "secret" {
+		t.Errorf("Unexpected SAE settings: %v", s)
+	}
+	s, _ = securitySettings(SecurityOwe, "")
+	if s["key-mgmt"].Value().(string) != "owe" {
+		t.Errorf("Unexpected OWE settings: %v", s)
+	}
+	s, _ = securitySettings(SecurityWep, "0123456789")
+	if s["key-mgmt"].Value().(string) != "none" || s["wep-key-type"].Value().(uint32) != 1 {
+		t.Errorf("Unexpected WEP hex key settings: %v", s)
+	}
+	s, _ = securitySettings(SecurityWep, "a long passphrase")
+	if s["wep-key-type"].Value().(uint32) != 2 {
+		t.Errorf("Unexpected WEP passphrase settings: %v", s)
+	}
+}
+
+func TestConnectApSecurity(t *testing.T) {
+	ap2device := map[string]string{"/ap/1": "/d/1"}
+	ssid2ap := map[string]string{"cafe": "/ap/1"}
+	mock := &mockObj{connect: true}
+	client := NewClient(mock)
+	if err := client.ConnectAp("cafe", "", ap2device, ssid2ap, nil); err != nil {
+		t.Errorf("Unexpected error connecting to open network: %v", err)
+	}
+	if _, ok := mock.settings["802-11-wireless-security"]; ok {
+		t.Errorf("Open network should not have a security section")
+	}
+	if _, ok := mock.settings["802-11-wireless"]["security"]; ok {
+		t.Errorf("Open network should not reference a security section")
+	}
+	mock.apFlags = apFlagsPrivacy
+	mock.rsnFlags = apSecKeyMgmtSae
+	if err := client.ConnectAp("cafe", "secret", ap2device, ssid2ap, nil); err != nil {
+		t.Errorf("Unexpected error connecting to SAE network: %v", err)
+	}
+	if mock.settings["802-11-wireless-security"]["key-mgmt"].Value().(string) != "sae" {
+		t.Errorf("Expected sae key management, got: %v", mock.settings["802-11-wireless-security"])
+	}
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus"
+)
+
+const settingsPath = "/org/freedesktop/NetworkManager/Settings"
+
+// Profile is a saved 802-11-wireless connection profile
+type Profile struct {
+	Path        string
+	ID          string
+	UUID        string
+	Ssid        string
+	Hidden      bool
+	Autoconnect bool
+	// Priority is the autoconnect priority, higher is preferred
+	Priority int32
+	// Timestamp is the last time in seconds since epoch the profile was
+	// successfully activated, 0 if never
+	Timestamp uint64
+}
+
+// connectionSettings returns the settings of passed saved connection
+func (c *Client) connectionSettings(path string) (map[string]map[string]dbus.Variant, error) {
+	objPath := dbus.ObjectPath(path)
+	obj := c.object(objPath)
+	settings := make(map[string]map[string]dbus.Variant)
+	err := obj.Call("org.freedesktop.NetworkManager.Settings.Connection.GetSettings", 0).Store(&settings)
+	if err != nil {
+		return nil, opError("GetSettings", path, err)
+	}
+	return settings, nil
+}
+
+// profileFromSettings returns the profile for passed connection settings, or
+// false if they are not of a wifi connection
+func profileFromSettings(path string, settings map[string]map[string]dbus.Variant) (Profile, bool) {
+	conn := settings["connection"]
+	if t, _ := conn["type"].Value().(string); t != "802-11-wireless" {
+		return Profile{}, false
+	}
+	p := Profile{Path: path, Autoconnect: true}
+	p.ID, _ = conn["id"].Value().(string)
+	p.UUID, _ = conn["uuid"].Value().(string)
+	if v, ok := conn["autoconnect"]; ok {
+		p.Autoconnect, _ = v.Value().(bool)
+	}
+	p.Priority, _ = conn["autoconnect-priority"].Value().(int32)
+	p.Timestamp, _ = conn["timestamp"].Value().(uint64)
+	wireless := settings["802-11-wireless"]
+	ssid, _ := wireless["ssid"].Value().([]byte)
+	p.Ssid = string(ssid)
+	p.Hidden, _ = wireless["hidden"].Value().(bool)
+	return p, true
+}
+
+// SavedProfiles returns all saved wifi connection profiles
+func (c *Client) SavedProfiles() ([]Profile, error) {
+	obj := c.object(settingsPath)
+	var paths []dbus.ObjectPath
+	err := obj.Call("org.freedesktop.NetworkManager.Settings.ListConnections", 0).Store(&paths)
+	if err != nil {
+		return nil, opError("ListConnections", "", err)
+	}
+	var profiles []Profile
+	for _, path := range paths {
+		settings, err := c.connectionSettings(string(path))
+		if errors.Is(err, ErrNoBus) {
+			return nil, err
+		}
+		if err != nil {
+			fmt.Printf("== wifi-connect: Error getting settings of %s: %v\n", path, err)
+			continue
+		}
+		if p, ok := profileFromSettings(string(path), settings); ok {
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles, nil
+}
+
+// ProfilesBySsid returns the saved wifi profiles for passed ssid
+func (c *Client) ProfilesBySsid(ssid string) ([]Profile, error) {
+	profiles, err := c.SavedProfiles()
+	if err != nil {
+		return nil, err
+	}
+	var found []Profile
+	for _, p := range profiles {
+		if sameSsid(p.Ssid, ssid) {
+			found = append(found, p)
+		}
+	}
+	return found, nil
+}
+
+// deleteConnection deletes passed saved connection
+func (c *Client) deleteConnection(path string) error {
+	objPath := dbus.ObjectPath(path)
+	obj := c.object(objPath)
+	return opError("Delete", path, obj.Call("org.freedesktop.NetworkManager.Settings.Connection.Delete", 0).Err)
+}
+
+// ForgetProfile deletes all saved profiles for passed ssid and returns how
+// many were deleted
+func (c *Client) ForgetProfile(ssid string) (int, error) {
+	profiles, err := c.ProfilesBySsid(ssid)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, p := range profiles {
+		err := c.deleteConnection(p.Path)
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// mergeSecrets adds the current secrets of passed saved connection to its
+// settings, as GetSettings does not return them. Secrets already in settings
+// are kept
+func (c *Client) mergeSecrets(path string, settings map[string]map[string]dbus.Variant) {
+	obj := c.object(dbus.ObjectPath(path))
+	for _, section := range []string{"802-11-wireless-security", "802-1x"} {
+		if _, ok := settings[section]; !ok {
+			continue
+		}
+		secrets := make(map[string]map[string]dbus.Variant)
+		err := obj.Call("org.freedesktop.NetworkManager.Settings.Connection.GetSecrets", 0, section).Store(&secrets)
+		if err != nil {
+			continue
+		}
+		for k, v := range secrets[section] {
+			if _, ok := settings[section][k]; !ok {
+				settings[section][k] = v
+			}
+		}
+	}
+}
+
+// updateConnection replaces the settings of passed saved connection. Secrets
+// are not returned by GetSettings, so if keepSecrets is set the current ones
+// are merged in to not lose them
+func (c *Client) updateConnection(path string, settings map[string]map[string]dbus.Variant, keepSecrets bool) error {
+	if keepSecrets {
+		c.mergeSecrets(path, settings)
+	}
+	obj := c.object(dbus.ObjectPath(path))
+	return opError("Update", path, obj.Call("org.freedesktop.NetworkManager.Settings.Connection.Update", 0, settings).Err)
+}
+
+// SetProfilePriority sets the autoconnect priority of all saved profiles for
+// passed ssid and returns how many were updated
+func (c *Client) SetProfilePriority(ssid string, priority int32) (int, error) {
+	profiles, err := c.ProfilesBySsid(ssid)
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, p := range profiles {
+		settings, err := c.connectionSettings(p.Path)
+		if err != nil {
+			return updated, fmt.Errorf("cannot get settings of profile %s: %v", p.ID, err)
+		}
+		settings["connection"]["autoconnect-priority"] = dbus.MakeVariant(priority)
+		err = c.updateConnection(p.Path, settings, true)
+		if err != nil {
+			return updated, fmt.Errorf("cannot update profile %s: %v", p.ID, err)
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// newestProfile returns the index of the most recently activated profile
+func newestProfile(profiles []Profile) int {
+	newest := 0
+	for i, p := range profiles {
+		if p.Timestamp > profiles[newest].Timestamp {
+			newest = i
+		}
+	}
+	return newest
+}
+
+// reuseProfile looks for a saved profile for ssid, the most recently used if
+// there are several, and updates it with passed settings, so that it can be
+// activated instead of adding a new one. The other profiles for ssid are
+// deleted once it is updated. Returns the profile path, or "" if there is
+// none, and its previous settings including secrets, to restore them if the
+// new ones do not work
+func (c *Client) reuseProfile(ssid string, outer map[string]map[string]dbus.Variant) (string, map[string]map[string]dbus.Variant, error) {
+	profiles, err := c.ProfilesBySsid(ssid)
+	if err != nil || len(profiles) == 0 {
+		return "", nil, err
+	}
+	keep := newestProfile(profiles)
+	path := profiles[keep].Path
+	previous, err := c.connectionSettings(path)
+	if err != nil {
+		return "", nil, err
+	}
+	c.mergeSecrets(path, previous)
+
+	settings, err := c.connectionSettings(path)
+	if err != nil {
+		return "", nil, err
+	}
+	wireless, ok := settings["802-11-wireless"]
+	if !ok {
+		wireless = make(map[string]dbus.Variant)
+		settings["802-11-wireless"] = wireless
+	}
+	delete(wireless, "security")
+	for k, v := range outer["802-11-wireless"] {
+		wireless[k] = v
+	}
+	// other sections, like security or IP configuration, are fully
+	// replaced, eg. the network may now be open
+	for _, section := range []string{"802-11-wireless-security", "802-1x"} {
+		delete(settings, section)
+	}
+	for section, values := range outer {
+		if section != "802-11-wireless" {
+			settings[section] = values
+		}
+	}
+	err = c.updateConnection(path, settings, false)
+	if err != nil {
+		return "", nil, err
+	}
+	for i, p := range profiles {
+		if i == keep {
+			continue
+		}
+		if err := c.deleteConnection(p.Path); err != nil {
+			fmt.Printf("== wifi-connect: Error deleting duplicated profile %s: %v\n", p.ID, err)
+		}
+	}
+	return path, previous, nil
+}
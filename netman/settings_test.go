@@ -0,0 +1,227 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// mockSettings holds saved connections served by mockSettingsObj objects
+type mockSettings struct {
+	profiles map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	secrets  map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	// activated is the profile passed to ActivateConnection
+	activated dbus.ObjectPath
+	added     map[string]map[string]dbus.Variant
+}
+
+// mockSettingsObj is a mocked bus object aware of its path
+type mockSettingsObj struct {
+	settings *mockSettings
+	path     dbus.ObjectPath
+}
+
+func newMockSettings() *mockSettingsObj {
+	wifi := func(id, ssid string, priority int32) map[string]map[string]dbus.Variant {
+		return map[string]map[string]dbus.Variant{
+			"connection": {
+				"id":                   dbus.MakeVariant(id),
+				"type":                 dbus.MakeVariant("802-11-wireless"),
+				"autoconnect-priority": dbus.MakeVariant(priority),
+			},
+			"802-11-wireless":          {"ssid": dbus.MakeVariant([]byte(ssid))},
+			"802-11-wireless-security": {"key-mgmt": dbus.MakeVariant("wpa-psk")},
+		}
+	}
+	s := &mockSettings{
+		profiles: map[dbus.ObjectPath]map[string]map[string]dbus.Variant{
+			"/s/1": wifi("home", "home", 0),
+			"/s/2": wifi("home 1", "home", 0),
+			"/s/3": wifi("office", "office", 5),
+			"/s/4": {"connection": {"id": dbus.MakeVariant("eth"), "type": dbus.MakeVariant("802-3-ethernet")}},
+		},
+		secrets: map[dbus.ObjectPath]map[string]map[string]dbus.Variant{
+			"/s/3": {"802-11-wireless-security": {"psk": dbus.MakeVariant("secret")}},
+		},
+	}
+	return &mockSettingsObj{settings: s, path: settingsPath}
+}
+
+func (m *mockSettingsObj) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	return &mockSettingsObj{settings: m.settings, path: path}
+}
+
+func (m *mockSettingsObj) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	call := &dbus.Call{Path: m.path, Method: method}
+	switch method {
+	case "org.freedesktop.NetworkManager.Settings.ListConnections":
+		var paths []string
+		for p := range m.settings.profiles {
+			paths = append(paths, string(p))
+		}
+		sort.Strings(paths)
+		var objPaths []dbus.ObjectPath
+		for _, p := range paths {
+			objPaths = append(objPaths, dbus.ObjectPath(p))
+		}
+		call.Body = []interface{}{objPaths}
+	case "org.freedesktop.NetworkManager.Settings.Connection.GetSettings":
+		settings, ok := m.settings.profiles[m.path]
+		if !ok {
+			call.Err = errors.New("no such connection")
+			break
+		}
+		call.Body = []interface{}{settings}
+	case "org.freedesktop.NetworkManager.Settings.Connection.GetSecrets":
+		secrets, ok := m.settings.secrets[m.path]
+		if !ok {
+			call.Err = errors.New("no secrets")
+			break
+		}
+		call.Body = []interface{}{secrets}
+	case "org.freedesktop.NetworkManager.Settings.Connection.Update":
+		m.settings.profiles[m.path] = args[0].(map[string]map[string]dbus.Variant)
+	case "org.freedesktop.NetworkManager.Settings.Connection.Delete":
+		delete(m.settings.profiles, m.path)
+	case "org.freedesktop.NetworkManager.ActivateConnection":
+		m.settings.activated = args[0].(dbus.ObjectPath)
+	case "org.freedesktop.NetworkManager.AddAndActivateConnection":
+		m.settings.added = args[0].(map[string]map[string]dbus.Variant)
+	default:
+		call.Err = errors.New("unknown method " + method)
+	}
+	return call
+}
+
+func (m *mockSettingsObj) Go(method string, flags dbus.Flags, ch chan *dbus.Call, args ...interface{}) *dbus.Call {
+	return m.Call(method, flags, args...)
+}
+
+func (m *mockSettingsObj) GetProperty(p string) (dbus.Variant, error) {
+	if p == "org.freedesktop.NetworkManager.Device.State" {
+		return dbus.MakeVariant(DeviceStateActivated), nil
+	}
+	return dbus.MakeVariant(""), errors.New("no such property found")
+}
+
+func (m *mockSettingsObj) Destination() string {
+	return "org.freedesktop.NetworkManager"
+}
+
+func (m *mockSettingsObj) Path() dbus.ObjectPath {
+	return m.path
+}
+
+func TestSavedProfiles(t *testing.T) {
+	client := NewClient(newMockSettings())
+	profiles, err := client.SavedProfiles()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(profiles) != 3 {
+		t.Fatalf("Expected 3 wifi profiles, got: %v", profiles)
+	}
+	if profiles[2].Ssid != "office" || profiles[2].Priority != 5 || !profiles[2].Autoconnect {
+		t.Errorf("Unexpected profile: %v", profiles[2])
+	}
+	home, _ := client.ProfilesBySsid("home")
+	if len(home) != 2 {
+		t.Errorf("Expected 2 home profiles, got: %v", home)
+	}
+}
+
+func TestForgetProfile(t *testing.T) {
+	mock := newMockSettings()
+	client := NewClient(mock)
+	n, err := client.ForgetProfile("home")
+	if err != nil || n != 2 {
+		t.Errorf("Expected 2 profiles deleted, got %d, %v", n, err)
+	}
+	if _, ok := mock.settings.profiles["/s/1"]; ok {
+		t.Errorf("Profile should have been deleted")
+	}
+	n, _ = client.ForgetProfile("unknown")
+	if n != 0 {
+		t.Errorf("No profile should have been deleted")
+	}
+}
+
+func TestSetProfilePriority(t *testing.T) {
+	mock := newMockSettings()
+	client := NewClient(mock)
+	n, err := client.SetProfilePriority("office", 10)
+	if err != nil || n != 1 {
+		t.Errorf("Expected 1 profile updated, got %d, %v", n, err)
+	}
+	settings := mock.settings.profiles["/s/3"]
+	if settings["connection"]["autoconnect-priority"].Value().(int32) != 10 {
+		t.Errorf("Priority not updated: %v", settings["connection"])
+	}
+	if settings["802-11-wireless-security"]["psk"].Value().(string) != "secret" {
+		t.Errorf("Secrets should have been kept on update: %v", settings["802-11-wireless-security"])
+	}
+}
+
+func TestFailedConnectionDeleted(t *testing.T) {
+	activateTimeout = 50 * time.Millisecond
+	defer func() { activateTimeout = 20 * time.Second }()
+	mock := &mockObj{}
+	client := NewClient(mock)
+	err := client.ConnectAp("ssid0", "secret", map[string]string{"/ap/1": "/d/1"}, map[string]string{"ssid0": "/ap/1"}, nil)
+	if err == nil {
+		t.Errorf("Connection should have failed")
+	}
+	if len(mock.deleted) != 1 {
+		t.Errorf("Failed connection should have been deleted")
+	}
+}
+
+func TestConnectReusesProfile(t *testing.T) {
+	mock := newMockSettings()
+	client := NewClient(mock)
+	ap2device := map[string]string{"/ap/1": "/d/1"}
+	err := client.ConnectAp("home", "newsecret", ap2device, map[string]string{"home": "/ap/1"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mock.settings.activated != "/s/1" || mock.settings.added != nil {
+		t.Errorf("Existing profile should have been activated, got: %v", mock.settings.activated)
+	}
+	// home had two profiles, only the reused one is left
+	if _, ok := mock.settings.profiles["/s/2"]; ok {
+		t.Errorf("Duplicated profile should have been deleted")
+	}
+	security := mock.settings.profiles["/s/1"]["802-11-wireless-security"]
+	if security["psk"].Value().(string) != "newsecret" {
+		t.Errorf("Profile secrets should have been updated: %v", security)
+	}
+
+	// a network without profile gets a new one
+	err = client.ConnectAp("cafe", "", ap2device, map[string]string{"cafe": "/ap/1"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mock.settings.added == nil {
+		t.Errorf("A new profile should have been added")
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package netman
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/godbus/dbus"
+)
+
+// IPStatus is the IP configuration a device got
+type IPStatus struct {
+	// Addresses are in CIDR notation, eg. 192.168.1.10/24
+	Addresses []string `json:"addresses"`
+	Gateway   string   `json:"gateway,omitempty"`
+	DNS       []string `json:"dns"`
+}
+
+// Status describes the active connection of a wifi interface
+type Status struct {
+	Interface string `json:"interface"`
+	Ssid      string `json:"ssid"`
+	Bssid     string `json:"bssid"`
+	// Strength is the signal quality in percent
+	Strength uint8 `json:"signal"`
+	// Bitrate is the current bitrate in Kb/s
+	Bitrate uint32 `json:"bitrate"`
+	// Frequency is in MHz
+	Frequency uint32 `json:"frequency"`
+	// Security is the name of the security of the access point
+	Security string   `json:"security"`
+	IPv4     IPStatus `json:"ipv4"`
+	IPv6     IPStatus `json:"ipv6"`
+}
+
+// addressData returns the AddressData of an IP4Config or IP6Config in CIDR
+// notation
+func addressData(v dbus.Variant) ([]string, error) {
+	data, ok := v.Value().([]map[string]dbus.Variant)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T", v.Value())
+	}
+	addresses := []string{}
+	for _, a := range data {
+		address, _ := a["address"].Value().(string)
+		prefix, _ := a["prefix"].Value().(uint32)
+		addresses = append(addresses, fmt.Sprintf("%s/%d", address, prefix))
+	}
+	return addresses, nil
+}
+
+// ipStatus returns the IP configuration at config, an IP6Config if v6 is
+// true else an IP4Config
+func (c *Client) ipStatus(config dbus.ObjectPath, v6 bool) (IPStatus, error) {
+	status := IPStatus{Addresses: []string{}, DNS: []string{}}
+	if config == "" || config == "/" {
+		// not configured
+		return status, nil
+	}
+	iface := "org.freedesktop.NetworkManager.IP4Config"
+	if v6 {
+		iface = "org.freedesktop.NetworkManager.IP6Config"
+	}
+	obj := c.object(config)
+	props := make(map[string]dbus.Variant)
+	err := obj.Call("org.freedesktop.DBus.Properties.GetAll", 0, iface).Store(&props)
+	if err != nil {
+		return status, opError("get IP configuration", string(config), err)
+	}
+	if v, ok := props["AddressData"]; ok {
+		status.Addresses, err = addressData(v)
+		if err != nil {
+			return status, opError("get AddressData", string(config), err)
+		}
+	}
+	status.Gateway, _ = props["Gateway"].Value().(string)
+	if v6 {
+		// IPv6 name servers are raw addresses
+		servers, _ := props["Nameservers"].Value().([][]byte)
+		for _, s := range servers {
+			if len(s) == net.IPv6len {
+				status.DNS = append(status.DNS, net.IP(s).String())
+			}
+		}
+		return status, nil
+	}
+	servers, _ := props["NameserverData"].Value().([]map[string]dbus.Variant)
+	for _, s := range servers {
+		if address, ok := s["address"].Value().(string); ok {
+			status.DNS = append(status.DNS, address)
+		}
+	}
+	return status, nil
+}
+
+// devicePath returns the object path property name of device, "/" if unset
+func (c *Client) devicePath(device string, name string) (dbus.ObjectPath, error) {
+	obj := c.object(dbus.ObjectPath(device))
+	v, err := obj.GetProperty(name)
+	if err != nil {
+		return "/", opError("get "+name, device, err)
+	}
+	path, ok := v.Value().(dbus.ObjectPath)
+	if !ok {
+		return "/", opError("get "+name, device, fmt.Errorf("unexpected type %T", v.Value()))
+	}
+	return path, nil
+}
+
+// Status returns the active connection of the wifi interface iface: the
+// access point it is connected to and the IP configuration it got.
+// ErrNotConnected is returned if it is not connected
+func (c *Client) Status(iface string) (*Status, error) {
+	device, err := c.DeviceByInterface(iface)
+	if err != nil {
+		return nil, err
+	}
+	ap, err := c.devicePath(device, "org.freedesktop.NetworkManager.Device.Wireless.ActiveAccessPoint")
+	if err != nil {
+		return nil, err
+	}
+	if ap == "/" {
+		return nil, opError("get status", iface, ErrNotConnected)
+	}
+	s, err := c.accessPoint(string(ap))
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{
+		Interface: iface,
+		Ssid:      s.Ssid,
+		Bssid:     s.Bssid,
+		Strength:  s.Strength,
+		Frequency: s.Frequency,
+		Security:  s.Security.String(),
+	}
+	obj := c.object(dbus.ObjectPath(device))
+	if v, err := obj.GetProperty("org.freedesktop.NetworkManager.Device.Wireless.Bitrate"); err == nil {
+		status.Bitrate, _ = v.Value().(uint32)
+	}
+	for _, ip := range []struct {
+		property string
+		status   *IPStatus
+		v6       bool
+	}{
+		{"org.freedesktop.NetworkManager.Device.Ip4Config", &status.IPv4, false},
+		{"org.freedesktop.NetworkManager.Device.Ip6Config", &status.IPv6, true},
+	} {
+		config, err := c.devicePath(device, ip.property)
+		if err != nil {
+			return nil, err
+		}
+		if *ip.status, err = c.ipStatus(config, ip.v6); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
@@ -19,12 +19,15 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"text/template"
+	"time"
 
+	"github.com/CanonicalLtd/UCWifiConnect/backend"
 	"github.com/CanonicalLtd/UCWifiConnect/netman"
 	"github.com/CanonicalLtd/UCWifiConnect/utils"
 	"github.com/CanonicalLtd/UCWifiConnect/wifiap"
@@ -36,15 +39,31 @@ const (
 	operationalTemplatePath = "/templates/operational.html"
 )
 
+// CheckpointTimeout is the time after which NetworkManager restores the
+// network configuration saved before a connection attempt, unless the daemon
+// settled the attempt. It covers a captive portal login
+var CheckpointTimeout = 15 * time.Minute
+
 // ResourcesPath absolute path to web static resources
 var ResourcesPath = filepath.Join(os.Getenv("SNAP"), "static")
 
+// WifiInterface is the wifi interface managed to connect to external APs
+var WifiInterface = "wlan0"
+
 // Data interface representing any data included in a template
 type Data interface{}
 
 // SsidsData dynamic data to fulfill the SSIDs page template
 type SsidsData struct {
 	Ssids []string
+	// Error is why the last connection attempt failed, if it did
+	Error string
+	// PortalLogin is true if the upstream network requires a captive
+	// portal login
+	PortalLogin bool
+	// SecretRequests are the requests for secrets of NetworkManager that
+	// were not answered
+	SecretRequests []netman.SecretRequest
 }
 
 // ConnectingData dynamic data to fulfill the connect result page template
@@ -79,48 +98,102 @@ func ManagementHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := SsidsData{Ssids: ssids}
+	data := SsidsData{Ssids: ssids, Error: utils.ConnectError.Read(), PortalLogin: PortalURL() != "", SecretRequests: secretRequests()}
 
 	// parse template
 	execTemplate(w, managementTemplatePath, data)
 }
 
-// ConnectHandler reads form got ssid and password and tries to connect to that network
-func ConnectHandler(w http.ResponseWriter, r *http.Request) {
-
+// connectForm returns the network to connect to described by the form of r
+func connectForm(r *http.Request) (backend.Network, error) {
 	r.ParseForm()
 
-	ssids := r.Form["ssid"]
-	if len(ssids) == 0 {
-		fmt.Println("== wifi-connect/handler: SSID not available")
+	ssid := r.Form.Get("ssid")
+	if ssid == "" {
+		return backend.Network{}, errors.New("no SSID provided")
+	}
+	pwd := r.Form.Get("pwd")
+
+	// a manually typed SSID is not in the scan results and has to be
+	// joined as a hidden network
+	hidden := r.Form.Get("hidden") == "true"
+	sec := netman.SecurityWpaPsk
+	if hidden {
+		var err error
+		sec, err = netman.ParseSecurity(r.Form.Get("security"))
+		if err != nil {
+			return backend.Network{}, err
+		}
+	}
+
+	// optional static addressing, for networks without DHCP or IPv6 only
+	ipv4, err := netman.ParseIPConfig(r.Form.Get("ipv4method"), r.Form.Get("ipv4address"),
+		r.Form.Get("ipv4gateway"), r.Form.Get("ipv4dns"), r.Form.Get("ipv4search"))
+	if err != nil {
+		return backend.Network{}, err
+	}
+	ipv6, err := netman.ParseIPv6Config(r.Form.Get("ipv6method"), r.Form.Get("ipv6address"),
+		r.Form.Get("ipv6gateway"), r.Form.Get("ipv6dns"), "", r.Form.Get("ipv6privacy"))
+	if err != nil {
+		return backend.Network{}, err
+	}
+	cfg := &netman.NetworkConfig{IPv4: ipv4, IPv6: ipv6}
+	return backend.Network{Ssid: ssid, Passphrase: pwd, Hidden: hidden, Security: sec, Config: cfg}, nil
+}
+
+// ConnectHandler reads form got ssid and password and tries to connect to that network
+func ConnectHandler(w http.ResponseWriter, r *http.Request) {
+	network, err := connectForm(r)
+	if err != nil {
+		// nothing was touched yet, show the management page again with
+		// the reason
+		fmt.Printf("== wifi-connect/handler: %v\n", err)
+		if err := utils.ConnectError.Write(fmt.Sprintf("Cannot connect: %v", err)); err != nil {
+			fmt.Printf("== wifi-connect/handler: Error storing connection result: %v\n", err)
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	ssid := ssids[0]
+	ssid := network.Ssid
 
 	data := ConnectingData{ssid}
 	execTemplate(w, connectingTemplatePath, data)
 
-	pwd := ""
-	pwds := r.Form["pwd"]
-	if len(pwds) > 0 {
-		pwd = pwds[0]
-	}
-
 	fmt.Printf("== wifi-connect/handler: Connecting to %v\n", ssid)
 
+	b := backend.Default()
+	// the daemon restores the configuration from before the attempt, AP
+	// included, if the attempt does not reach the internet. NetworkManager
+	// does after CheckpointTimeout if the daemon could not
+	if nm, ok := b.(*backend.NM); ok {
+		checkpoint, err := nm.Client().CheckpointCreate(nil, CheckpointTimeout)
+		if err != nil {
+			fmt.Printf("== wifi-connect/handler: Cannot save the network configuration: %v\n", err)
+		} else if err = utils.Checkpoint.Write(checkpoint); err != nil {
+			fmt.Printf("== wifi-connect/handler: Error storing the checkpoint: %v\n", err)
+		}
+	}
+
 	cw := wifiap.DefaultClient()
 	cw.Disable()
 
 	//connect
-	c := netman.DefaultClient()
-	c.SetIfaceManaged("wlan0", true, c.GetWifiDevices(c.GetDevices()))
-	_, ap2device, ssid2ap := c.Ssids()
-
-	err := c.ConnectAp(ssid, pwd, ap2device, ssid2ap)
+	err = b.SetManaged(WifiInterface, true)
+	if err != nil {
+		fmt.Printf("== wifi-connect/handler: Error managing %s: %v\n", WifiInterface, err)
+	}
+	err = b.Connect(WifiInterface, network)
 
-	//TODO signal user in portal on failure to connect
+	// the reason is shown in the portal once management mode is back
+	reason := ""
 	if err != nil {
-		fmt.Printf("== wifi-connect/handler: Failed connecting to %v.\n", ssid)
+		fmt.Printf("== wifi-connect/handler: Failed connecting to %v: %v\n", ssid, err)
+		reason = fmt.Sprintf("Cannot connect to %s: %s", ssid, failureMessage(err))
+	} else if network.Passphrase != "" {
+		saveNetworkSecrets(ssid)
+	}
+	if err := utils.ConnectError.Write(reason); err != nil {
+		fmt.Printf("== wifi-connect/handler: Error storing connection result: %v\n", err)
 	}
 
 	//remove flag file so that daemon starts checking state
@@ -129,12 +202,42 @@ func ConnectHandler(w http.ResponseWriter, r *http.Request) {
 	utils.RemoveFlagFile(waitPath)
 }
 
+// failureMessage returns a message for the portal user explaining err
+func failureMessage(err error) string {
+	// the sentinel errors may be wrapped with the failed operation
+	switch {
+	case errors.Is(err, netman.ErrBadSecret):
+		return "the passphrase is wrong."
+	case errors.Is(err, netman.ErrNoSecrets):
+		return "the network requires a passphrase."
+	case errors.Is(err, netman.ErrSsidNotFound):
+		return "the network is out of range."
+	case errors.Is(err, netman.ErrDhcpFailed):
+		return "the network did not assign an IP address."
+	case errors.Is(err, netman.ErrTimeout):
+		return "the network did not answer in time."
+	}
+	return "unknown error."
+}
+
 type disconnectData struct {
+	// Status is the active wifi connection, nil if unknown
+	Status *netman.Status
+	// PortalLogin is true if the network requires a captive portal login
+	PortalLogin bool
 }
 
 // OperationalHandler display Opertational mode page
 func OperationalHandler(w http.ResponseWriter, r *http.Request) {
-	data := disconnectData{}
+	data := disconnectData{PortalLogin: PortalURL() != ""}
+	// the connection details are only known with NetworkManager
+	if nm, ok := backend.Default().(*backend.NM); ok {
+		status, err := nm.Client().Status(WifiInterface)
+		if err != nil {
+			fmt.Printf("== wifi-connect/handler: Error getting connection status: %v\n", err)
+		}
+		data.Status = status
+	}
 	execTemplate(w, operationalTemplatePath, data)
 }
 
@@ -165,6 +268,8 @@ func HashItHandler(w http.ResponseWriter, r *http.Request) {
 
 // DisconnectHandler allows user to disconnect from external AP
 func DisconnectHandler(w http.ResponseWriter, r *http.Request) {
-	c := netman.DefaultClient()
-	c.DisconnectWifi(c.GetWifiDevices(c.GetDevices()))
+	err := backend.Default().Disconnect(WifiInterface)
+	if err != nil {
+		fmt.Printf("== wifi-connect/handler: Error disconnecting: %v\n", err)
+	}
 }
@@ -23,6 +23,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/CanonicalLtd/UCWifiConnect/netman"
 	"github.com/CanonicalLtd/UCWifiConnect/utils"
 )
 
@@ -62,6 +63,33 @@ func TestConnectHandler(t *testing.T) {
 	}
 }
 
+func TestConnectHandlerInvalidForm(t *testing.T) {
+
+	ResourcesPath = "../static"
+	utils.ConnectError.SetPath("/tmp/connect-error")
+	defer utils.ConnectError.Write("")
+
+	for _, form := range []string{
+		"",
+		"ssid=hidden&hidden=true&security=bogus",
+		"ssid=home&ipv4method=manual&ipv4address=bogus",
+		"ssid=home&ipv6method=manual&ipv6address=bogus",
+	} {
+		utils.ConnectError.Write("")
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/connect", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		http.HandlerFunc(ConnectHandler).ServeHTTP(w, r)
+
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+			t.Errorf("Form %q: expected a redirect to the management page, got %d", form, w.Code)
+		}
+		if utils.ConnectError.Read() == "" {
+			t.Errorf("Form %q: the reason should have been stored", form)
+		}
+	}
+}
+
 func TestInvalidTemplateHandler(t *testing.T) {
 
 	ResourcesPath = "/invalidpath"
@@ -143,3 +171,13 @@ func TestReadEmptySsidsFile(t *testing.T) {
 		t.Error("Expected 0 elements in csv record")
 	}
 }
+
+func TestFailureMessage(t *testing.T) {
+	wrapped := &netman.Error{Op: "ActivateConnection", Path: "/", Err: netman.ErrBadSecret}
+	if m := failureMessage(wrapped); m != "the passphrase is wrong." {
+		t.Errorf("Wrapped errors should be recognized, got %q", m)
+	}
+	if m := failureMessage(netman.ErrSsidNotFound); m != "the network is out of range." {
+		t.Errorf("Unexpected message %q", m)
+	}
+}
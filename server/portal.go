@@ -0,0 +1,321 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/UCWifiConnect/wifiap"
+)
+
+// portalPrefix is the path the captive portal pages are proxied under, as
+// portalPrefix + scheme/host/path
+const portalPrefix = "/portal-login/"
+
+var portalMu sync.Mutex
+var portalURL string
+
+// apSubnet is the network of the setup AP, looked up when proxying starts
+var apSubnet *net.IPNet
+
+// portalHosts are the hosts the proxy forwards to: the one of the login page
+// and the ones its answers link or redirect to
+var portalHosts map[string]bool
+
+// SetPortalURL sets the login page of the captive portal of the upstream
+// network, so that the portals proxy it to the user. An empty URL stops
+// proxying
+func SetPortalURL(u string) {
+	var subnet *net.IPNet
+	if u != "" {
+		subnet = setupApSubnet()
+	}
+	portalMu.Lock()
+	defer portalMu.Unlock()
+	portalURL = u
+	apSubnet = subnet
+	portalHosts = nil
+	if login, err := url.Parse(u); err == nil && login.Host != "" {
+		portalHosts = map[string]bool{strings.ToLower(login.Host): true}
+	}
+}
+
+// allowPortalHost lets the proxy forward to host, the captive portal
+// linked or redirected there
+func allowPortalHost(host string) {
+	portalMu.Lock()
+	defer portalMu.Unlock()
+	if portalHosts != nil && host != "" {
+		portalHosts[strings.ToLower(host)] = true
+	}
+}
+
+// portalHostAllowed returns true if the proxy forwards to host
+func portalHostAllowed(host string) bool {
+	portalMu.Lock()
+	defer portalMu.Unlock()
+	return portalHosts[strings.ToLower(host)]
+}
+
+// PortalURL returns the login page of the upstream captive portal, empty if
+// there is none
+func PortalURL() string {
+	portalMu.Lock()
+	defer portalMu.Unlock()
+	return portalURL
+}
+
+// proxyPath returns the path u is proxied at
+func proxyPath(u *url.URL) string {
+	p := portalPrefix + u.Scheme + "/" + u.Host + u.EscapedPath()
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p
+}
+
+// upstreamURL returns the captive portal URL a proxied request is for
+func upstreamURL(r *http.Request) (*url.URL, error) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), portalPrefix), "/", 3)
+	if len(parts) < 2 || (parts[0] != "http" && parts[0] != "https") || parts[1] == "" {
+		return nil, fmt.Errorf("invalid captive portal path: %s", r.URL.Path)
+	}
+	path := "/"
+	if len(parts) == 3 {
+		path += parts[2]
+	}
+	u, err := url.Parse(parts[0] + "://" + parts[1] + path)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = r.URL.RawQuery
+	return u, nil
+}
+
+// rewriteLink returns the proxied link for link, found in a page at base,
+// and lets the proxy forward to its host. Relative links already resolve
+// under the proxied path, others are kept
+func rewriteLink(base *url.URL, link string) string {
+	lower := strings.ToLower(link)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") &&
+		(!strings.HasPrefix(link, "/") || strings.HasPrefix(link, "//")) {
+		return link
+	}
+	u, err := base.Parse(link)
+	if err != nil {
+		return link
+	}
+	allowPortalHost(u.Host)
+	return proxyPath(u)
+}
+
+// linkAttr matches the page attributes holding links
+var linkAttr = regexp.MustCompile(`(?i)\b(href|src|action)(\s*=\s*)(["'])([^"']*)(["'])`)
+
+// rewriteCookie keeps a cookie set by the captive portal under its proxied
+// path, the browser only knows the portal host
+func rewriteCookie(base *url.URL, cookie string) string {
+	attrs := strings.Split(cookie, ";")
+	kept := attrs[:1]
+	for _, a := range attrs[1:] {
+		kv := strings.SplitN(a, "=", 2)
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "domain", "secure":
+			continue
+		case "path":
+			if len(kv) == 2 {
+				a = " Path=" + portalPrefix + base.Scheme + "/" + base.Host + strings.TrimSpace(kv[1])
+			}
+		}
+		kept = append(kept, a)
+	}
+	return strings.Join(kept, ";")
+}
+
+// rewriteResponse makes the links, redirects and cookies of a captive portal
+// answer point to the proxy
+func rewriteResponse(resp *http.Response) error {
+	base := resp.Request.URL
+	if loc, err := resp.Location(); err == nil {
+		resp.Header.Set("Location", rewriteLink(base, loc.String()))
+	}
+	cookies := resp.Header["Set-Cookie"]
+	for i, c := range cookies {
+		cookies[i] = rewriteCookie(base, c)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	body = linkAttr.ReplaceAllFunc(body, func(m []byte) []byte {
+		s := linkAttr.FindSubmatch(m)
+		return bytes.Join([][]byte{s[1], s[2], s[3], []byte(rewriteLink(base, string(s[4]))), s[5]}, nil)
+	})
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// portalAddressAllowed returns true if the proxy may connect to ip. Captive
+// portals are on the upstream network, often a private one, but the device
+// itself and the setup AP network are not reachable through the proxy
+var portalAddressAllowed = func(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	portalMu.Lock()
+	subnet := apSubnet
+	portalMu.Unlock()
+	if subnet != nil && subnet.Contains(ip) {
+		return false
+	}
+	return !ownAddress(ip)
+}
+
+// ownAddress returns true if ip is an address of the device
+func ownAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// setupApSubnet returns the network of the setup AP from the wifi-ap
+// configuration, nil if unknown
+var setupApSubnet = func() *net.IPNet {
+	config, err := wifiap.DefaultClient().Show()
+	if err != nil {
+		return nil
+	}
+	address, _ := config["wifi.address"].(string)
+	netmask, _ := config["wifi.netmask"].(string)
+	ip := net.ParseIP(address).To4()
+	mask := net.ParseIP(netmask).To4()
+	if ip == nil || mask == nil {
+		return nil
+	}
+	return &net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+}
+
+var portalDialer = &net.Dialer{Timeout: 30 * time.Second}
+
+// dialPortal connects to addr if all the addresses its host resolves to are
+// allowed. The checked address is dialed, so that the name cannot resolve to
+// another one meanwhile
+func dialPortal(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+	for _, ip := range ips {
+		if !portalAddressAllowed(ip.IP) {
+			return nil, fmt.Errorf("captive portal address %s of %s is not allowed", ip.IP, host)
+		}
+	}
+	err = errors.New("no address reachable")
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = portalDialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// portalProxy forwards requests to the captive portal host they are for
+var portalProxy = &httputil.ReverseProxy{
+	Transport: &http.Transport{
+		DialContext:         dialPortal,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	Director: func(r *http.Request) {
+		target, err := upstreamURL(r)
+		if err != nil {
+			return
+		}
+		r.URL = target
+		r.Host = target.Host
+		// the answers are rewritten, they must not be compressed
+		r.Header.Del("Accept-Encoding")
+		r.Header.Del("Referer")
+	},
+	ModifyResponse: rewriteResponse,
+}
+
+// PortalLoginHandler proxies the login pages of the captive portal of the
+// upstream network, so that the user accepts its terms for the device.
+// Without path it redirects to the login page
+func PortalLoginHandler(w http.ResponseWriter, r *http.Request) {
+	login := PortalURL()
+	if login == "" {
+		http.Error(w, "The network does not require a login", http.StatusNotFound)
+		return
+	}
+	if r.URL.Path == portalPrefix {
+		u, err := url.Parse(login)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, proxyPath(u), http.StatusFound)
+		return
+	}
+	target, err := upstreamURL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// only the captive portal is proxied, not any host the user names
+	if !portalHostAllowed(target.Host) {
+		http.Error(w, "Not a captive portal host", http.StatusForbidden)
+		return
+	}
+	portalProxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newCaptivePortal returns a captive portal redirecting to its login page
+func newCaptivePortal() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<form action="` + r.URL.Query().Get("to") + `"></form>`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login?from=probe", http.StatusFound)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Path: "/", Domain: "portal.example"})
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/terms">terms</a><form action="http://` + r.Host + `/accept"></form><img src="logo.png">`))
+	})
+	return httptest.NewServer(mux)
+}
+
+// allowLoopback lets the proxy connect to test servers until the returned
+// function is called
+func allowLoopback() func() {
+	allowed := portalAddressAllowed
+	portalAddressAllowed = func(ip net.IP) bool { return true }
+	return func() { portalAddressAllowed = allowed }
+}
+
+func TestPortalLoginHandler(t *testing.T) {
+	defer SetPortalURL("")
+	defer allowLoopback()()
+	router := operationalHandler()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/portal-login/", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d without captive portal, got: %d", http.StatusNotFound, w.Code)
+	}
+
+	ts := newCaptivePortal()
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+	SetPortalURL(ts.URL + "/login")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/portal-login/", nil)
+	router.ServeHTTP(w, r)
+	if loc := w.Header().Get("Location"); loc != "/portal-login/http/"+host+"/login" {
+		t.Errorf("Unexpected redirect to the login page: %q", loc)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/portal-login/http/"+host+"/login", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got: %d", http.StatusOK, w.Code)
+	}
+	body := w.Body.String()
+	for _, link := range []string{`href="/portal-login/http/` + host + `/terms"`, `action="/portal-login/http/` + host + `/accept"`, `src="logo.png"`} {
+		if !strings.Contains(body, link) {
+			t.Errorf("%s expected in the page: %s", link, body)
+		}
+	}
+	cookie := w.Header().Get("Set-Cookie")
+	if strings.Contains(strings.ToLower(cookie), "domain") || !strings.Contains(cookie, "Path=/portal-login/http/"+host+"/") {
+		t.Errorf("Cookie should be kept under the proxied path: %s", cookie)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/portal-login/http/"+host+"/", nil)
+	router.ServeHTTP(w, r)
+	if loc := w.Header().Get("Location"); loc != "/portal-login/http/"+host+"/login?from=probe" {
+		t.Errorf("Redirect should be proxied, got: %q", loc)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/portal-login/ftp/"+host+"/", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid path, got: %d", http.StatusBadRequest, w.Code)
+	}
+
+	// other hosts are only proxied once the captive portal redirected there
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("welcome"))
+	}))
+	defer other.Close()
+	otherHost := strings.TrimPrefix(other.URL, "http://")
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/portal-login/http/"+otherHost+"/welcome", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for another host, got: %d", http.StatusForbidden, w.Code)
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/portal-login/http/"+host+"/away?to="+other.URL+"/welcome", nil)
+	router.ServeHTTP(w, r)
+	if loc := w.Header().Get("Location"); loc != "/portal-login/http/"+otherHost+"/welcome" {
+		t.Errorf("Redirect should be proxied, got: %q", loc)
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/portal-login/http/"+otherHost+"/welcome", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "welcome" {
+		t.Errorf("The host redirected to should be proxied, got: %d", w.Code)
+	}
+}
+
+func TestPortalLinkedHosts(t *testing.T) {
+	defer SetPortalURL("")
+	defer allowLoopback()()
+	router := operationalHandler()
+	ts := newCaptivePortal()
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+	SetPortalURL(ts.URL + "/login")
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("accepted"))
+	}))
+	defer other.Close()
+	otherHost := strings.TrimPrefix(other.URL, "http://")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/portal-login/http/"+otherHost+"/accept", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for another host, got: %d", http.StatusForbidden, w.Code)
+	}
+
+	// the login form posts to another host
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/portal-login/http/"+host+"/link?to="+other.URL+"/accept", nil)
+	router.ServeHTTP(w, r)
+	if link := `action="/portal-login/http/` + otherHost + `/accept"`; !strings.Contains(w.Body.String(), link) {
+		t.Errorf("%s expected in the page: %s", link, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/portal-login/http/"+otherHost+"/accept", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "accepted" {
+		t.Errorf("The host the page links to should be proxied, got: %d", w.Code)
+	}
+}
+
+func TestPortalLocalAddresses(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.60.0/24")
+	portalMu.Lock()
+	apSubnet = subnet
+	portalMu.Unlock()
+	for _, tc := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"169.254.1.1", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		// the setup AP network
+		{"10.0.60.1", false},
+		// captive portals of private upstream networks
+		{"10.0.0.1", true},
+		{"192.168.1.1", true},
+		{"91.189.89.10", true},
+		{"2001:4860:4860::8888", true},
+	} {
+		if allowed := portalAddressAllowed(net.ParseIP(tc.ip)); allowed != tc.allowed {
+			t.Errorf("Address %s allowed: %v", tc.ip, allowed)
+		}
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && portalAddressAllowed(n.IP) {
+			t.Errorf("Own address %s should not be allowed", n.IP)
+		}
+	}
+
+	defer SetPortalURL("")
+	ts := newCaptivePortal()
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+	SetPortalURL(ts.URL + "/login")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/portal-login/http/"+host+"/login", nil)
+	operationalHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("The device itself should not be reachable, got: %d", w.Code)
+	}
+}
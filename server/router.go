@@ -31,6 +31,8 @@ func managementHandler() *mux.Router {
 	router.HandleFunc("/", ManagementHandler).Methods("GET")
 	router.HandleFunc("/connect", ConnectHandler).Methods("POST")
 	router.HandleFunc("/hashit", HashItHandler).Methods("POST")
+	router.HandleFunc("/secrets", SecretsHandler).Methods("POST")
+	router.PathPrefix(portalPrefix).HandlerFunc(PortalLoginHandler)
 
 	// Resources path
 	fs := http.StripPrefix("/static/", http.FileServer(http.Dir(ResourcesPath)))
@@ -46,6 +48,7 @@ func operationalHandler() *mux.Router {
 	router.HandleFunc("/", OperationalHandler).Methods("GET")
 	router.HandleFunc("/disconnect", DisconnectHandler).Methods("GET")
 	router.HandleFunc("/hashit", HashItHandler).Methods("POST")
+	router.PathPrefix(portalPrefix).HandlerFunc(PortalLoginHandler)
 
 	// Resources path
 	fs := http.StripPrefix("/static/", http.FileServer(http.Dir(ResourcesPath)))
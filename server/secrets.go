@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/CanonicalLtd/UCWifiConnect/netman"
+)
+
+// SecretAgent lists and answers the secret requests of NetworkManager, as
+// netman.SecretAgent does
+type SecretAgent interface {
+	Requests() []netman.SecretRequest
+	Answer(id string, secrets map[string]string) error
+	SaveNetworkSecrets(ssid string) error
+}
+
+var agentMu sync.Mutex
+var secretAgent SecretAgent
+
+// SetSecretAgent sets the agent whose unanswered requests the management
+// portal shows, nil for none
+func SetSecretAgent(a SecretAgent) {
+	agentMu.Lock()
+	defer agentMu.Unlock()
+	secretAgent = a
+}
+
+// secretRequests returns the unanswered secret requests, if any
+func secretRequests() []netman.SecretRequest {
+	agentMu.Lock()
+	defer agentMu.Unlock()
+	if secretAgent == nil {
+		return nil
+	}
+	return secretAgent.Requests()
+}
+
+// saveNetworkSecrets stores the secrets ssid was joined with from the
+// portal, so that the agent answers NetworkManager with them later
+func saveNetworkSecrets(ssid string) {
+	agentMu.Lock()
+	a := secretAgent
+	agentMu.Unlock()
+	if a == nil {
+		return
+	}
+	if err := a.SaveNetworkSecrets(ssid); err != nil {
+		fmt.Printf("== wifi-connect/handler: Cannot store the secrets of %s: %v\n", ssid, err)
+	}
+}
+
+// SecretsHandler answers a secret request with the secrets the user
+// supplied, then goes back to the management page
+func SecretsHandler(w http.ResponseWriter, r *http.Request) {
+	agentMu.Lock()
+	a := secretAgent
+	agentMu.Unlock()
+	if a == nil {
+		http.Error(w, "No secret agent", http.StatusNotFound)
+		return
+	}
+	r.ParseForm()
+	id := r.Form.Get("id")
+	secrets := make(map[string]string)
+	for _, req := range a.Requests() {
+		if req.ID != id {
+			continue
+		}
+		for _, k := range req.Keys {
+			secrets[k] = r.Form.Get(k)
+		}
+	}
+	if err := a.Answer(id, secrets); err != nil {
+		fmt.Printf("== wifi-connect/handler: Cannot answer secret request %q: %v\n", id, err)
+		code := http.StatusInternalServerError
+		if err == netman.ErrNoSecretRequest {
+			code = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/UCWifiConnect/netman"
+	"github.com/CanonicalLtd/UCWifiConnect/utils"
+)
+
+type mockAgent struct {
+	requests []netman.SecretRequest
+	answered map[string]map[string]string
+	saved    []string
+}
+
+func (a *mockAgent) SaveNetworkSecrets(ssid string) error {
+	a.saved = append(a.saved, ssid)
+	return nil
+}
+
+func (a *mockAgent) Requests() []netman.SecretRequest {
+	return a.requests
+}
+
+func (a *mockAgent) Answer(id string, secrets map[string]string) error {
+	for _, r := range a.requests {
+		if r.ID == id {
+			a.answered[id] = secrets
+			return nil
+		}
+	}
+	return netman.ErrNoSecretRequest
+}
+
+func TestSecretsHandler(t *testing.T) {
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/secrets", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		http.HandlerFunc(SecretsHandler).ServeHTTP(w, r)
+		return w
+	}
+
+	SetSecretAgent(nil)
+	if w := post(url.Values{"id": {"1"}}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d without agent, got: %d", http.StatusNotFound, w.Code)
+	}
+
+	a := &mockAgent{
+		requests: []netman.SecretRequest{{ID: "1", Network: "office", Setting: "802-1x", Keys: []string{"password"}}},
+		answered: make(map[string]map[string]string),
+	}
+	SetSecretAgent(a)
+	defer SetSecretAgent(nil)
+
+	// the management page shows the request
+	ResourcesPath = "../static"
+	utils.SetSsidsFile("../static/tests/ssids")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	http.HandlerFunc(ManagementHandler).ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "Secrets required for office") {
+		t.Errorf("Management page should show the secret request")
+	}
+
+	w = post(url.Values{"id": {"1"}, "password": {"otp123"}, "psk": {"ignored"}})
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("Expected status %d, got: %d", http.StatusSeeOther, w.Code)
+	}
+	if len(a.answered["1"]) != 1 || a.answered["1"]["password"] != "otp123" {
+		t.Errorf("Unexpected answer: %v", a.answered)
+	}
+	if w = post(url.Values{"id": {"2"}}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown request, got: %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -22,8 +22,10 @@ import (
 	"os"
 	"time"
 
+	"github.com/CanonicalLtd/UCWifiConnect/backend"
 	"github.com/CanonicalLtd/UCWifiConnect/daemon"
 	"github.com/CanonicalLtd/UCWifiConnect/netman"
+	"github.com/CanonicalLtd/UCWifiConnect/server"
 	"github.com/CanonicalLtd/UCWifiConnect/utils"
 	"github.com/CanonicalLtd/UCWifiConnect/wifiap"
 )
@@ -36,11 +38,37 @@ func main() {
 	client.SetWaitFlagPath(os.Getenv("SNAP_COMMON") + "/startingApConnect")
 	client.SetManualFlagPath(os.Getenv("SNAP_COMMON") + "/manualMode")
 
-	c := netman.DefaultClient()
+	b := backend.Default()
+	fmt.Println("== wifi-connect: Using network backend", b.Name())
 	cw := wifiap.DefaultClient()
 
+	// follow device state changes from NetworkManager signals, so that link
+	// changes are handled immediately and without querying every device
+	var monitor *netman.Monitor
+	var changes <-chan struct{}
+	if nm, ok := b.(*backend.NM); ok {
+		var err error
+		monitor, err = nm.Client().NewMonitor()
+		if err != nil {
+			fmt.Println("== wifi-connect: Cannot monitor NetworkManager, polling instead:", err)
+		} else {
+			changes = monitor.Changes()
+		}
+		// answer NetworkManager secret requests, eg. when re-keying, from
+		// the credential store, the portal shows the unanswered ones
+		agent, err := nm.Client().NewSecretAgent(utils.CredentialStore{})
+		if err != nil {
+			fmt.Println("== wifi-connect: Cannot register the secret agent:", err)
+		} else {
+			server.SetSecretAgent(agent)
+		}
+	}
+
 	client.ManagementServerDown()
 	client.OperationalServerDown()
+	lastReason := ""
+	// connectivity is checked again as soon as the links come up
+	linksUp := false
 
 	for {
 		if first {
@@ -50,16 +78,24 @@ func main() {
 			first = false
 			//clean start require wifi AP down so we can get SSIDs
 			cw.Disable()
+			if err := client.SelectInterface(b, cw); err != nil {
+				fmt.Println("== wifi-connect: No wifi interface to put up the AP on:", err)
+			}
+			client.LoadPolicy()
 			//remove previous State flags
 			utils.RemoveFlagFile(client.GetWaitFlagPath())
 			utils.RemoveFlagFile(client.GetManualFlagPath())
-			//TODO only wait if wlan0 is managed
+			//TODO only wait if the wifi interface is managed
 			//wait time period (TBD) on first run to allow wifi connections
 			time.Sleep(40000 * time.Millisecond)
 		}
 
-		// wait 5 seconds on each iter
-		time.Sleep(5000 * time.Millisecond)
+		// wait for a device state change, or at most 5 seconds as flag
+		// files are not signaled
+		select {
+		case <-changes:
+		case <-time.After(5000 * time.Millisecond):
+		}
 
 		// loop without action if in manual mode
 		if client.ManualMode() {
@@ -85,17 +121,65 @@ func main() {
 			continue
 		}
 
-		// if an external wifi connection, we are in Operational mode
-		// and we stay here until there is an external wifi connection
-		if c.ConnectedWifi(c.GetWifiDevices(c.GetDevices())) {
+		// if the connections satisfy the operating policy, we are in
+		// Operational mode and we stay here while they do
+		operational, reason := client.Operational(links(b, monitor, client.GetInterface()))
+		// behind a captive portal connectivity is checked until the login
+		force := !linksUp || client.GetState() == daemon.PORTAL
+		linksUp = operational
+		// associating is not enough, the internet must be reachable
+		if operational {
+			online, why := client.Online(b, force)
+			if !online && force {
+				// shown in the portal once management mode is back
+				err := utils.ConnectError.Write(fmt.Sprintf("The connection is up but %s.", why))
+				if err != nil {
+					fmt.Println("== wifi-connect: Error storing connectivity result:", err)
+				}
+			}
+			operational = online
+			reason = reason + ", " + why
+		}
+		if reason != lastReason {
+			fmt.Printf("== wifi-connect: %s (policy %s)\n", reason, client.GetPolicy())
+			lastReason = reason
+		}
+
+		// stay connected so that the user logs in to the captive portal
+		// through the operational portal, the next checks decide
+		if !operational && linksUp && client.GetConnectivity() == netman.ConnectivityPortal && client.WaitPortalLogin() {
+			client.SetState(daemon.PORTAL)
+			if client.GetPreviousState() != daemon.PORTAL {
+				fmt.Println("== wifi-connect: entering PORTAL mode")
+			}
+			if client.GetPreviousState() == daemon.MANAGING {
+				client.ManagementServerDown()
+				if wifiUp, _ := cw.Enabled(); wifiUp {
+					cw.Disable()
+				}
+			}
+			client.OperationalServerUp()
+			continue
+		}
+		if client.GetState() == daemon.PORTAL {
+			client.PortalDone()
+		}
+		if operational {
+			client.SettleCheckpoint(b, true)
 			client.SetState(daemon.OPERATING)
 			if client.GetPreviousState() != daemon.OPERATING {
-				fmt.Println("== wifi-connect: entering OPERATIONAL mode")
+				fmt.Printf("== wifi-connect: entering OPERATIONAL mode (connectivity %v)\n", client.GetConnectivity())
 			}
 			if client.GetPreviousState() == daemon.MANAGING {
 				client.ManagementServerDown()
+				// eg. ethernet got connected while the AP was up
+				if wifiUp, _ := cw.Enabled(); wifiUp {
+					cw.Disable()
+				}
 			}
 			client.OperationalServerUp()
+			// move to a stronger network when the signal stays weak
+			client.Roam(b)
 			continue
 		}
 
@@ -104,9 +188,18 @@ func main() {
 			fmt.Println("== wifi-connect: entering MANAGEMENT mode")
 		}
 
-		// if wlan0 managed, set Unmanaged so that we can bring up wifi-ap
+		// a connection attempt that did not reach the internet is undone
+		// before the AP is put up again
+		client.SettleCheckpoint(b, false)
+
+		// the AP is only put up on an AP capable interface
+		if client.GetInterface() == "" {
+			continue
+		}
+
+		// if the wifi interface is managed, set Unmanaged so that we can bring up wifi-ap
 		// properly
-		client.Unmanage(c)
+		client.Unmanage(b)
 
 		//wifi-ap UP?
 		wifiUp, err := cw.Enabled()
@@ -117,18 +210,54 @@ func main() {
 
 		//get ssids if wifi-ap Down
 		if !wifiUp {
-			found := client.ScanSsids(utils.SsidsFile, c)
-			client.Unmanage(c)
+			client.SetScanExclusion(b, cw)
+			found := client.ScanSsids(utils.SsidsFile, b)
+			client.Unmanage(b)
 			if !found {
 				fmt.Println("== wifi-connect: Looping.")
 				continue
 			}
 			fmt.Println("== wifi-connect: starting wifi-ap")
 			cw.Enable()
-			if client.GetPreviousState() == daemon.OPERATING {
+			if client.GetPreviousState() == daemon.OPERATING || client.GetPreviousState() == daemon.PORTAL {
 				client.OperationalServerDown()
 			}
 			client.ManagementServerUp()
 		}
 	}
 }
+
+// clientLinks queries the network backend for the connected links
+type clientLinks struct {
+	b     backend.Backend
+	iface string
+}
+
+func (l clientLinks) ConnectedWifi() bool {
+	return l.b.Connected(l.iface)
+}
+
+// ConnectedEthernet is only known with NetworkManager, wpa_supplicant does
+// not handle ethernet
+func (l clientLinks) ConnectedEthernet() bool {
+	if nm, ok := l.b.(*backend.NM); ok {
+		c := nm.Client()
+		devices, err := c.GetDevices()
+		if err != nil {
+			fmt.Println("== wifi-connect: Error getting devices:", err)
+			return false
+		}
+		connected, _ := c.ConnectedEthernet(devices)
+		return connected
+	}
+	return false
+}
+
+// links uses the monitor device states if available, else queries the
+// network backend
+func links(b backend.Backend, monitor *netman.Monitor, iface string) daemon.Links {
+	if monitor != nil {
+		return monitor
+	}
+	return clientLinks{b, iface}
+}
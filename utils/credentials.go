@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CredentialsFile path to the file storing the network secrets supplied
+// through the portal, readable by root only
+var CredentialsFile = filepath.Join(os.Getenv("SNAP_COMMON"), "credentials.json")
+
+// SetCredentialsFile sets the CredentialsFile var
+func SetCredentialsFile(p string) {
+	CredentialsFile = p
+}
+
+var credentialsMu sync.Mutex
+
+// credentials are the secrets by network, then setting, then key
+type credentials map[string]map[string]map[string]string
+
+// readCredentials returns the stored credentials, none if the file does not
+// exist. A file that cannot be read is an error, so that it is not
+// overwritten and its credentials lost
+func readCredentials() (credentials, error) {
+	c := make(credentials)
+	b, err := ioutil.ReadFile(CredentialsFile)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("corrupt credentials file %s: %v", CredentialsFile, err)
+	}
+	return c, nil
+}
+
+func writeCredentials(c credentials) error {
+	if len(c) == 0 {
+		err := os.Remove(CredentialsFile)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(CredentialsFile, b, 0600); err != nil {
+		return err
+	}
+	// the mode is only set when the file is created
+	return os.Chmod(CredentialsFile, 0600)
+}
+
+// CredentialStore keeps network secrets in the CredentialsFile, so that
+// NetworkManager gets them when it asks wifi-connect
+type CredentialStore struct{}
+
+// Secrets returns the stored secrets of setting for network, nil if none
+func (CredentialStore) Secrets(network string, setting string) map[string]string {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	c, err := readCredentials()
+	if err != nil {
+		fmt.Println("== wifi-connect: Error reading credentials:", err)
+		return nil
+	}
+	return c[network][setting]
+}
+
+// SaveSecrets stores the secrets of setting for network, replacing the
+// previous ones
+func (CredentialStore) SaveSecrets(network string, setting string, secrets map[string]string) error {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	c, err := readCredentials()
+	if err != nil {
+		return err
+	}
+	if c[network] == nil {
+		c[network] = make(map[string]map[string]string)
+	}
+	c[network][setting] = secrets
+	return writeCredentials(c)
+}
+
+// DeleteSecrets forgets all secrets of network
+func (CredentialStore) DeleteSecrets(network string) error {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	c, err := readCredentials()
+	if err != nil {
+		return err
+	}
+	if _, ok := c[network]; !ok {
+		return nil
+	}
+	delete(c, network)
+	return writeCredentials(c)
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Setting is a value stored in a file, eg. a knob set from the command line.
+// An empty value is not stored, meaning the default is used
+type Setting struct {
+	// Path is the file storing the value
+	Path string
+}
+
+// NewSetting returns the setting stored in the file name of SNAP_COMMON
+func NewSetting(name string) *Setting {
+	return &Setting{Path: filepath.Join(os.Getenv("SNAP_COMMON"), name)}
+}
+
+// SetPath sets the file storing the value
+func (s *Setting) SetPath(p string) {
+	s.Path = p
+}
+
+// Write stores value. An empty value removes the file
+func (s *Setting) Write(value string) error {
+	if value == "" {
+		err := os.Remove(s.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(s.Path, []byte(value), 0644)
+}
+
+// Read returns the stored value, if any
+func (s *Setting) Read() string {
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// WriteList stores values, one per line. An empty list removes the file
+func (s *Setting) WriteList(values []string) error {
+	return s.Write(strings.Join(values, "\n"))
+}
+
+// ReadList returns the values stored one per line, if any
+func (s *Setting) ReadList() []string {
+	var values []string
+	for _, v := range strings.Split(s.Read(), "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// ConnectError is why the last connection attempt from the portal failed
+var ConnectError = NewSetting("connect-error")
+
+// Checkpoint is the NetworkManager checkpoint taken before the last
+// connection attempt, until the attempt is settled
+var Checkpoint = NewSetting("checkpoint")
+
+// Interface is the configured wifi interface, empty to auto-select one
+var Interface = NewSetting("interface")
+
+// Policy is the configured operating policy, empty for the default one
+var Policy = NewSetting("policy")
+
+// Backend is the configured network backend, empty to select one
+// automatically
+var Backend = NewSetting("backend")
+
+// ConnectivityCheck is how connectivity is checked, "off" or a probe URL,
+// empty for the default check
+var ConnectivityCheck = NewSetting("connectivity-check")
+
+// RoamThreshold is the signal quality in percent below which a stronger
+// network is looked for, or "off", empty for the default threshold
+var RoamThreshold = NewSetting("roam-threshold")
+
+// DenyList holds the SSID patterns to hide from scan results, one per line
+var DenyList = NewSetting("deny-list")
@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -48,3 +50,79 @@ func TestMatchingHash(t *testing.T) {
 		t.Error("Error MatchingHash should have matched but did not")
 	}
 }
+
+func TestSettings(t *testing.T) {
+	for _, tc := range []struct {
+		setting *Setting
+		value   string
+	}{
+		{ConnectError, "wrong passphrase"},
+		{Checkpoint, "/org/freedesktop/NetworkManager/Checkpoint/1"},
+		{Interface, "wlp1s0"},
+		{Policy, "ethernet"},
+		{Backend, "wpa-supplicant"},
+		{ConnectivityCheck, "off"},
+		{RoamThreshold, "40"},
+	} {
+		tc.setting.SetPath(filepath.Join("/tmp", filepath.Base(tc.setting.Path)))
+		if err := tc.setting.Write(tc.value); err != nil {
+			t.Errorf("Error %v writing %s", err, tc.setting.Path)
+		}
+		if v := tc.setting.Read(); v != tc.value {
+			t.Errorf("Error reading %s returned %q", tc.setting.Path, v)
+		}
+		if err := tc.setting.Write(""); err != nil {
+			t.Errorf("Error %v clearing %s", err, tc.setting.Path)
+		}
+		if v := tc.setting.Read(); v != "" {
+			t.Errorf("Error %s should be empty after clearing, got %q", tc.setting.Path, v)
+		}
+	}
+}
+
+func TestSettingList(t *testing.T) {
+	DenyList.SetPath("/tmp/deny-list")
+	if err := DenyList.WriteList([]string{"Ubuntu-*", "setup"}); err != nil {
+		t.Errorf("Error %v WriteList returned an error", err)
+	}
+	if patterns := DenyList.ReadList(); len(patterns) != 2 || patterns[0] != "Ubuntu-*" || patterns[1] != "setup" {
+		t.Errorf("Error ReadList returned %v", patterns)
+	}
+	DenyList.WriteList(nil)
+	if patterns := DenyList.ReadList(); len(patterns) != 0 {
+		t.Errorf("Error ReadList should be empty after clearing, got %v", patterns)
+	}
+}
+
+func TestCredentialStore(t *testing.T) {
+	SetCredentialsFile("/tmp/credentials.json")
+	s := CredentialStore{}
+	if err := s.SaveSecrets("home", "802-11-wireless-security", map[string]string{"psk": "secret12"}); err != nil {
+		t.Errorf("Error %v SaveSecrets returned an error", err)
+	}
+	if secrets := s.Secrets("home", "802-11-wireless-security"); secrets["psk"] != "secret12" {
+		t.Errorf("Error Secrets returned %v", secrets)
+	}
+	if info, err := os.Stat(CredentialsFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Error credentials should only be readable by their owner: %v", err)
+	}
+	if secrets := s.Secrets("cafe", "802-11-wireless-security"); secrets != nil {
+		t.Errorf("Error Secrets of an unknown network returned %v", secrets)
+	}
+	if err := s.DeleteSecrets("home"); err != nil {
+		t.Errorf("Error %v DeleteSecrets returned an error", err)
+	}
+	if secrets := s.Secrets("home", "802-11-wireless-security"); secrets != nil {
+		t.Errorf("Error Secrets should be empty after deleting, got %v", secrets)
+	}
+
+	// a corrupt file is not overwritten
+	ioutil.WriteFile(CredentialsFile, []byte("{corrupt"), 0600)
+	defer os.Remove(CredentialsFile)
+	if err := s.SaveSecrets("home", "802-11-wireless-security", map[string]string{"psk": "secret12"}); err == nil {
+		t.Errorf("Error SaveSecrets should fail with a corrupt file")
+	}
+	if b, _ := ioutil.ReadFile(CredentialsFile); string(b) != "{corrupt" {
+		t.Errorf("Error the corrupt file should have been kept, got %q", b)
+	}
+}
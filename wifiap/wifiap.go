@@ -161,6 +161,26 @@ func (client *Client) SetSsid(ssid string) error {
 	return nil
 }
 
+// SetInterface sets the wifi interface the ap is hosted on
+func (client *Client) SetInterface(iface string) error {
+	params := map[string]string{"wifi.interface": iface}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("wifi-ap set interface operation failed when marshalling input parameters: %q", err)
+	}
+
+	response, err := client.restClient.sendHTTPRequest(defaultServiceURI(), "POST", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("wifi-ap set interface operation failed: %q", err)
+	}
+
+	if response.StatusCode != http.StatusOK || response.Status != http.StatusText(http.StatusOK) {
+		return fmt.Errorf("Failed to set configuration, service returned: %d (%s)", response.StatusCode, response.Status)
+	}
+
+	return nil
+}
+
 // SetPassphrase sets the credential to access the wifi ap
 func (client *Client) SetPassphrase(passphrase string) error {
 	if len(passphrase) < 13 {
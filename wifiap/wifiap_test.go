@@ -387,6 +387,44 @@ func TestSetSsid(t *testing.T) {
 	}
 }
 
+// Testing SetInterface(iface)
+type mockTransportSetInterface struct{}
+
+func (mock *mockTransportSetInterface) Do(req *http.Request) (*http.Response, error) {
+
+	url := req.URL.String()
+	if url != "http://unix/v1/configuration" {
+		return nil, fmt.Errorf("Not valid request URL: %v", url)
+	}
+
+	if req.Method != "POST" {
+		return nil, fmt.Errorf("Method is not valid. Expected POST, got %v", req.Method)
+	}
+
+	err := validateHeaders(map[string]string{"wifi.interface": "mlan0"}, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rawBody := `{"result":{},"status":"OK","status-code":200,"type":"sync"}`
+
+	response := http.Response{
+		StatusCode: 200,
+		Status:     "200 OK",
+		Body:       ioutil.NopCloser(strings.NewReader(rawBody)),
+	}
+
+	return &response, nil
+}
+
+func TestSetInterface(t *testing.T) {
+	client := NewClient(&mockTransportSetInterface{})
+	err := client.SetInterface("mlan0")
+	if err != nil {
+		t.Errorf("Failed to set interface: %v\n", err)
+	}
+}
+
 // Testing SetPassphrase(passphrase)
 type mockTransportSetPassphrase struct{}
 